@@ -2,51 +2,256 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/canbo-x/port-service/internal/application/filereader"
+	"github.com/canbo-x/port-service/internal/application/replication"
 	"github.com/canbo-x/port-service/internal/application/service"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
 	"github.com/canbo-x/port-service/internal/infrastructure/httpserver"
+	"github.com/canbo-x/port-service/internal/infrastructure/repository/bolt"
+	"github.com/canbo-x/port-service/internal/infrastructure/repository/cache"
 	"github.com/canbo-x/port-service/internal/infrastructure/repository/memory"
+	"github.com/canbo-x/port-service/internal/infrastructure/repository/sqlite"
 	"github.com/canbo-x/port-service/internal/util"
 )
 
+// Supported values of the storage flag.
+const (
+	storageMemory  = "memory"
+	storageSharded = "sharded"
+	storageBolt    = "bolt"
+	storageSQLite  = "sqlite"
+)
+
+// defaultSource is the file the ports of the default dataset are imported from.
+// this should be a config value
+const defaultSource = "ports.json"
+
+// storageOptions holds the flags of the storages, shared by all the datasets.
+type storageOptions struct {
+	storage          string
+	shards           int
+	boltFile         string
+	sqliteFile       string
+	snapshotDir      string
+	snapshotInterval time.Duration
+	snapshotKeep     int
+	walFile          string
+	walSync          bool
+	historyLimit     int
+	cacheSize        int
+	cacheTTL         time.Duration
+	cacheNegativeTTL time.Duration
+	changeLogSize    int
+}
+
+// openedRepository is the repository of a dataset opened by openRepository.
+type openedRepository struct {
+	repo repository.PortRepository
+
+	// restored is set when the storage already holds the ports, so the file is not imported.
+	restored bool
+
+	// newRepository provides the empty repository a reload imports the file into,
+	// it is nil when the storage cannot reload.
+	newRepository service.RepositoryFactory
+}
+
+// datasetSource is a dataset name and the files its ports are imported from, joined with a "+".
+type datasetSource struct {
+	name     string
+	filename string
+}
+
+// servedDataset is a dataset together with what the startup, the reloads and the purges need.
+type servedDataset struct {
+	name       string
+	service    *service.PortService
+	portSource source.PortSource
+	opened     openedRepository
+}
+
 func main() {
+	// Command line flags
+	// A proper configuration file logic is not implemented yet
+	opts := storageOptions{}
+	flag.StringVar(&opts.storage, "storage", storageMemory, "storage of the ports: memory, sharded, bolt or sqlite")
+	flag.IntVar(&opts.shards, "shards", memory.DefaultShardCount, "number of shards used by the sharded storage")
+	flag.StringVar(&opts.boltFile, "bolt-file", "ports.db", "path of the bbolt file used by the bolt storage")
+	flag.StringVar(&opts.sqliteFile, "sqlite-file", "ports.sqlite", "path of the database used by the sqlite storage")
+	flag.StringVar(&opts.snapshotDir, "snapshot-dir", "",
+		"directory of the database snapshots, snapshots are disabled when empty")
+	flag.DurationVar(&opts.snapshotInterval, "snapshot-interval", 5*time.Minute, "interval between two snapshots")
+	flag.IntVar(&opts.snapshotKeep, "snapshot-keep", 3, "number of snapshots to keep")
+	flag.StringVar(&opts.walFile, "wal-file", "",
+		"path of the write-ahead log, the log is disabled when empty")
+	flag.BoolVar(&opts.walSync, "wal-sync", true, "flush the write-ahead log to the disk on every write")
+	flag.IntVar(&opts.historyLimit, "history-limit", memory.DefaultHistoryLimit,
+		"number of revisions kept per port by the memory storages, the history is disabled when zero")
+	reloadMinCount := flag.Int("reload-min-count", 1, "least number of ports a reloaded file must have")
+	reloadMaxDelta := flag.Float64("reload-max-delta", 0.5,
+		"largest relative change of the number of ports a reload can make, the check is disabled when zero")
+	fullSync := flag.Bool("full-sync", false,
+		"import the files on every start, even into a storage holding the ports, and delete the ports missing from them")
+	syncMaxRemove := flag.Float64("sync-max-remove", 0.1,
+		"largest share of the ports a full-sync import can delete, the check is disabled when zero")
+	readerOpts := filereader.Options{BufferSize: 1024}
+	flag.BoolVar(&readerOpts.SkipBroken, "skip-broken", true,
+		"skip the ports of the files which cannot be decoded, instead of failing the import")
+	flag.StringVar(&readerOpts.Format, "format", filereader.FormatAuto,
+		"format of the files: json, ndjson, csv or unlocode, picked from the extension of every file when empty, "+
+			"a file prefixed with its format, such as unlocode:CodeListPart1.csv, is read in this format")
+	csvColumns := flag.String("csv-columns", "",
+		"comma separated header=field pairs mapping the columns of the csv files, such as \"Port Code=id,Latitude=lat\", "+
+			"the headers are the field names when empty")
+	csvComma := flag.String("csv-comma", ",", "delimiter of the columns of the csv files")
+	flag.StringVar(&readerOpts.CSV.ListSeparator, "csv-list-separator", filereader.DefaultCSVListSeparator,
+		"separator of the alias, regions and unlocs values in the csv files")
+	tombstoneRetention := flag.Duration("tombstone-retention", 30*24*time.Hour,
+		"time the deleted ports are kept as tombstones, the tombstones are never purged when zero")
+	purgeInterval := flag.Duration("purge-interval", time.Hour, "interval between two purges of the tombstones")
+	flag.IntVar(&opts.cacheSize, "cache-size", cache.DefaultSize,
+		"number of ports cached in front of the bolt and sqlite storages, the cache is disabled when zero")
+	flag.DurationVar(&opts.cacheTTL, "cache-ttl", cache.DefaultTTL, "time a port stays cached")
+	flag.DurationVar(&opts.cacheNegativeTTL, "cache-negative-ttl", cache.DefaultNegativeTTL,
+		"time an unknown port id stays cached as not found")
+	datasetsFlag := flag.String("datasets", "",
+		"additional datasets as comma separated name=file pairs, such as unlocode=unlocode.json, "+
+			"the files of a dataset imported in order are joined with a +, such as internal=base.json+fixes.json")
+	flag.IntVar(&opts.changeLogSize, "change-log-size", service.DefaultChangeLogSize,
+		"number of changes of the memory storage kept for the followers")
+	leader := flag.String("leader", "",
+		"URL of the leader to follow, such as http://leader:8080, the instance is a leader when empty")
+	followWait := flag.Duration("follow-wait", replication.DefaultWait,
+		"time a request of a follower waits on the leader for new changes")
+	flag.Parse()
+
+	sources, err := parseDatasets(*datasetsFlag)
+	if err != nil {
+		log.Printf("Invalid datasets: %v", err)
+		return
+	}
+
+	readerOpts.CSV.Columns, err = filereader.ParseCSVColumns(*csvColumns)
+	if err != nil {
+		log.Printf("Invalid csv columns: %v", err)
+		return
+	}
+	if utf8.RuneCountInString(*csvComma) != 1 {
+		log.Printf("Invalid csv comma: %q is not a single character", *csvComma)
+		return
+	}
+	readerOpts.CSV.Comma, _ = utf8.DecodeRuneInString(*csvComma)
+
+	// A follower starts from a snapshot of its leader, and its storage does not number the changes itself
+	if *leader != "" && (opts.storage != storageMemory || opts.snapshotDir != "" || opts.walFile != "") {
+		log.Printf("A follower needs the memory storage without snapshots nor write-ahead log")
+		return
+	}
+
 	// Create a context with a cancel function
 	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 
 	// Set up a signal handler to listen for signals
 	util.SetupSignalHandler(ctx, cancel)
 
+	// SIGHUP must not terminate the process during the startup, the reloads are enabled once it is done
+	enableReload := util.SetupReloadHandler(ctx)
+
 	// Shutdown the server gracefully
 	defer util.GracefulShutdown()
 
-	// Initialize the repository and the service
-	portRepository := memory.NewMemoryDB()
-	portService := service.NewPortService(portRepository)
+	// The storages are closed once the background work using them, such as the snapshots, is done
+	var closers []func()
+	defer func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}()
+	background := &sync.WaitGroup{}
+	defer stopBackground(cancel, background)
 
-	// Initialize the file reader
-	fileReader := &filereader.JSONFileReader{
-		// this should be a config value
-		Filename:   "ports.json",
-		BufferSize: 1024,
+	// Initialize the repository and the service of every dataset, the default one first
+	datasets, served, err := openDatasets(ctx, opts, sources, readerOpts, background, &closers)
+	if err != nil {
+		log.Printf("Error while opening the datasets: %v", err)
+		return
+	}
+
+	// A reload loads the files into new repositories, which the storages bound to their files cannot provide,
+	// so they do not reload at all rather than change the current ports in place
+	reloadable := true
+	for _, dataset := range served {
+		reloadable = reloadable && dataset.opened.newRepository != nil
+	}
+	if !reloadable {
+		log.Printf("Reloading on SIGHUP is disabled: the %s storage cannot load the files into a new repository "+
+			"with its current options", opts.storage)
 	}
 
 	wg := &sync.WaitGroup{}
 
 	// Initialize the HTTP server
-	httpServer := httpserver.NewHTTPServer(portService)
+	httpServer := httpserver.NewHTTPServer(datasets)
 
-	// Start the file processing
-	wg.Add(1)
-	go func() {
-		if err := portService.StoreFileToDB(ctx, fileReader, wg); err != nil {
-			log.Printf("Error while processing file: %v", err)
-			cancel()
+	// Follow the leader with every dataset, which replaces the file processing
+	if *leader != "" {
+		for _, dataset := range served {
+			leaderURL := strings.TrimSuffix(*leader, "/")
+			if dataset.name != service.DefaultDataset {
+				leaderURL += "/datasets/" + dataset.name
+			}
+
+			follower := replication.NewFollower(leaderURL, dataset.service, replication.Options{Wait: *followWait})
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				follower.Run(ctx)
+			}()
 		}
-	}()
+	}
+
+	// Start the file processing of the datasets unless their data was restored by the storage or is followed.
+	// A full sync processes the files in any case, and deletes the restored ports missing from them.
+	syncOpts := service.SyncOptions{MaxRemove: *syncMaxRemove}
+	for _, dataset := range served {
+		if (dataset.opened.restored && !*fullSync) || *leader != "" {
+			continue
+		}
+
+		dataset := dataset
+		wg.Add(1)
+		go func() {
+			if !*fullSync {
+				if err := dataset.service.StoreFileToDB(ctx, dataset.portSource, wg); err != nil {
+					log.Printf("Error while processing file of the %s dataset: %v", dataset.name, err)
+					cancel()
+				}
+				return
+			}
+
+			defer wg.Done()
+			_, err := dataset.service.SyncFileToDB(ctx, dataset.portSource, syncOpts)
+			if errors.Is(err, errs.ErrSyncRejected) {
+				// The imported ports are served, only the deletes were skipped
+				log.Printf("Ports of the %s dataset were not deleted: %v", dataset.name, err)
+			} else if err != nil {
+				log.Printf("Error while processing file of the %s dataset: %v", dataset.name, err)
+				cancel()
+			}
+		}()
+	}
 	// Wait for the processing to be finished
 	wg.Wait()
 
@@ -71,6 +276,283 @@ func main() {
 	// Wait for the server to start
 	wg.Wait()
 
+	// Record the changes for the followers, which can also be followers themselves
+	if opts.storage == storageMemory {
+		for _, dataset := range served {
+			dataset := dataset
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				dataset.service.RunChangeLog(ctx, opts.changeLogSize)
+			}()
+		}
+	}
+
+	// Purge the tombstones kept longer than the retention, the followers get the purges of their leader
+	if *tombstoneRetention > 0 && *leader == "" {
+		for _, dataset := range served {
+			dataset := dataset
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				dataset.service.RunPurge(ctx, *tombstoneRetention, *purgeInterval)
+			}()
+		}
+	}
+
+	// Reload the files on SIGHUP into new repositories, the server keeps serving the current ports meanwhile
+	enableReload(func() {
+		if !reloadable {
+			log.Printf("Ignoring SIGHUP: reloading is not supported by the %s storage with its current options",
+				opts.storage)
+			return
+		}
+
+		reloadOpts := service.ReloadOptions{MinCount: *reloadMinCount, MaxDelta: *reloadMaxDelta}
+		for _, dataset := range served {
+			err := dataset.service.Reload(ctx, dataset.portSource, dataset.opened.newRepository, reloadOpts)
+			if err != nil {
+				log.Printf("Error while reloading file of the %s dataset: %v", dataset.name, err)
+			}
+		}
+	})
+
 	// Wait for the context to be canceled
 	<-ctx.Done()
 }
+
+// stopBackground cancels the context of the background work, such as the snapshots, then waits for it to end.
+// The work is canceled first, so that an early return of the startup does not wait for it forever.
+func stopBackground(cancel context.CancelFunc, background *sync.WaitGroup) {
+	cancel()
+	background.Wait()
+}
+
+// openDatasets opens the repository and creates the service of every dataset, the default one first.
+// The background work of the repositories is added to the wait group, and the functions closing them
+// are appended to the closers, including the ones of the datasets opened before a failure.
+func openDatasets(
+	ctx context.Context,
+	opts storageOptions,
+	sources []datasetSource,
+	readerOpts filereader.Options,
+	background *sync.WaitGroup,
+	closers *[]func(),
+) (*service.Datasets, []*servedDataset, error) {
+	var datasets *service.Datasets
+	served := make([]*servedDataset, 0, len(sources)+1)
+	for _, dataset := range append([]datasetSource{{service.DefaultDataset, defaultSource}}, sources...) {
+		name := dataset.name
+		portSource, err := newPortSource(dataset.filename, readerOpts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("files of the %s dataset: %w", name, err)
+		}
+
+		opened, err := openRepository(ctx, opts, name, background, closers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storage of the %s dataset: %w", name, err)
+		}
+
+		portService := service.NewPortService(opened.repo)
+		if datasets == nil {
+			datasets = service.NewDatasets(portSource.Name(), portService)
+		} else if err := datasets.Add(name, portSource.Name(), portService); err != nil {
+			return nil, nil, fmt.Errorf("%s dataset: %w", name, err)
+		}
+
+		served = append(served, &servedDataset{
+			name:       name,
+			service:    portService,
+			portSource: portSource,
+			opened:     opened,
+		})
+	}
+
+	return datasets, served, nil
+}
+
+// openRepository opens the repository of the dataset in the storage selected by the options.
+// The files of the datasets other than the default one are named after the dataset.
+// The background work of the repository is added to the wait group,
+// and the functions closing it are appended to the closers.
+func openRepository(
+	ctx context.Context,
+	opts storageOptions,
+	dataset string,
+	background *sync.WaitGroup,
+	closers *[]func(),
+) (openedRepository, error) {
+	opened := openedRepository{}
+
+	switch opts.storage {
+	case storageMemory:
+		memoryDB := memory.NewMemoryDB()
+		memoryDB.SetHistoryLimit(opts.historyLimit)
+		opened.repo = memoryDB
+
+		// The snapshots and the write-ahead log are bound to the database, so it cannot be replaced with them
+		if opts.snapshotDir == "" && opts.walFile == "" {
+			opened.newRepository = func(current repository.PortRepository) (repository.PortRepository, error) {
+				return current.(*memory.MemoryDB).Successor(), nil
+			}
+		}
+
+		// Restore the newest snapshot, which replaces the file import
+		var snapshotter *memory.Snapshotter
+		if opts.snapshotDir != "" {
+			dir := opts.snapshotDir
+			if dataset != service.DefaultDataset {
+				dir = filepath.Join(dir, dataset)
+			}
+
+			snapshotter = memory.NewSnapshotter(memoryDB, dir, opts.snapshotKeep)
+			path, err := snapshotter.LoadLatest(ctx)
+			switch {
+			case err == nil:
+				log.Printf("Snapshot restored from %s. Number of ports in the repository: %d",
+					path, memoryDB.GetLength(ctx))
+				opened.restored = true
+			case errors.Is(err, errs.ErrSnapshotNotFound):
+				log.Printf("No snapshot found in %s", dir)
+			default:
+				return opened, fmt.Errorf("error while restoring snapshot: %w", err)
+			}
+		}
+
+		// Replay the changes made after the snapshot and record the following ones
+		if opts.walFile != "" {
+			wal, err := memory.OpenWAL(datasetPath(opts.walFile, dataset), opts.walSync)
+			if err != nil {
+				return opened, fmt.Errorf("error while opening write-ahead log: %w", err)
+			}
+			*closers = append(*closers, func() { wal.Close() })
+
+			replayed, err := memoryDB.AttachWAL(wal)
+			if err != nil {
+				return opened, fmt.Errorf("error while replaying write-ahead log: %w", err)
+			}
+			log.Printf("Write-ahead log replayed. Number of changes: %d", replayed)
+			opened.restored = opened.restored || replayed > 0
+		}
+
+		// Save snapshots periodically and once more on shutdown,
+		// which also compacts the write-ahead log
+		if snapshotter != nil {
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				snapshotter.Run(ctx, opts.snapshotInterval)
+			}()
+		}
+
+	case storageSharded:
+		// The sharded storage has no snapshots nor write-ahead log, the ports are always imported
+		shardedDB := memory.NewShardedMemoryDB(opts.shards)
+		shardedDB.SetHistoryLimit(opts.historyLimit)
+		opened.repo = shardedDB
+		opened.newRepository = func(current repository.PortRepository) (repository.PortRepository, error) {
+			return current.(*memory.ShardedMemoryDB).Successor(), nil
+		}
+
+	case storageBolt:
+		boltDB, err := bolt.NewBoltDB(datasetPath(opts.boltFile, dataset))
+		if err != nil {
+			return opened, fmt.Errorf("error while opening bolt file: %w", err)
+		}
+		*closers = append(*closers, func() { boltDB.Close() })
+
+		// The file keeps the ports between the restarts, so the import is only needed once
+		opened.restored = boltDB.GetLength(ctx) > 0
+		opened.repo = withCache(boltDB, opts)
+
+	case storageSQLite:
+		sqliteDB, err := sqlite.NewSQLiteDB(ctx, datasetPath(opts.sqliteFile, dataset))
+		if err != nil {
+			return opened, fmt.Errorf("error while opening sqlite database: %w", err)
+		}
+		*closers = append(*closers, func() { sqliteDB.Close() })
+
+		// The database keeps the ports between the restarts, so the import is only needed once
+		opened.restored = sqliteDB.GetLength(ctx) > 0
+		opened.repo = withCache(sqliteDB, opts)
+
+	default:
+		return opened, fmt.Errorf("unknown storage: %s", opts.storage)
+	}
+
+	return opened, nil
+}
+
+// withCache puts a read-through cache in front of the repository, unless the cache size is zero.
+func withCache(portRepository repository.PortRepository, opts storageOptions) repository.PortRepository {
+	if opts.cacheSize <= 0 {
+		return portRepository
+	}
+
+	return cache.NewCachedRepository(portRepository, cache.Options{
+		Size:        opts.cacheSize,
+		TTL:         opts.cacheTTL,
+		NegativeTTL: opts.cacheNegativeTTL,
+	})
+}
+
+// datasetPath returns the path of the file of the dataset, which is the given path for the default dataset,
+// and the given path with the dataset name appended to its base name for the others, such as ports-unlocode.db.
+func datasetPath(path, dataset string) string {
+	if dataset == service.DefaultDataset {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + dataset + ext
+}
+
+// newPortSource returns the source reading the files joined with a "+" in order,
+// so that a port of a later file replaces the port with the same id of an earlier one.
+// A file prefixed with a format, such as unlocode:CodeListPart1.csv, is read in this format.
+func newPortSource(filenames string, opts filereader.Options) (source.PortSource, error) {
+	sources := make([]source.PortSource, 0)
+	for _, filename := range strings.Split(filenames, "+") {
+		fileOpts := opts
+		if format, name, ok := strings.Cut(filename, ":"); ok && filereader.IsFormat(format) {
+			fileOpts.Format, filename = format, name
+		}
+
+		reader, err := filereader.NewReader(filename, fileOpts)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, reader)
+	}
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+
+	return source.Chain(sources...), nil
+}
+
+// parseDatasets parses the value of the datasets flag into name and file pairs, in the given order.
+func parseDatasets(value string) ([]datasetSource, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	sources := make([]datasetSource, 0)
+	for _, pair := range strings.Split(value, ",") {
+		name, filename, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || filename == "" {
+			return nil, fmt.Errorf("%q is not a name=file pair", pair)
+		}
+		for _, file := range strings.Split(filename, "+") {
+			if file == "" {
+				return nil, fmt.Errorf("%q has an empty file name", pair)
+			}
+		}
+		if err := service.ValidateDatasetName(name); err != nil {
+			return nil, fmt.Errorf("%q: %w", name, err)
+		}
+		sources = append(sources, datasetSource{name: name, filename: filename})
+	}
+
+	return sources, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/application/filereader"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+func TestOpenDatasetsFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The default dataset starts its snapshots before the second dataset fails
+	dir := t.TempDir()
+	opts := storageOptions{
+		storage:          storageMemory,
+		snapshotDir:      dir,
+		snapshotInterval: time.Hour,
+		snapshotKeep:     1,
+	}
+	sources := []datasetSource{{name: "Internal", filename: "internal.json"}}
+
+	var closers []func()
+	background := &sync.WaitGroup{}
+	_, _, err := openDatasets(ctx, opts, sources, filereader.Options{}, background, &closers)
+	assert.ErrorIs(t, err, errs.ErrInvalidDataset)
+
+	stopped := make(chan struct{})
+	go func() {
+		stopBackground(cancel, background)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the background work of the opened dataset was not stopped")
+	}
+
+	// The snapshots of the opened dataset ran until the stop, which saved a last one
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries)
+}
@@ -0,0 +1,354 @@
+package filereader
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// Fields of a port the CSV columns can be mapped to.
+// The lat and lon fields are the latitude and the longitude of the coordinates of the port.
+const (
+	CSVFieldID       = "id"
+	CSVFieldName     = "name"
+	CSVFieldCity     = "city"
+	CSVFieldProvince = "province"
+	CSVFieldCountry  = "country"
+	CSVFieldAlias    = "alias"
+	CSVFieldRegions  = "regions"
+	CSVFieldLat      = "lat"
+	CSVFieldLon      = "lon"
+	CSVFieldTimezone = "timezone"
+	CSVFieldUnlocs   = "unlocs"
+	CSVFieldCode     = "code"
+)
+
+// DefaultCSVListSeparator separates the values of the multi-valued fields when the mapping does not give one.
+const DefaultCSVListSeparator = "|"
+
+// csvFields holds the fields the CSV columns can be mapped to.
+var csvFields = map[string]bool{
+	CSVFieldID: true, CSVFieldName: true, CSVFieldCity: true, CSVFieldProvince: true, CSVFieldCountry: true,
+	CSVFieldAlias: true, CSVFieldRegions: true, CSVFieldLat: true, CSVFieldLon: true, CSVFieldTimezone: true,
+	CSVFieldUnlocs: true, CSVFieldCode: true,
+}
+
+// CSVMapping describes how the columns of a CSV file map to the fields of the ports.
+type CSVMapping struct {
+	// Columns maps the headers of the columns to the fields, such as "Port Code" to CSVFieldID.
+	// The headers are matched regardless of their case and surrounding spaces, and the columns which
+	// are not mapped are ignored. When empty, the headers are the names of the fields.
+	Columns map[string]string
+
+	// Comma is the delimiter of the columns, a comma when zero.
+	Comma rune
+
+	// ListSeparator separates the values of the alias, regions and unlocs fields,
+	// DefaultCSVListSeparator when empty.
+	ListSeparator string
+}
+
+// ParseCSVColumns parses comma separated header=field pairs, such as "Port Code=id,Latitude=lat",
+// into the columns of a CSVMapping. If a pair is malformed or maps to an unknown field,
+// it returns an ErrInvalidInput error.
+func ParseCSVColumns(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	columns := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		header, field, ok := strings.Cut(pair, "=")
+		header, field = strings.TrimSpace(header), strings.ToLower(strings.TrimSpace(field))
+		if !ok || header == "" {
+			return nil, fmt.Errorf("%w: %q is not a header=field pair", errs.ErrInvalidInput, pair)
+		}
+		if !csvFields[field] {
+			return nil, fmt.Errorf("%w: unknown field %q", errs.ErrInvalidInput, field)
+		}
+		columns[header] = field
+	}
+
+	return columns, nil
+}
+
+// CSVFileReader reads the ports of a CSV file with a header row, one port per row.
+// It implements source.PortSource.
+type CSVFileReader struct {
+	Filename   string
+	BufferSize int
+
+	// SkipBroken skips the rows which cannot be decoded or have no id, instead of stopping the reading with an error.
+	SkipBroken bool
+
+	// Mapping maps the columns to the fields of the ports.
+	Mapping CSVMapping
+}
+
+var _ source.PortSource = (*CSVFileReader)(nil)
+
+// Name returns the filename.
+func (fr *CSVFileReader) Name() string {
+	return fr.Filename
+}
+
+// Metadata returns the format and the filename.
+func (fr *CSVFileReader) Metadata() map[string]string {
+	return map[string]string{"format": FormatCSV, "file": fr.Filename}
+}
+
+// ReadPorts reads ports from the CSV file and sends them to output channels.
+// The fields without a column are left empty, and the coordinates are set when the lat and lon columns
+// of the row are both filled. A header without an id column stops the reading with an error.
+// The errors report the row they occurred on, which is its line number in the file.
+func (fr *CSVFileReader) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
+	// Create the output channels
+	portsCh := make(chan *model.Port, 1)
+	errCh := make(chan error, 1)
+
+	// Launch a goroutine to process the file
+	go func() {
+		defer close(errCh)
+		defer close(portsCh)
+
+		// Open the file
+		file, err := os.Open(fr.Filename)
+		if err != nil {
+			errCh <- fmt.Errorf("os.Open: failed with: %w", err)
+			return
+		}
+
+		// Ensure that the file is closed before returning
+		// The close error is only reported when no other error was
+		defer func() {
+			if err := file.Close(); err != nil && len(errCh) == 0 {
+				errCh <- fmt.Errorf("file.Close: failed with: %w", err)
+			}
+		}()
+
+		if err := fr.readRows(ctx, bufio.NewReaderSize(file, fr.BufferSize), portsCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return portsCh, errCh
+}
+
+// csvColumn is a column of the file mapped to a field.
+type csvColumn struct {
+	index int
+	field string
+}
+
+// readRows decodes a port from every row read from the reader after the header,
+// and sends the ports to the channel until the reader ends or the context is done.
+func (fr *CSVFileReader) readRows(ctx context.Context, reader io.Reader, portsCh chan<- *model.Port) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.ReuseRecord = true
+	if fr.Mapping.Comma != 0 {
+		csvReader.Comma = fr.Mapping.Comma
+	}
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("header: csv.Reader.Read: failed with: %w", err)
+	}
+	columns, err := fr.Mapping.columns(header)
+	if err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+
+	separator := fr.Mapping.ListSeparator
+	if separator == "" {
+		separator = DefaultCSVListSeparator
+	}
+
+	for {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseErr *csv.ParseError
+		var port *model.Port
+		row := 0
+		switch {
+		case errors.As(err, &parseErr):
+			// The reader goes on with the next row after a malformed one
+			row = parseErr.StartLine
+			err = fmt.Errorf("csv.Reader.Read: failed with: %w", parseErr.Err)
+		case err != nil:
+			return fmt.Errorf("csv.Reader.Read: failed with: %w", err)
+		default:
+			row, _ = csvReader.FieldPos(0)
+			port, err = processPortRow(record, columns, separator)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("row %d: %w", row, err)
+			if !fr.SkipBroken {
+				return err
+			}
+			source.ReportSkipped(ctx, fr, err)
+			continue
+		}
+
+		select {
+		case portsCh <- port:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// columns returns the columns of the header mapped to a field.
+// If there is no id column or a column is mapped to an unknown field, it returns an ErrInvalidInput error.
+func (m CSVMapping) columns(header []string) ([]csvColumn, error) {
+	mapping := make(map[string]string, len(m.Columns))
+	for name, field := range m.Columns {
+		mapping[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(field)
+	}
+
+	columns := make([]csvColumn, 0, len(header))
+	hasID := false
+	for index, name := range header {
+		// Spreadsheets may start the file with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+
+		field := name
+		if len(mapping) > 0 {
+			field = mapping[name]
+		}
+		if field == "" || (len(mapping) == 0 && !csvFields[field]) {
+			continue
+		}
+		if !csvFields[field] {
+			return nil, fmt.Errorf("%w: unknown field %q", errs.ErrInvalidInput, field)
+		}
+
+		hasID = hasID || field == CSVFieldID
+		columns = append(columns, csvColumn{index: index, field: field})
+	}
+	if !hasID {
+		return nil, fmt.Errorf("%w: no column is mapped to the id", errs.ErrInvalidInput)
+	}
+
+	return columns, nil
+}
+
+// processPortRow decodes the port of a row from its mapped columns.
+func processPortRow(record []string, columns []csvColumn, separator string) (*model.Port, error) {
+	port := new(model.Port)
+	var lat, lon string
+	for _, column := range columns {
+		value := strings.TrimSpace(record[column.index])
+
+		switch column.field {
+		case CSVFieldID:
+			port.ID = value
+		case CSVFieldName:
+			port.Name = value
+		case CSVFieldCity:
+			port.City = value
+		case CSVFieldProvince:
+			port.Province = value
+		case CSVFieldCountry:
+			port.Country = value
+		case CSVFieldAlias:
+			port.Alias = splitList(value, separator)
+		case CSVFieldRegions:
+			port.Regions = splitList(value, separator)
+		case CSVFieldLat:
+			lat = value
+		case CSVFieldLon:
+			lon = value
+		case CSVFieldTimezone:
+			port.Timezone = value
+		case CSVFieldUnlocs:
+			port.Unlocs = splitList(value, separator)
+		case CSVFieldCode:
+			port.Code = value
+		}
+	}
+
+	if port.ID == "" {
+		return nil, fmt.Errorf("%w: missing id", errs.ErrInvalidPortID)
+	}
+
+	coordinates, err := parseCoordinates(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	port.Coordinates = coordinates
+
+	return port, nil
+}
+
+// parseCoordinates returns the longitude and latitude pair of the port, or nil if both are empty.
+// If only one of them is given, or a value is not a finite number within the range of the latitudes
+// or the longitudes, it returns an ErrInvalidInput error.
+func parseCoordinates(lat, lon string) ([]float64, error) {
+	if lat == "" && lon == "" {
+		return nil, nil
+	}
+	if lat == "" || lon == "" {
+		return nil, fmt.Errorf("%w: both lat and lon are needed", errs.ErrInvalidInput)
+	}
+
+	latitude, err := parseDegrees(lat, 90)
+	if err != nil {
+		return nil, fmt.Errorf("%w: lat %q %v", errs.ErrInvalidInput, lat, err)
+	}
+	longitude, err := parseDegrees(lon, 180)
+	if err != nil {
+		return nil, fmt.Errorf("%w: lon %q %v", errs.ErrInvalidInput, lon, err)
+	}
+
+	// The coordinates of a port are a longitude and latitude pair
+	return []float64{longitude, latitude}, nil
+}
+
+// parseDegrees parses decimal degrees between -limit and limit. The values which cannot be encoded
+// to JSON, such as NaN and Inf, are rejected like the ones out of range.
+func parseDegrees(value string, limit float64) (float64, error) {
+	degrees, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(degrees) || math.IsInf(degrees, 0) {
+		return 0, errors.New("is not a number")
+	}
+	if degrees < -limit || degrees > limit {
+		return 0, fmt.Errorf("is not between -%.0f and %.0f", limit, limit)
+	}
+
+	return degrees, nil
+}
+
+// splitList splits the value of a multi-valued field and drops the empty values.
+// An empty value gives an empty list.
+func splitList(value, separator string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
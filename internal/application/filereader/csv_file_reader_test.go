@@ -0,0 +1,192 @@
+package filereader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// readCSV reads all the ports of the CSV content with the mapping, and returns them with the first error read.
+func readCSV(t *testing.T, content string, mapping CSVMapping, skipBroken bool) ([]*model.Port, error) {
+	t.Helper()
+
+	return readSource(context.Background(), &CSVFileReader{
+		Filename:   writeNamedFile(t, "ports.csv", content),
+		BufferSize: 4096,
+		SkipBroken: skipBroken,
+		Mapping:    mapping,
+	})
+}
+
+func TestCSVFileReader(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "Test Headers Named After The Fields",
+			testFunc: func(t *testing.T) {
+				content := "id,name,city,province,country,alias,regions,lat,lon,timezone,unlocs,code\n" +
+					"AEAJM,Ajman,Ajman,Ajman,United Arab Emirates,,,25.4052165,55.5136433,Asia/Dubai,AEAJM,52000\n" +
+					"GBLON,London,London,,United Kingdom,Londres|Londra,Europe| UK ,,,Europe/London,GBLON|GBLGP,41352\n"
+				ports, err := readCSV(t, content, CSVMapping{}, false)
+				require.NoError(t, err)
+				require.Len(t, ports, 2)
+
+				assert.Equal(t, &model.Port{
+					ID:          "AEAJM",
+					Name:        "Ajman",
+					City:        "Ajman",
+					Province:    "Ajman",
+					Country:     "United Arab Emirates",
+					Alias:       []string{},
+					Regions:     []string{},
+					Coordinates: []float64{55.5136433, 25.4052165},
+					Timezone:    "Asia/Dubai",
+					Unlocs:      []string{"AEAJM"},
+					Code:        "52000",
+				}, ports[0])
+				assert.Equal(t, []string{"Londres", "Londra"}, ports[1].Alias)
+				assert.Equal(t, []string{"Europe", "UK"}, ports[1].Regions)
+				assert.Equal(t, []string{"GBLON", "GBLGP"}, ports[1].Unlocs)
+				assert.Nil(t, ports[1].Coordinates)
+			},
+		},
+		{
+			name: "Test Mapped Columns With Other Delimiters",
+			testFunc: func(t *testing.T) {
+				content := "\ufeffPort Code;Port Name;Extra;LATITUDE;Longitude;UN/LOCODEs\n" +
+					"NLRTM;\"Rotterdam; Europoort\";ignored;51.9225;4.47917;NLRTM, NLEUR\n"
+				mapping := CSVMapping{
+					Columns: map[string]string{
+						"port code": CSVFieldID, "Port Name": CSVFieldName, "Latitude": CSVFieldLat,
+						"Longitude": CSVFieldLon, "UN/LOCODEs": CSVFieldUnlocs,
+					},
+					Comma:         ';',
+					ListSeparator: ",",
+				}
+				ports, err := readCSV(t, content, mapping, false)
+				require.NoError(t, err)
+				require.Len(t, ports, 1)
+				assert.Equal(t, "NLRTM", ports[0].ID)
+				assert.Equal(t, "Rotterdam; Europoort", ports[0].Name)
+				assert.Equal(t, []float64{4.47917, 51.9225}, ports[0].Coordinates)
+				assert.Equal(t, []string{"NLRTM", "NLEUR"}, ports[0].Unlocs)
+				assert.Nil(t, ports[0].Alias)
+				assert.Empty(t, ports[0].Country)
+			},
+		},
+		{
+			name: "Test Large File",
+			testFunc: func(t *testing.T) {
+				var sb strings.Builder
+				sb.WriteString("id,name\n")
+				for i := 0; i < 20000; i++ {
+					fmt.Fprintf(&sb, "P%05d,Port %d\n", i, i)
+				}
+				ports, err := readCSV(t, sb.String(), CSVMapping{}, false)
+				require.NoError(t, err)
+				require.Len(t, ports, 20000)
+				assert.Equal(t, "Port 19999", ports[19999].Name)
+			},
+		},
+		{
+			name: "Test Errors Report The Row",
+			testFunc: func(t *testing.T) {
+				for _, tc := range []struct {
+					content  string
+					expected string
+				}{
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\n\nAEAUH,north,54.3\n", expected: `row 4: invalid input: lat "north"`},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,24.4,\n", expected: "row 3: invalid input: both lat"},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,NaN,54.3\n", expected: `row 3: invalid input: lat "NaN"`},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,24.4,-Inf\n", expected: `row 3: invalid input: lon "-Inf"`},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,91,54.3\n", expected: "is not between -90 and 90"},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,24.4,180.5\n", expected: "is not between -180 and 180"},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\n,24.4,54.3\n", expected: "row 3: invalid port id"},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAEAUH,24.4\n", expected: "row 3: csv.Reader.Read"},
+					{content: "id,lat,lon\nAEAJM,25.4,55.5\nAE\"AUH,24.4,54.3\n", expected: "row 3: csv.Reader.Read"},
+				} {
+					ports, err := readCSV(t, tc.content, CSVMapping{}, false)
+					assert.ErrorContains(t, err, tc.expected)
+					assert.Equal(t, []string{"AEAJM"}, portIDs(ports))
+				}
+			},
+		},
+		{
+			name: "Test Broken Rows Are Skipped",
+			testFunc: func(t *testing.T) {
+				content := "id,name,lat,lon\nAEAJM,Ajman,,\nAEAUH,Abu Dhabi,north,54.3\n,No Id,,\n" +
+					"AEDXB,\"Dubai\n\nAEFJR,Fujairah\"\n"
+				ports, err := readCSV(t, content, CSVMapping{}, true)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM"}, portIDs(ports))
+
+				// Every skipped row is reported with its row
+				var skipped []error
+				ctx := source.WithSkipped(context.Background(), func(err error) {
+					skipped = append(skipped, err)
+				})
+				fr := &CSVFileReader{Filename: writeNamedFile(t, "ports.csv", content), SkipBroken: true}
+				_, err = readSource(ctx, fr)
+				require.NoError(t, err)
+				if assert.Len(t, skipped, 3) {
+					assert.ErrorContains(t, skipped[0], "ports.csv: row 3: invalid input: lat")
+					assert.ErrorIs(t, skipped[1], errs.ErrInvalidPortID)
+					assert.ErrorContains(t, skipped[1], "row 4: ")
+					assert.ErrorContains(t, skipped[2], "row 5: csv.Reader.Read")
+				}
+
+				content = "id,name\nAEAJM,Ajman\nAEAUH,Abu Dhabi,extra\nAEDXB,Dubai\n"
+				ports, err = readCSV(t, content, CSVMapping{}, true)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM", "AEDXB"}, portIDs(ports))
+			},
+		},
+		{
+			name: "Test Header Without An Id Column",
+			testFunc: func(t *testing.T) {
+				_, err := readCSV(t, "name,city\nAjman,Ajman\n", CSVMapping{}, true)
+				assert.ErrorIs(t, err, errs.ErrInvalidInput)
+				assert.ErrorContains(t, err, "header")
+
+				_, err = readCSV(t, "id,name\nAEAJM,Ajman\n", CSVMapping{Columns: map[string]string{"name": "name"}}, true)
+				assert.ErrorIs(t, err, errs.ErrInvalidInput)
+
+				_, err = readCSV(t, "", CSVMapping{}, true)
+				assert.ErrorContains(t, err, "header")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
+
+func TestParseCSVColumns(t *testing.T) {
+	columns, err := ParseCSVColumns(" Port Code = id ,Latitude=LAT,Longitude=lon")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Port Code": CSVFieldID, "Latitude": CSVFieldLat, "Longitude": CSVFieldLon}, columns)
+
+	columns, err = ParseCSVColumns("")
+	require.NoError(t, err)
+	assert.Nil(t, columns)
+
+	for _, value := range []string{"Port Code", "=id", "Port Code=identifier"} {
+		_, err := ParseCSVColumns(value)
+		assert.ErrorIs(t, err, errs.ErrInvalidInput, value)
+	}
+}
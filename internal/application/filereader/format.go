@@ -0,0 +1,99 @@
+package filereader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// Supported formats of the ports files.
+const (
+	// FormatAuto picks the format from the extension of the file.
+	FormatAuto = ""
+
+	// FormatJSON is a single JSON object mapping the port ids to the ports.
+	FormatJSON = "json"
+
+	// FormatNDJSON is a JSON Lines file holding one port object per line, with its id in the id field.
+	FormatNDJSON = "ndjson"
+
+	// FormatCSV is a CSV file with a header row, holding one port per row in the columns given by a CSVMapping.
+	FormatCSV = "csv"
+
+	// FormatUNLOCODE is a CSV file of the official UN/LOCODE code list, which is never picked from the extension.
+	FormatUNLOCODE = "unlocode"
+)
+
+// formatsByExtension maps the file extensions to their format.
+var formatsByExtension = map[string]string{
+	".json":   FormatJSON,
+	".ndjson": FormatNDJSON,
+	".jsonl":  FormatNDJSON,
+	".csv":    FormatCSV,
+}
+
+// Options holds the settings of the reader created by NewReader.
+type Options struct {
+	// Format is the format of the file, it is picked from the extension of the file when empty.
+	Format string
+
+	// BufferSize is the size of the read buffer.
+	BufferSize int
+
+	// SkipBroken skips the ports which cannot be decoded, instead of stopping the reading with an error.
+	SkipBroken bool
+
+	// CSV maps the columns of the CSV files to the fields of the ports.
+	CSV CSVMapping
+}
+
+// IsFormat reports whether the format is one of the supported ones, FormatAuto excluded.
+func IsFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case FormatJSON, FormatNDJSON, FormatCSV, FormatUNLOCODE:
+		return true
+	default:
+		return false
+	}
+}
+
+// DetectFormat returns the format of the file picked from its extension, which is FormatJSON for
+// the unknown extensions.
+func DetectFormat(filename string) string {
+	if format, ok := formatsByExtension[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
+	}
+
+	return FormatJSON
+}
+
+// NewReader returns the reader of the file in the format of the options, or in the format picked from the
+// extension of the file if the options do not give one. If the format is unknown,
+// it returns an ErrUnsupportedFormat error.
+func NewReader(filename string, opts Options) (source.PortSource, error) {
+	format := strings.ToLower(opts.Format)
+	if format == FormatAuto {
+		format = DetectFormat(filename)
+	}
+
+	switch format {
+	case FormatJSON:
+		return &JSONFileReader{Filename: filename, BufferSize: opts.BufferSize, SkipBroken: opts.SkipBroken}, nil
+	case FormatNDJSON:
+		return &NDJSONFileReader{Filename: filename, BufferSize: opts.BufferSize, SkipBroken: opts.SkipBroken}, nil
+	case FormatCSV:
+		return &CSVFileReader{
+			Filename:   filename,
+			BufferSize: opts.BufferSize,
+			SkipBroken: opts.SkipBroken,
+			Mapping:    opts.CSV,
+		}, nil
+	case FormatUNLOCODE:
+		return &UNLOCODEFileReader{Filename: filename, BufferSize: opts.BufferSize, SkipBroken: opts.SkipBroken}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errs.ErrUnsupportedFormat, opts.Format)
+	}
+}
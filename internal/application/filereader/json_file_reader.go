@@ -2,33 +2,51 @@ package filereader
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 
-	"github.com/buger/jsonparser"
-
 	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
 )
 
-// JSONFileReader holds the filename and buffer size for reading the JSON file
+// JSONFileReader reads the ports of a JSON file, it implements source.PortSource.
 type JSONFileReader struct {
 	Filename   string
 	BufferSize int
+
+	// SkipBroken skips the ports which cannot be decoded, instead of stopping the reading with an error.
+	SkipBroken bool
+}
+
+var _ source.PortSource = (*JSONFileReader)(nil)
+
+// Name returns the filename.
+func (fr *JSONFileReader) Name() string {
+	return fr.Filename
 }
 
-// ReadPorts reads ports from the JSON file and sends them to output channels
-func (fr *JSONFileReader) ReadPorts(ctx context.Context, skipBroken bool) (<-chan *model.Port, <-chan error) {
+// Metadata returns the format and the filename.
+func (fr *JSONFileReader) Metadata() map[string]string {
+	return map[string]string{"format": FormatJSON, "file": fr.Filename}
+}
+
+// ReadPorts reads ports from the JSON file and sends them to output channels.
+// The file is a single JSON object mapping the port ids to the ports, which is read token by token,
+// so only one port is held in memory at a time whatever the size and the layout of the file.
+// The values which are not objects are skipped, and so are the ports which cannot be decoded if SkipBroken is set.
+// A malformed file stops the reading with an error.
+func (fr *JSONFileReader) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
 	// Create the output channels
 	portsCh := make(chan *model.Port, 1)
 	errCh := make(chan error, 1)
 
 	// Launch a goroutine to process the file
 	go func() {
-		defer close(portsCh)
 		defer close(errCh)
+		defer close(portsCh)
 
 		// Open the file
 		file, err := os.Open(fr.Filename)
@@ -38,91 +56,108 @@ func (fr *JSONFileReader) ReadPorts(ctx context.Context, skipBroken bool) (<-cha
 		}
 
 		// Ensure that the file is closed before returning
+		// The close error is only reported when no other error was
 		defer func() {
-			if err = file.Close(); err != nil {
+			if err := file.Close(); err != nil && len(errCh) == 0 {
 				errCh <- fmt.Errorf("file.Close: failed with: %w", err)
 			}
 		}()
 
-		// Create a new scanner to read the file line by line
-		scanner := bufio.NewScanner(file)
-		jsonBuffer := make([]byte, 0, fr.BufferSize)
+		if err := fr.readPorts(ctx, bufio.NewReaderSize(file, fr.BufferSize), portsCh); err != nil {
+			errCh <- err
+		}
+	}()
 
-		// Read the file line by line
-		for scanner.Scan() {
-			line := scanner.Bytes()
+	return portsCh, errCh
+}
 
-			// Skip empty lines
-			if len(bytes.TrimSpace(line)) == 0 {
-				continue
-			}
+// readPorts walks the key and value pairs of the top-level object read from the reader,
+// and sends the ports to the channel until the object ends or the context is done.
+func (fr *JSONFileReader) readPorts(ctx context.Context, reader io.Reader, portsCh chan<- *model.Port) error {
+	decoder := json.NewDecoder(reader)
 
-			// Append the line to the JSON buffer
-			jsonBuffer = append(jsonBuffer, line...)
+	// The file must hold an object
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
 
-			// Check if the JSON buffer has a complete JSON object
-			if bytes.HasSuffix(bytes.TrimSpace(jsonBuffer), []byte("}")) {
-				// Process the JSON object
-				err = jsonparser.ObjectEach(jsonBuffer, func(key, value []byte, dataType jsonparser.ValueType, _ int) error {
-					handleJSONValue(key, value, dataType, portsCh, errCh, skipBroken)
-					return nil
-				})
+	for decoder.More() {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
 
-				// Clear the buffer for the next JSON object
-				jsonBuffer = jsonBuffer[:0]
-			}
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("json.Decoder.Token: failed with: %w", err)
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("json.Decoder.Token: unexpected %v instead of a port id", token)
+		}
 
-			// Check if the context is done
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
+		// Only the value of the current key is held in memory
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("json.Decoder.Decode: failed with: %w (key: %s)", err, key)
+		}
 
-			// Check scanner error
-			if err := scanner.Err(); err != nil {
-				errCh <- fmt.Errorf("scanner.Err: failed with: %w", err)
-				return
+		// Process the port JSON and send it to the output channel
+		port, err := handleJSONValue([]byte(key), value)
+		if err != nil {
+			if !fr.SkipBroken {
+				return err
 			}
+			source.ReportSkipped(ctx, fr, err)
+			continue
+		}
+		if port == nil {
+			continue
 		}
-	}()
-
-	return portsCh, errCh
-}
 
-// handleJSONValue processes the JSON value and sends it to the output channel
-func handleJSONValue(key, value []byte, dataType jsonparser.ValueType,
-	portsCh chan<- *model.Port, errCh chan<- error, skipErrors bool,
-) {
-	// If the JSON value is not an object, skip it
-	if dataType != jsonparser.Object {
-		return
+		select {
+		case portsCh <- port:
+		case <-ctx.Done():
+			return nil
+		}
 	}
 
-	// Process the port JSON and send it to the output channel
-	port, err := processPort(key, value, skipErrors)
+	return expectDelim(decoder, '}')
+}
+
+// expectDelim reads the next token of the decoder and returns an error if it is not the given delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
 	if err != nil {
-		errCh <- err
-		return
+		return fmt.Errorf("json.Decoder.Token: failed with: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("json.Decoder.Token: unexpected %v instead of %v", token, delim)
 	}
 
-	if port != nil {
-		portsCh <- port
+	return nil
+}
+
+// handleJSONValue processes the JSON value of the given key and returns the port,
+// or nil if the value is not an object.
+func handleJSONValue(key, value []byte) (*model.Port, error) {
+	// If the JSON value is not an object, skip it
+	if len(value) == 0 || value[0] != '{' {
+		return nil, nil
 	}
+
+	return processPort(key, value)
 }
 
 // processPort Unmarshals the port JSON and returns a Port instance
-func processPort(key, value []byte, skipErrors bool) (*model.Port, error) {
+func processPort(key, value []byte) (*model.Port, error) {
 	port := new(model.Port)
 
 	// Unmarshal the JSON value into the Port struct
 	if err := json.Unmarshal(value, port); err != nil {
-		// If skipping errors is enabled, return nil without an error
-		if skipErrors {
-			return nil, nil
-		}
-
-		// Otherwise, return an error with details
+		// Return an error with details
 		return nil, fmt.Errorf("json.Unmarshal: failed with: %w data: (key: %s, value: %s)", err, key, value)
 	}
 
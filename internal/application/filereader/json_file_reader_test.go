@@ -0,0 +1,251 @@
+package filereader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
+)
+
+const prettyPorts = `{
+  "AEAJM": {
+    "name": "Ajman",
+    "city": "Ajman",
+    "country": "United Arab Emirates",
+    "coordinates": [
+      55.5136433,
+      25.4052165
+    ],
+    "unlocs": [
+      "AEAJM"
+    ],
+    "code": "52000"
+  },
+  "AEAUH": {
+    "name": "Abu Dhabi",
+    "city": "Abu Dhabi",
+    "country": "United Arab Emirates",
+    "unlocs": [
+      "AEAUH"
+    ],
+    "code": "52001"
+  }
+}
+`
+
+// writeFile writes the content to a ports.json file in a temporary directory and returns its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	return writeNamedFile(t, "ports.json", content)
+}
+
+// writeNamedFile writes the content to a file with the given name in a temporary directory and returns its path.
+func writeNamedFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+
+	return filename
+}
+
+// readAll reads all the ports of the JSON file, and returns them with the first error read.
+func readAll(ctx context.Context, filename string, skipBroken bool) ([]*model.Port, error) {
+	return readSource(ctx, &JSONFileReader{Filename: filename, BufferSize: 4096, SkipBroken: skipBroken})
+}
+
+// readSource reads all the ports of the source, and returns them with the first error read.
+func readSource(ctx context.Context, src source.PortSource) ([]*model.Port, error) {
+	portsCh, errCh := src.ReadPorts(ctx)
+
+	var ports []*model.Port
+	for port := range portsCh {
+		ports = append(ports, port)
+	}
+
+	return ports, <-errCh
+}
+
+// portIDs returns the ids of the ports.
+func portIDs(ports []*model.Port) []string {
+	ids := make([]string, 0, len(ports))
+	for _, port := range ports {
+		ids = append(ids, port.ID)
+	}
+
+	return ids
+}
+
+// largePorts returns a file content of n ports, on a single line if minified.
+func largePorts(n int, minified bool) string {
+	separator := ",\n"
+	if minified {
+		separator = ","
+	}
+
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(separator)
+		}
+		fmt.Fprintf(&sb, `"P%05d":{"name":"Port %d","country":"Country","unlocs":["P%05d"]}`, i, i, i)
+	}
+	sb.WriteString("}")
+
+	return sb.String()
+}
+
+func TestJSONFileReader(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "Test Pretty-Printed File",
+			testFunc: func(t *testing.T) {
+				ports, err := readAll(ctx, writeFile(t, prettyPorts), false)
+				require.NoError(t, err)
+				require.Len(t, ports, 2)
+				assert.Equal(t, "AEAJM", ports[0].ID)
+				assert.Equal(t, "Ajman", ports[0].Name)
+				assert.Equal(t, []float64{55.5136433, 25.4052165}, ports[0].Coordinates)
+				assert.Equal(t, "AEAUH", ports[1].ID)
+				assert.Equal(t, "52001", ports[1].Code)
+			},
+		},
+		{
+			name: "Test Minified File",
+			testFunc: func(t *testing.T) {
+				minified := `{"AEAJM":{"name":"Ajman","code":"52000"},"AEAUH":{"name":"Abu Dhabi","code":"52001"}}`
+				ports, err := readAll(ctx, writeFile(t, minified), false)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM", "AEAUH"}, portIDs(ports))
+				assert.Equal(t, "Abu Dhabi", ports[1].Name)
+			},
+		},
+		{
+			name: "Test Closing Braces In The Middle Of Lines",
+			testFunc: func(t *testing.T) {
+				content := "{\"AEAJM\": {\"name\": \"Ajman\"}, \"AEAUH\": {\n\"name\": \"Abu {Dhabi}\"\n}, \"AEDXB\":\n" +
+					"{\"name\": \"Dubai\"}}"
+				ports, err := readAll(ctx, writeFile(t, content), false)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM", "AEAUH", "AEDXB"}, portIDs(ports))
+				assert.Equal(t, "Abu {Dhabi}", ports[1].Name)
+			},
+		},
+		{
+			name: "Test Large Files",
+			testFunc: func(t *testing.T) {
+				for _, minified := range []bool{false, true} {
+					content := largePorts(20000, minified)
+					if minified {
+						// A single line far over the 64 KB limit of a line scanner
+						require.Greater(t, len(content), 1<<20)
+					}
+
+					ports, err := readAll(ctx, writeFile(t, content), false)
+					require.NoError(t, err)
+					require.Len(t, ports, 20000)
+					assert.Equal(t, "P00000", ports[0].ID)
+					assert.Equal(t, "Port 19999", ports[19999].Name)
+				}
+			},
+		},
+		{
+			name: "Test Port Larger Than The Buffer",
+			testFunc: func(t *testing.T) {
+				alias := strings.Repeat("a", 100*1024)
+				content := fmt.Sprintf(`{"AEAJM":{"name":"Ajman","alias":["%s"]},"AEAUH":{"name":"Abu Dhabi"}}`, alias)
+				ports, err := readAll(ctx, writeFile(t, content), false)
+				require.NoError(t, err)
+				require.Len(t, ports, 2)
+				assert.Equal(t, []string{alias}, ports[0].Alias)
+			},
+		},
+		{
+			name: "Test Values Which Are Not Objects Are Skipped",
+			testFunc: func(t *testing.T) {
+				content := `{"version": 2, "AEAJM": {"name": "Ajman"}, "tags": ["a", {"b": "}"}], "note": null}`
+				ports, err := readAll(ctx, writeFile(t, content), false)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM"}, portIDs(ports))
+			},
+		},
+		{
+			name: "Test Broken Port Is Skipped Or Returned",
+			testFunc: func(t *testing.T) {
+				filename := writeFile(t, `{"AEAJM": {"name": 42}, "AEAUH": {"name": "Abu Dhabi"}}`)
+
+				ports, err := readAll(ctx, filename, true)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAUH"}, portIDs(ports))
+
+				ports, err = readAll(ctx, filename, false)
+				assert.ErrorContains(t, err, "json.Unmarshal: failed with")
+				assert.ErrorContains(t, err, "AEAJM")
+				assert.Empty(t, ports)
+			},
+		},
+		{
+			name: "Test Malformed Files Return An Error",
+			testFunc: func(t *testing.T) {
+				for _, content := range []string{
+					``,
+					`["AEAJM"]`,
+					`{"AEAJM": {"name": "Ajman"}`,
+					`{"AEAJM": {"name": "Ajman"},, "AEAUH": {}}`,
+					`{"AEAJM": {"name": "Ajman"} "AEAUH": {}}`,
+				} {
+					_, err := readAll(ctx, writeFile(t, content), true)
+					assert.Error(t, err, content)
+				}
+			},
+		},
+		{
+			name: "Test Missing File Returns An Error",
+			testFunc: func(t *testing.T) {
+				_, err := readAll(ctx, filepath.Join(t.TempDir(), "missing.json"), true)
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			},
+		},
+		{
+			name: "Test Canceled Context Stops The Reading",
+			testFunc: func(t *testing.T) {
+				ctx, cancel := context.WithCancel(ctx)
+				fr := &JSONFileReader{Filename: writeFile(t, largePorts(1000, true)), BufferSize: 4096}
+				portsCh, errCh := fr.ReadPorts(ctx)
+
+				<-portsCh
+				cancel()
+
+				read := 1
+				for range portsCh {
+					read++
+				}
+				assert.Less(t, read, 1000)
+				assert.NoError(t, <-errCh)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package filereader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// NDJSONFileReader reads the ports of a JSON Lines file, which holds one port object per line
+// with its id in the id field. It implements source.PortSource.
+type NDJSONFileReader struct {
+	Filename   string
+	BufferSize int
+
+	// SkipBroken skips the lines which cannot be decoded or have no id, instead of stopping the reading with an error.
+	SkipBroken bool
+}
+
+var _ source.PortSource = (*NDJSONFileReader)(nil)
+
+// Name returns the filename.
+func (fr *NDJSONFileReader) Name() string {
+	return fr.Filename
+}
+
+// Metadata returns the format and the filename.
+func (fr *NDJSONFileReader) Metadata() map[string]string {
+	return map[string]string{"format": FormatNDJSON, "file": fr.Filename}
+}
+
+// ReadPorts reads ports from the JSON Lines file and sends them to output channels.
+// The file is read line by line whatever their length, and the blank lines are skipped.
+// The errors report the number of the line they occurred on.
+func (fr *NDJSONFileReader) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
+	// Create the output channels
+	portsCh := make(chan *model.Port, 1)
+	errCh := make(chan error, 1)
+
+	// Launch a goroutine to process the file
+	go func() {
+		defer close(errCh)
+		defer close(portsCh)
+
+		// Open the file
+		file, err := os.Open(fr.Filename)
+		if err != nil {
+			errCh <- fmt.Errorf("os.Open: failed with: %w", err)
+			return
+		}
+
+		// Ensure that the file is closed before returning
+		// The close error is only reported when no other error was
+		defer func() {
+			if err := file.Close(); err != nil && len(errCh) == 0 {
+				errCh <- fmt.Errorf("file.Close: failed with: %w", err)
+			}
+		}()
+
+		if err := fr.readPortLines(ctx, bufio.NewReaderSize(file, fr.BufferSize), portsCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return portsCh, errCh
+}
+
+// readPortLines decodes a port from every line read from the reader,
+// and sends the ports to the channel until the reader ends or the context is done.
+func (fr *NDJSONFileReader) readPortLines(ctx context.Context, reader *bufio.Reader, portsCh chan<- *model.Port) error {
+	for number := 1; ; number++ {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("line %d: bufio.Reader.ReadBytes: failed with: %w", number, err)
+		}
+		eof := err != nil
+
+		port, lineErr := processPortLine(line)
+		switch {
+		case lineErr != nil && !fr.SkipBroken:
+			return fmt.Errorf("line %d: %w", number, lineErr)
+		case lineErr != nil:
+			source.ReportSkipped(ctx, fr, fmt.Errorf("line %d: %w", number, lineErr))
+		case port != nil:
+			select {
+			case portsCh <- port:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if eof {
+			return nil
+		}
+	}
+}
+
+// processPortLine decodes the port of a line, and returns nil without an error for a blank line.
+func processPortLine(line []byte) (*model.Port, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	port := new(model.Port)
+	if err := json.Unmarshal(line, port); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: failed with: %w data: (value: %s)", err, line)
+	}
+	if port.ID == "" {
+		return nil, fmt.Errorf("%w: missing id data: (value: %s)", errs.ErrInvalidPortID, line)
+	}
+
+	return port, nil
+}
@@ -0,0 +1,161 @@
+package filereader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// readLines reads all the ports of the JSON Lines content, and returns them with the first error read.
+func readLines(t *testing.T, content string, skipBroken bool) ([]string, error) {
+	t.Helper()
+
+	fr := &NDJSONFileReader{
+		Filename:   writeNamedFile(t, "ports.ndjson", content),
+		BufferSize: 4096,
+		SkipBroken: skipBroken,
+	}
+	ports, err := readSource(context.Background(), fr)
+
+	return portIDs(ports), err
+}
+
+func TestNDJSONFileReader(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "Test Ports Are Read Line By Line",
+			testFunc: func(t *testing.T) {
+				content := `{"id":"AEAJM","name":"Ajman","coordinates":[55.5136433,25.4052165],"code":"52000"}` + "\n" +
+					`{"id":"AEAUH","name":"Abu Dhabi","unlocs":["AEAUH"]}` + "\r\n" +
+					"\n   \n" +
+					`{"id":"AEDXB","name":"Dubai"}`
+				fr := &NDJSONFileReader{Filename: writeNamedFile(t, "ports.ndjson", content), BufferSize: 4096}
+				ports, err := readSource(context.Background(), fr)
+				require.NoError(t, err)
+				require.Len(t, ports, 3)
+				assert.Equal(t, "AEAJM", ports[0].ID)
+				assert.Equal(t, []float64{55.5136433, 25.4052165}, ports[0].Coordinates)
+				assert.Equal(t, []string{"AEAUH"}, ports[1].Unlocs)
+				assert.Equal(t, "Dubai", ports[2].Name)
+			},
+		},
+		{
+			name: "Test Lines Longer Than The Buffer",
+			testFunc: func(t *testing.T) {
+				alias := strings.Repeat("a", 100*1024)
+				content := fmt.Sprintf(`{"id":"AEAJM","alias":["%s"]}`+"\n"+`{"id":"AEAUH"}`+"\n", alias)
+				ids, err := readLines(t, content, false)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM", "AEAUH"}, ids)
+			},
+		},
+		{
+			name: "Test Large File",
+			testFunc: func(t *testing.T) {
+				var sb strings.Builder
+				for i := 0; i < 20000; i++ {
+					fmt.Fprintf(&sb, `{"id":"P%05d","name":"Port %d"}`+"\n", i, i)
+				}
+				ids, err := readLines(t, sb.String(), false)
+				require.NoError(t, err)
+				require.Len(t, ids, 20000)
+				assert.Equal(t, "P19999", ids[19999])
+			},
+		},
+		{
+			name: "Test Errors Report The Line Number",
+			testFunc: func(t *testing.T) {
+				for _, tc := range []struct {
+					content  string
+					expected string
+				}{
+					{content: "{\"id\":\"AEAJM\"}\n\n{\"id\":\"AEAUH\",\"name\":42}\n", expected: "line 3: json.Unmarshal"},
+					{content: "{\"id\":\"AEAJM\"}\n{\"id\":\"AEAUH\"\n", expected: "line 2: json.Unmarshal"},
+					{content: "{\"id\":\"AEAJM\"}\n[\"AEAUH\"]\n", expected: "line 2: json.Unmarshal"},
+					{content: "{\"id\":\"AEAJM\"}\n{\"name\":\"Abu Dhabi\"}", expected: "line 2: invalid port id"},
+				} {
+					ids, err := readLines(t, tc.content, false)
+					assert.ErrorContains(t, err, tc.expected)
+					assert.Equal(t, []string{"AEAJM"}, ids)
+				}
+
+				_, err := readLines(t, "{\"name\":\"Abu Dhabi\"}\n", false)
+				assert.ErrorIs(t, err, errs.ErrInvalidPortID)
+			},
+		},
+		{
+			name: "Test Broken Lines Are Skipped",
+			testFunc: func(t *testing.T) {
+				content := "{\"id\":\"AEAJM\"}\n{\"id\":\"AEAUH\",\"name\":42}\nnot json\n" +
+					"{\"name\":\"No Id\"}\n{\"id\":\"AEDXB\"}\n"
+				ids, err := readLines(t, content, true)
+				require.NoError(t, err)
+				assert.Equal(t, []string{"AEAJM", "AEDXB"}, ids)
+			},
+		},
+		{
+			name: "Test Missing File Returns An Error",
+			testFunc: func(t *testing.T) {
+				fr := &NDJSONFileReader{Filename: filepath.Join(t.TempDir(), "missing.ndjson")}
+				_, err := readSource(context.Background(), fr)
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	testCases := []struct {
+		name           string
+		filename       string
+		format         string
+		expectedFormat string
+		expectedError  error
+	}{
+		{name: "JSON Extension", filename: "ports.json", expectedFormat: FormatJSON},
+		{name: "NDJSON Extension", filename: "ports.ndjson", expectedFormat: FormatNDJSON},
+		{name: "JSON Lines Extension", filename: "PORTS.JSONL", expectedFormat: FormatNDJSON},
+		{name: "CSV Extension", filename: "fixes.csv", expectedFormat: FormatCSV},
+		{name: "Unknown Extension Defaults To JSON", filename: "ports.txt", expectedFormat: FormatJSON},
+		{name: "Explicit Format Wins", filename: "ports.json", format: "ndjson", expectedFormat: FormatNDJSON},
+		{name: "Explicit Format Is Case Insensitive", filename: "ports.txt", format: "NDJSON",
+			expectedFormat: FormatNDJSON},
+		{name: "UN/LOCODE Format", filename: "CodeListPart1.csv", format: "unlocode", expectedFormat: FormatUNLOCODE},
+		{name: "Unknown Format", filename: "ports.json", format: "xml", expectedError: errs.ErrUnsupportedFormat},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader, err := NewReader(tc.filename, Options{Format: tc.format, SkipBroken: true})
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.filename, reader.Name())
+			assert.Equal(t, tc.expectedFormat, reader.Metadata()["format"])
+		})
+	}
+}
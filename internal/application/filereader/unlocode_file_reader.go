@@ -0,0 +1,306 @@
+package filereader
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// Columns of the UN/LOCODE code list CSV, which has no header row.
+const (
+	unlocodeChange = iota
+	unlocodeCountry
+	unlocodeLocation
+	unlocodeName
+	unlocodeNameWoDiacritics
+	unlocodeSubdivision
+	unlocodeStatus
+	unlocodeFunction
+	unlocodeDate
+	unlocodeIATA
+	unlocodeCoordinates
+
+	// unlocodeMinColumns is the number of columns up to the coordinates, the remarks may be left out.
+	unlocodeMinColumns
+)
+
+// Change indicators of the UN/LOCODE entries which are not imported.
+const (
+	unlocodeRemoved   = "X"
+	unlocodeReference = "="
+)
+
+// UNLOCODEFileReader reads the ports of a CSV file of the official UN/LOCODE code list, as distributed by UNECE.
+// Only the entries whose function classifier marks them as ports are read. It implements source.PortSource.
+type UNLOCODEFileReader struct {
+	Filename   string
+	BufferSize int
+
+	// SkipBroken skips the entries which cannot be decoded, instead of stopping the reading with an error.
+	SkipBroken bool
+}
+
+var _ source.PortSource = (*UNLOCODEFileReader)(nil)
+
+// Name returns the filename.
+func (fr *UNLOCODEFileReader) Name() string {
+	return fr.Filename
+}
+
+// Metadata returns the format and the filename.
+func (fr *UNLOCODEFileReader) Metadata() map[string]string {
+	return map[string]string{"format": FormatUNLOCODE, "file": fr.Filename}
+}
+
+// ReadPorts reads ports from the UN/LOCODE file and sends them to output channels.
+// The id of a port joins the country and location codes, such as GBLON, and is also its only unloc.
+// The country rows, such as ".UNITED KINGDOM", give the country name of the entries after them.
+// The entries marked as removed and the reference entries are skipped.
+// The values which are not valid UTF-8 are decoded as ISO 8859-1, the encoding of the older distributions.
+// The errors report the row they occurred on, which is its line number in the file.
+func (fr *UNLOCODEFileReader) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
+	// Create the output channels
+	portsCh := make(chan *model.Port, 1)
+	errCh := make(chan error, 1)
+
+	// Launch a goroutine to process the file
+	go func() {
+		defer close(errCh)
+		defer close(portsCh)
+
+		// Open the file
+		file, err := os.Open(fr.Filename)
+		if err != nil {
+			errCh <- fmt.Errorf("os.Open: failed with: %w", err)
+			return
+		}
+
+		// Ensure that the file is closed before returning
+		// The close error is only reported when no other error was
+		defer func() {
+			if err := file.Close(); err != nil && len(errCh) == 0 {
+				errCh <- fmt.Errorf("file.Close: failed with: %w", err)
+			}
+		}()
+
+		if err := fr.readEntries(ctx, bufio.NewReaderSize(file, fr.BufferSize), portsCh); err != nil {
+			errCh <- err
+		}
+	}()
+
+	return portsCh, errCh
+}
+
+// readEntries decodes the port entries read from the reader, and sends the ports to the channel
+// until the reader ends or the context is done.
+func (fr *UNLOCODEFileReader) readEntries(ctx context.Context, reader io.Reader, portsCh chan<- *model.Port) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.ReuseRecord = true
+	csvReader.FieldsPerRecord = -1
+
+	// The country names by their code
+	countries := make(map[string]string)
+
+	for {
+		// Check if the context is done
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var parseErr *csv.ParseError
+		var port *model.Port
+		row := 0
+		switch {
+		case errors.As(err, &parseErr):
+			// The reader goes on with the next row after a malformed one
+			row = parseErr.StartLine
+			err = fmt.Errorf("csv.Reader.Read: failed with: %w", parseErr.Err)
+		case err != nil:
+			return fmt.Errorf("csv.Reader.Read: failed with: %w", err)
+		default:
+			row, _ = csvReader.FieldPos(0)
+			port, err = processEntry(record, countries)
+		}
+
+		if err != nil {
+			err = fmt.Errorf("row %d: %w", row, err)
+			if !fr.SkipBroken {
+				return err
+			}
+			source.ReportSkipped(ctx, fr, err)
+			continue
+		}
+		if port == nil {
+			continue
+		}
+
+		select {
+		case portsCh <- port:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// processEntry decodes the port of an entry, and returns nil without an error for the entries
+// which are not imported. A country row adds its name to the countries.
+func processEntry(record []string, countries map[string]string) (*model.Port, error) {
+	if len(record) < unlocodeMinColumns {
+		return nil, fmt.Errorf("%w: %d columns, at least %d expected", errs.ErrInvalidInput, len(record), unlocodeMinColumns)
+	}
+
+	field := func(column int) string {
+		return strings.TrimSpace(decodeLatin1(record[column]))
+	}
+	country, location, name := field(unlocodeCountry), field(unlocodeLocation), field(unlocodeName)
+
+	if location == "" {
+		if strings.HasPrefix(name, ".") {
+			countries[country] = titleCase(strings.TrimPrefix(name, "."))
+		}
+		return nil, nil
+	}
+
+	change, function := field(unlocodeChange), field(unlocodeFunction)
+	if change == unlocodeRemoved || change == unlocodeReference || !strings.HasPrefix(function, "1") {
+		return nil, nil
+	}
+
+	id := country + location
+	if err := model.ValidatePortID(id); err != nil {
+		return nil, fmt.Errorf("%w: %q", err, id)
+	}
+	coordinates, err := parseUNLOCODECoordinates(field(unlocodeCoordinates))
+	if err != nil {
+		return nil, err
+	}
+
+	port := &model.Port{
+		ID:          id,
+		Name:        name,
+		City:        name,
+		Province:    field(unlocodeSubdivision),
+		Country:     countries[country],
+		Alias:       []string{},
+		Regions:     []string{},
+		Coordinates: coordinates,
+		Unlocs:      []string{id},
+		Function:    function,
+		Status:      field(unlocodeStatus),
+	}
+	if port.Country == "" {
+		port.Country = country
+	}
+	if plain := field(unlocodeNameWoDiacritics); plain != "" && plain != name {
+		port.Alias = append(port.Alias, plain)
+	}
+
+	return port, nil
+}
+
+// parseUNLOCODECoordinates parses the ddmmN dddmmE notation of the coordinates, such as "5130N 00007W",
+// into a longitude and latitude pair in decimal degrees. It returns nil for empty coordinates,
+// and an ErrInvalidInput error for malformed ones.
+func parseUNLOCODECoordinates(value string) ([]float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	lat, lon, ok := strings.Cut(value, " ")
+	if !ok {
+		return nil, fmt.Errorf("%w: coordinates %q", errs.ErrInvalidInput, value)
+	}
+	latitude, err := parseDegreesMinutes(lat, 2, 'N', 'S', 90)
+	if err != nil {
+		return nil, fmt.Errorf("%w: coordinates %q", err, value)
+	}
+	longitude, err := parseDegreesMinutes(strings.TrimSpace(lon), 3, 'E', 'W', 180)
+	if err != nil {
+		return nil, fmt.Errorf("%w: coordinates %q", err, value)
+	}
+
+	return []float64{longitude, latitude}, nil
+}
+
+// parseDegreesMinutes parses degrees of the given number of digits followed by two digits of minutes
+// and the hemisphere, which is negative for the second one, into decimal degrees up to the limit.
+func parseDegreesMinutes(value string, digits int, positive, negative byte, limit int) (float64, error) {
+	if len(value) != digits+3 {
+		return 0, errs.ErrInvalidInput
+	}
+
+	degrees, err := strconv.Atoi(value[:digits])
+	if err != nil {
+		return 0, errs.ErrInvalidInput
+	}
+	minutes, err := strconv.Atoi(value[digits : digits+2])
+	if err != nil || minutes >= 60 || degrees*60+minutes > limit*60 {
+		return 0, errs.ErrInvalidInput
+	}
+
+	decimal := float64(degrees) + float64(minutes)/60
+	switch value[digits+2] {
+	case positive:
+		return decimal, nil
+	case negative:
+		return -decimal, nil
+	default:
+		return 0, errs.ErrInvalidInput
+	}
+}
+
+// decodeLatin1 returns the value as is if it is valid UTF-8, and decodes it as ISO 8859-1 otherwise.
+func decodeLatin1(value string) string {
+	if utf8.ValidString(value) {
+		return value
+	}
+
+	runes := make([]rune, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		runes = append(runes, rune(value[i]))
+	}
+
+	return string(runes)
+}
+
+// titleCase turns the upper case country names of the code list, such as "KOREA, REPUBLIC OF",
+// into the case of the ports file, such as "Korea, Republic of".
+func titleCase(value string) string {
+	words := strings.Fields(strings.ToLower(value))
+	for i, word := range words {
+		switch word {
+		case "of", "the", "and":
+			if i > 0 {
+				continue
+			}
+		}
+
+		// Capitalize the first letter, which may follow an opening parenthesis
+		for j, r := range word {
+			if r != '(' {
+				words[i] = word[:j] + strings.ToUpper(string(r)) + word[j+utf8.RuneLen(r):]
+				break
+			}
+		}
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,156 @@
+package filereader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// unlocodeRows are rows of the code list in the layout of the UNECE distribution.
+const unlocodeRows = `,"AE",,".UNITED ARAB EMIRATES",,,,,,,,
+,"AE","AJM","Ajman","Ajman","AJ","AI","1-------","9307",,"2524N 05530E",
+,"AE","AUH","Abu Dhabi","Abu Dhabi","AZ","AI","1--45---","9601",,"2428N 05422E",
+,"AE","XYZ","Inland Depot","Inland Depot",,"RL","--3-----","0901",,"2500N 05500E",
+"X","AE","OLD","Old Harbour","Old Harbour",,"XX","1-------","0901",,,
+"=","AE","DXB","Dubai Port = Dubai","Dubai Port = Dubai",,,,,,,
+,"AE","DXB","Dubai","Dubai","DU","AI","1-345---","0901",,"2516N 05518E",
+,"KR",,".KOREA, REPUBLIC OF",,,,,,,,
+,"KR","PUS","Busan","Busan","26","AI","1234----","0901",,"3506N 12903E","Formerly Pusan"
+,"AR",,".ARGENTINA",,,,,,,,
+,"AR","RGA","R` + "\xed" + `o Grande","Rio Grande","V","AI","1-------","0901",,"5347S 06742W",
+`
+
+func TestUNLOCODEFileReader(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "Test Port Entries Are Read",
+			testFunc: func(t *testing.T) {
+				fr := &UNLOCODEFileReader{Filename: writeNamedFile(t, "CodeListPart1.csv", unlocodeRows), BufferSize: 4096}
+				ports, err := readSource(ctx, fr)
+				require.NoError(t, err)
+
+				// The inland depot, the removed and the reference entries are left out
+				assert.Equal(t, []string{"AEAJM", "AEAUH", "AEDXB", "KRPUS", "ARRGA"}, portIDs(ports))
+				assert.Equal(t, &model.Port{
+					ID:          "AEAJM",
+					Name:        "Ajman",
+					City:        "Ajman",
+					Province:    "AJ",
+					Country:     "United Arab Emirates",
+					Alias:       []string{},
+					Regions:     []string{},
+					Coordinates: []float64{55.5, 25.4},
+					Unlocs:      []string{"AEAJM"},
+					Function:    "1-------",
+					Status:      "AI",
+				}, ports[0])
+
+				assert.Equal(t, "Korea, Republic of", ports[3].Country)
+				assert.InDelta(t, 129.05, ports[3].Coordinates[0], 1e-9)
+				assert.InDelta(t, 35.1, ports[3].Coordinates[1], 1e-9)
+
+				// The ISO 8859-1 names are decoded, and the southern and western coordinates are negative
+				assert.Equal(t, "Río Grande", ports[4].Name)
+				assert.Equal(t, []string{"Rio Grande"}, ports[4].Alias)
+				assert.InDelta(t, -67.7, ports[4].Coordinates[0], 1e-9)
+				assert.InDelta(t, -53.783333, ports[4].Coordinates[1], 1e-6)
+			},
+		},
+		{
+			name: "Test Unknown Country Falls Back To Its Code",
+			testFunc: func(t *testing.T) {
+				content := `,"FR","PAR","Paris","Paris","75","AI","1-345---","0901",,,` + "\n"
+				fr := &UNLOCODEFileReader{Filename: writeNamedFile(t, "CodeListPart2.csv", content)}
+				ports, err := readSource(ctx, fr)
+				require.NoError(t, err)
+				require.Len(t, ports, 1)
+				assert.Equal(t, "FR", ports[0].Country)
+				assert.Nil(t, ports[0].Coordinates)
+			},
+		},
+		{
+			name: "Test Broken Entries Are Skipped Or Reported With Their Row",
+			testFunc: func(t *testing.T) {
+				for _, tc := range []struct {
+					row      string
+					expected string
+				}{
+					{row: `,"AE","SHJ","Sharjah","Sharjah","SH","AI","1-------","0901",,"2522N",`, expected: "coordinates"},
+					{row: `,"AE","SHJ","Sharjah","Sharjah","SH","AI","1-------","0901",,"2522X 05523E",`,
+						expected: "coordinates"},
+					{row: `,"AE","SHJ","Sharjah","Sharjah","SH","AI","1-------","0901",,"9522N 05523E",`,
+						expected: "coordinates"},
+					{row: `,"AE","SHJ","Sharjah","Sharjah","SH","AI","1-------"`, expected: "columns"},
+					{row: `,"AE","SHJ","Sharjah,"Sharjah","SH","AI","1-------","0901",,,`, expected: "csv.Reader.Read"},
+				} {
+					content := unlocodeRows[:len(`,"AE",,".UNITED ARAB EMIRATES",,,,,,,,`)+1] + tc.row + "\n" +
+						`,"AE","DXB","Dubai","Dubai","DU","AI","1-345---","0901",,"2516N 05518E",` + "\n"
+					filename := writeNamedFile(t, "CodeListPart1.csv", content)
+
+					_, err := readSource(ctx, &UNLOCODEFileReader{Filename: filename})
+					assert.ErrorContains(t, err, "row 2: ", tc.row)
+					assert.ErrorContains(t, err, tc.expected, tc.row)
+					if tc.expected != "csv.Reader.Read" {
+						assert.ErrorIs(t, err, errs.ErrInvalidInput, tc.row)
+					}
+
+					ports, err := readSource(ctx, &UNLOCODEFileReader{Filename: filename, SkipBroken: true})
+					require.NoError(t, err)
+					assert.Equal(t, []string{"AEDXB"}, portIDs(ports))
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
+
+func TestParseUNLOCODECoordinates(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected []float64
+		valid    bool
+	}{
+		{value: "", expected: nil, valid: true},
+		{value: "5130N 00007W", expected: []float64{-0.11666666666666667, 51.5}, valid: true},
+		{value: "0000N 18000E", expected: []float64{180, 0}, valid: true},
+		{value: "9000S 00000E", expected: []float64{0, -90}, valid: true},
+		{value: "5130N00007W", valid: false},
+		{value: "5160N 00007W", valid: false},
+		{value: "9001N 00007W", valid: false},
+		{value: "5130N 18001E", valid: false},
+		{value: "5130E 00007N", valid: false},
+		{value: "513ON 00007W", valid: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.value, func(t *testing.T) {
+			t.Parallel()
+
+			coordinates, err := parseUNLOCODECoordinates(tc.value)
+			if !tc.valid {
+				assert.ErrorIs(t, err, errs.ErrInvalidInput)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, coordinates)
+		})
+	}
+}
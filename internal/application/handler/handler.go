@@ -2,41 +2,558 @@
 package handler
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/canbo-x/port-service/internal/application/service"
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
 	errs "github.com/canbo-x/port-service/internal/error"
 )
 
+// ListPortsResponse is the response body of the port listing endpoint.
+type ListPortsResponse struct {
+	// Ports contains the ports of the requested page.
+	Ports []*model.Port `json:"ports"`
+
+	// NextCursor is the cursor of the following page, empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+
+	// Next is the link to the following page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// PortRevisionResponse is a single revision in the response body of the port history endpoint.
+type PortRevisionResponse struct {
+	// Revision is the number of the revision.
+	Revision uint64 `json:"revision"`
+
+	// Timestamp is the time of the change.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Source describes where the change came from.
+	Source string `json:"source,omitempty"`
+
+	// Deleted is set when the change removed the port.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Port is the state of the port after the change, missing when it was deleted.
+	Port *model.Port `json:"port,omitempty"`
+}
+
+// PortHistoryResponse is the response body of the port history endpoint.
+type PortHistoryResponse struct {
+	// ID is the id of the port.
+	ID string `json:"id"`
+
+	// Revisions contains the kept revisions ordered from the oldest.
+	Revisions []PortRevisionResponse `json:"revisions"`
+}
+
+// sourceAPI is the source of the changes made through the API in the history of the ports.
+const sourceAPI = "api"
+
 // PortHandler is the HTTP handler for port-related operations.
+// The requests are served by the dataset named by the `ds` path parameter, or by the default one without it.
 type PortHandler struct {
-	portService *service.PortService
+	datasets *service.Datasets
 }
 
-// NewPortHandler creates a new PortHandler instance with the given port service.
+// NewPortHandler creates a new PortHandler instance serving the given port service as the only dataset.
 func NewPortHandler(portService *service.PortService) *PortHandler {
+	return NewDatasetHandler(service.NewDatasets("", portService))
+}
+
+// NewDatasetHandler creates a new PortHandler instance serving the given datasets.
+func NewDatasetHandler(datasets *service.Datasets) *PortHandler {
 	return &PortHandler{
-		portService: portService,
+		datasets: datasets,
+	}
+}
+
+// service returns the port service of the dataset of the request, or false if there is no such dataset.
+func (h *PortHandler) service(c echo.Context) (*service.PortService, bool) {
+	name := c.Param("ds")
+	if name == "" {
+		name = service.DefaultDataset
+	}
+
+	dataset, err := h.datasets.Get(name)
+	if err != nil {
+		return nil, false
 	}
+
+	return dataset.Service, true
+}
+
+// ListDatasets handles the HTTP GET request to list the datasets with their figures.
+func (h *PortHandler) ListDatasets(c echo.Context) error {
+	datasets := h.datasets.All()
+	stats := make([]service.DatasetStats, 0, len(datasets))
+	for _, dataset := range datasets {
+		stats = append(stats, dataset.Stats(c.Request().Context()))
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// GetDataset handles the HTTP GET request to retrieve the figures of a dataset by its name.
+// It returns 404 Not Found if there is no such dataset.
+func (h *PortHandler) GetDataset(c echo.Context) error {
+	dataset, err := h.datasets.Get(c.Param("ds"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, dataset.Stats(c.Request().Context()))
 }
 
 // GetPort handles the HTTP GET request to retrieve a port by its ID.
-// It returns an appropriate error response if the ID is invalid, the port is not found,
-// or there is an internal server error. Otherwise, it returns the port data as JSON.
+// It returns 410 Gone if the port was deleted, and an appropriate error response if the ID is invalid,
+// the port is not found, or there is an internal server error. Otherwise, it returns the port data as JSON.
+// With the optional `revision` query parameter, it returns the port as it was at that revision,
+// and with the optional `asOf` query parameter, as it was at that RFC 3339 time.
+// Otherwise, the version of the port is sent in the ETag header, to be used in the If-Match header of the writes.
 func (h *PortHandler) GetPort(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
 	id := c.Param("id")
-	port, err := h.portService.GetPort(c.Request().Context(), id)
+
+	var port *model.Port
+	var err error
+	rawRevision, rawAsOf := c.QueryParam("revision"), c.QueryParam("asOf")
+	switch {
+	case rawRevision != "" && rawAsOf != "":
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+	case rawRevision != "":
+		revision, parseErr := strconv.ParseUint(rawRevision, 10, 64)
+		if parseErr != nil || revision == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidRevision.Error()})
+		}
+		port, err = portService.GetPortRevision(c.Request().Context(), id, revision)
+	case rawAsOf != "":
+		asOf, parseErr := time.Parse(time.RFC3339, rawAsOf)
+		if parseErr != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidTime.Error()})
+		}
+		port, err = portService.GetPortAsOf(c.Request().Context(), id, asOf)
+	default:
+		var version uint64
+		port, version, err = portService.GetPortVersioned(c.Request().Context(), id)
+		if err == nil {
+			c.Response().Header().Set("ETag", formatETag(version))
+		}
+	}
+	if err == errs.ErrInvalidPortID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrPortNotFound || err == errs.ErrRevisionNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrHistoryExpired {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrPortGone {
+		return c.JSON(http.StatusGone, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrHistoryNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, port)
+}
+
+// PutPort handles the HTTP PUT request to create or replace a port by its ID.
+// The ID in the body can be omitted, otherwise it must match the one in the path.
+// With the If-Match header, the port is only replaced if it still has the given ETag, `*` matching any
+// existing port, and with `If-None-Match: *` it is only created if it does not exist yet.
+// It returns the port with its new ETag, 412 Precondition Failed if the precondition does not hold,
+// 409 Conflict if a write without precondition keeps losing to the concurrent ones, 403 Forbidden on a follower,
+// or an appropriate error response if the port is invalid or there is an internal server error.
+func (h *PortHandler) PutPort(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+	id := c.Param("id")
+
+	port := new(model.Port)
+	if err := json.NewDecoder(c.Request().Body).Decode(port); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+	}
+	if port.ID == "" {
+		port.ID = id
+	}
+	if port.ID != id {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+	}
+
+	ctx := repository.WithSource(c.Request().Context(), sourceAPI)
+	version, err := expectedVersion(c, portService, id)
+	if err == nil {
+		var newVersion uint64
+		if newVersion, err = portService.SavePort(ctx, port, version); err == nil {
+			c.Response().Header().Set("ETag", formatETag(newVersion))
+		}
+	}
+	if err == errs.ErrInvalidPortID || err == errs.ErrInvalidInput {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrVersionMismatch {
+		return c.JSON(http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrWriteConflict {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrFollowerReadOnly {
+		return followerReadOnly(c, portService)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, port)
+}
+
+// DeletePort handles the HTTP DELETE request to remove a port by its ID.
+// With the If-Match header, the port is only removed if it still has the given ETag.
+// It returns 204 No Content on success, 412 Precondition Failed if the precondition does not hold,
+// 403 Forbidden on a follower, or an appropriate error response if the ID is invalid, the port is not found,
+// or there is an internal server error.
+func (h *PortHandler) DeletePort(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+	id := c.Param("id")
+	ctx := repository.WithSource(c.Request().Context(), sourceAPI)
+
+	version, err := expectedVersion(c, portService, id)
+	if err == nil {
+		if version != nil {
+			err = portService.DeletePortIfVersion(ctx, id, *version)
+		} else {
+			err = portService.DeletePort(ctx, id)
+		}
+	}
 	if err == errs.ErrInvalidPortID {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 	if err == errs.ErrPortNotFound {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
 	}
+	if err == errs.ErrVersionMismatch {
+		return c.JSON(http.StatusPreconditionFailed, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrFollowerReadOnly {
+		return followerReadOnly(c, portService)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListPorts handles the HTTP GET request to list ports page by page.
+// It accepts the optional `limit` and `cursor` query parameters and returns
+// the page together with the link to the next one, which is also sent in the Link header.
+// With the optional `deleted=true` query parameter, it lists the deleted ports instead of the live ones,
+// and with the optional `asOf` query parameter, the ports as they were at that RFC 3339 time.
+func (h *PortHandler) ListPorts(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+	limit := 0
+	if rawLimit := c.QueryParam("limit"); rawLimit != "" {
+		var err error
+		if limit, err = strconv.Atoi(rawLimit); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidLimit.Error()})
+		}
+	}
+
+	deleted := false
+	if rawDeleted := c.QueryParam("deleted"); rawDeleted != "" {
+		var err error
+		if deleted, err = strconv.ParseBool(rawDeleted); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+		}
+	}
+
+	var result *repository.ListResult
+	var err error
+	if rawAsOf := c.QueryParam("asOf"); rawAsOf != "" {
+		if deleted {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+		}
+		asOf, parseErr := time.Parse(time.RFC3339, rawAsOf)
+		if parseErr != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidTime.Error()})
+		}
+		result, err = portService.ListPortsAsOf(c.Request().Context(), asOf, c.QueryParam("cursor"), limit)
+	} else {
+		result, err = portService.ListPorts(c.Request().Context(), c.QueryParam("cursor"), limit, deleted)
+	}
+	if err == errs.ErrInvalidLimit || err == errs.ErrInvalidCursor {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrHistoryExpired {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrHistoryNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
+	resp := ListPortsResponse{
+		Ports:      result.Ports,
+		NextCursor: result.NextCursor,
+	}
+
+	if result.NextCursor != "" {
+		query := c.Request().URL.Query()
+		query.Set("cursor", result.NextCursor)
+		next := url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}
+		resp.Next = next.String()
+		c.Response().Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", resp.Next))
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// RestorePort handles the HTTP POST request to bring back a deleted port by its ID.
+// It returns the restored port with its new ETag, 403 Forbidden on a follower, or an appropriate error response
+// if the ID is invalid, the port has no tombstone, or there is an internal server error.
+func (h *PortHandler) RestorePort(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+	id := c.Param("id")
+	ctx := repository.WithSource(c.Request().Context(), sourceAPI)
+
+	var port *model.Port
+	var version uint64
+	_, err := portService.RestorePort(ctx, id)
+	if err == nil {
+		// The port is read back, as another write may follow the restore
+		port, version, err = portService.GetPortVersioned(ctx, id)
+	}
+	if err == errs.ErrInvalidPortID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrPortNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrPortGone {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrFollowerReadOnly {
+		return followerReadOnly(c, portService)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set("ETag", formatETag(version))
+
 	return c.JSON(http.StatusOK, port)
 }
+
+// InvalidPortPath handles the HTTP GET requests to the unknown paths below a port.
+// The port id cannot contain a slash, so they are rejected as an invalid port id.
+func (h *PortHandler) InvalidPortPath(c echo.Context) error {
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidPortID.Error()})
+}
+
+// GetPortHistory handles the HTTP GET request to retrieve the kept revisions of a port.
+// It returns an appropriate error response if the ID is invalid, the port has no revision,
+// the storage does not keep the history, or there is an internal server error.
+func (h *PortHandler) GetPortHistory(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+	id := c.Param("id")
+	revisions, err := portService.GetPortHistory(c.Request().Context(), id)
+	if err == errs.ErrInvalidPortID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrPortNotFound {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrHistoryNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	resp := PortHistoryResponse{
+		ID:        id,
+		Revisions: make([]PortRevisionResponse, 0, len(revisions)),
+	}
+	for _, revision := range revisions {
+		resp.Revisions = append(resp.Revisions, PortRevisionResponse{
+			Revision:  revision.Number,
+			Timestamp: revision.Timestamp,
+			Source:    revision.Source,
+			Deleted:   revision.Deleted,
+			Port:      revision.Port,
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetReplicationSnapshot handles the HTTP GET request of a follower for the whole content of the dataset,
+// which it starts from before asking for the following changes.
+// It returns 501 Not Implemented if the storage cannot be replicated.
+func (h *PortHandler) GetReplicationSnapshot(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+
+	snapshot, err := portService.ReplicationSnapshot(c.Request().Context())
+	if err == errs.ErrReplicationNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, snapshot)
+}
+
+// GetReplicationChanges handles the HTTP GET request of a follower for the changes made after the version
+// in the `after` query parameter. With the optional `wait` query parameter, such as `5s`, it waits that long
+// for a change if there is none yet. It returns 410 Gone if the changes are no longer kept, so the follower
+// has to start over from a snapshot, and 501 Not Implemented if the changes are not recorded.
+func (h *PortHandler) GetReplicationChanges(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+
+	after, err := strconv.ParseUint(c.QueryParam("after"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidVersion.Error()})
+	}
+	wait := time.Duration(0)
+	if rawWait := c.QueryParam("wait"); rawWait != "" {
+		if wait, err = time.ParseDuration(rawWait); err != nil || wait < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errs.ErrInvalidInput.Error()})
+		}
+	}
+
+	changes, err := portService.ChangesSince(c.Request().Context(), after, wait)
+	if err == errs.ErrChangesExpired {
+		return c.JSON(http.StatusGone, map[string]string{"error": err.Error()})
+	}
+	if err == errs.ErrReplicationNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
+
+// GetReplicationStatus handles the HTTP GET request to retrieve the replication status of the dataset,
+// which tells a follower how far behind its leader it is.
+// It returns 501 Not Implemented if the storage cannot be replicated.
+func (h *PortHandler) GetReplicationStatus(c echo.Context) error {
+	portService, ok := h.service(c)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": errs.ErrDatasetNotFound.Error()})
+	}
+
+	status, err := portService.ReplicationStatus(c.Request().Context())
+	if err == errs.ErrReplicationNotSupported {
+		return c.JSON(http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// followerReadOnly returns the 403 Forbidden response of a write sent to a follower,
+// which names the leader to send it to instead.
+func followerReadOnly(c echo.Context, portService *service.PortService) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error":  errs.ErrFollowerReadOnly.Error(),
+		"leader": portService.Leader(),
+	})
+}
+
+// expectedVersion returns the version of the port required by the preconditions of the request
+// to the given service, or nil if the request has none. `If-Match: *` requires the current version of an existing port
+// and `If-None-Match: *` requires a port that does not exist, which is version zero.
+// It returns an ErrVersionMismatch error if the preconditions cannot hold.
+func expectedVersion(c echo.Context, portService *service.PortService, id string) (*uint64, error) {
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		if strings.TrimSpace(ifMatch) == "*" {
+			_, current, err := portService.GetPortVersioned(c.Request().Context(), id)
+			if err == errs.ErrPortNotFound || err == errs.ErrPortGone {
+				return nil, errs.ErrVersionMismatch
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &current, nil
+		}
+
+		version, ok := parseETag(ifMatch)
+		if !ok {
+			return nil, errs.ErrVersionMismatch
+		}
+		return &version, nil
+	}
+
+	if strings.TrimSpace(c.Request().Header.Get("If-None-Match")) == "*" {
+		version := uint64(0)
+		return &version, nil
+	}
+
+	return nil, nil
+}
+
+// formatETag returns the entity tag of the given port version.
+func formatETag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
+
+// parseETag returns the port version of an entity tag returned by formatETag.
+// Weak tags are rejected, since the writes require a strong comparison.
+func parseETag(tag string) (uint64, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil || version == 0 {
+		return 0, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,217 @@
+// Package replication contains the follower side of the replication between port-service instances.
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/application/service"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+const (
+	// DefaultWait is how long a request for changes waits on the leader when a non-positive duration is requested.
+	DefaultWait = service.MaxChangesWait
+
+	// DefaultRetryInterval is the time waited after a failed request when a non-positive duration is requested.
+	DefaultRetryInterval = time.Second
+
+	// requestTimeout is the time given to a request on top of the wait for changes.
+	requestTimeout = 30 * time.Second
+)
+
+// Options holds the settings of a follower.
+type Options struct {
+	// Wait is how long a request for changes waits on the leader for new ones, capped to service.MaxChangesWait.
+	Wait time.Duration
+
+	// RetryInterval is the time waited after a failed request before the next one.
+	RetryInterval time.Duration
+
+	// Client sends the requests to the leader, a client with a timeout is used when nil.
+	Client *http.Client
+}
+
+// Follower copies the ports of a leader into the service of a follower. It starts from a snapshot of the leader,
+// then applies the changes of the leader in order, and starts over from a new snapshot whenever the leader no
+// longer keeps the changes it needs.
+type Follower struct {
+	leader  string
+	service *service.PortService
+	opts    Options
+
+	mu     sync.Mutex
+	status service.ReplicationStatus
+}
+
+// NewFollower creates a new Follower of the leader dataset at the given URL, such as http://leader:8080
+// or http://leader:8080/datasets/unlocode, and marks the service as its follower, which rejects the writes.
+// The non-positive options fall back to DefaultWait and DefaultRetryInterval.
+func NewFollower(leader string, portService *service.PortService, opts Options) *Follower {
+	if opts.Wait <= 0 {
+		opts.Wait = DefaultWait
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Wait + requestTimeout}
+	}
+
+	leader = strings.TrimSuffix(leader, "/")
+	f := &Follower{
+		leader:  leader,
+		service: portService,
+		opts:    opts,
+		status:  service.ReplicationStatus{Role: service.RoleFollower, Leader: leader},
+	}
+	portService.Follow(leader, f.Status)
+
+	return f
+}
+
+// Status returns the replication status of the follower.
+func (f *Follower) Status() service.ReplicationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status
+}
+
+// Run follows the leader until the context is done.
+// A failed request is logged and tried again after the retry interval.
+func (f *Follower) Run(ctx context.Context) {
+	synced := false
+	for {
+		var err error
+		if synced {
+			synced, err = f.pullChanges(ctx)
+		} else {
+			err = f.loadSnapshot(ctx)
+			synced = err == nil
+		}
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Error while following %s: %v", f.leader, err)
+		f.mu.Lock()
+		f.status.Connected = false
+		f.mu.Unlock()
+
+		select {
+		case <-time.After(f.opts.RetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// loadSnapshot replaces the ports of the follower with a snapshot of the leader.
+func (f *Follower) loadSnapshot(ctx context.Context) error {
+	snapshot := &service.ReplicationSnapshot{}
+	if err := f.get(ctx, "/replication/snapshot", nil, snapshot); err != nil {
+		return err
+	}
+	if err := f.service.ResetReplica(ctx, snapshot); err != nil {
+		return fmt.Errorf("reset: failed with: %w", err)
+	}
+	log.Printf("Snapshot of %s loaded at version %d. Number of ports: %d",
+		f.leader, snapshot.Version, len(snapshot.Ports))
+
+	f.contacted(snapshot.Version, snapshot.Version)
+
+	return nil
+}
+
+// pullChanges applies the changes of the leader made after the last applied one, waiting for them if needed.
+// It reports false once the follower has to start over from a snapshot.
+func (f *Follower) pullChanges(ctx context.Context) (bool, error) {
+	version := f.Status().Version
+	query := url.Values{}
+	query.Set("after", strconv.FormatUint(version, 10))
+	query.Set("wait", f.opts.Wait.String())
+
+	changes := &service.ReplicationChanges{}
+	err := f.get(ctx, "/replication/changes", query, changes)
+	if err == errs.ErrChangesExpired {
+		log.Printf("Changes of %s after version %d are no longer kept, loading a new snapshot", f.leader, version)
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	for _, change := range changes.Changes {
+		if err := f.service.ApplyReplicationChange(ctx, change); err != nil {
+			return false, fmt.Errorf("apply %s of %s at version %d: failed with: %w",
+				change.Type, change.ID, change.Version, err)
+		}
+		version = change.Version
+	}
+	f.contacted(version, changes.Version)
+
+	return true, nil
+}
+
+// contacted records a successful request to the leader, after which the follower is at the given version.
+func (f *Follower) contacted(version, leaderVersion uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.status.Connected = true
+	f.status.LastContact = &now
+	f.status.Version = version
+	f.status.LeaderVersion = leaderVersion
+	f.status.Lag = 0
+	if leaderVersion > version {
+		f.status.Lag = leaderVersion - version
+	}
+}
+
+// get sends a GET request to the given path of the leader and decodes the JSON response body into out.
+// It returns an ErrChangesExpired error if the leader replies with 410 Gone.
+func (f *Follower) get(ctx context.Context, path string, query url.Values, out any) error {
+	target := f.leader + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext: failed with: %w", err)
+	}
+	resp, err := f.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http.Client.Do: failed with: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errs.ErrChangesExpired
+	default:
+		body := map[string]string{}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("leader replied %s: %s", resp.Status, body["error"])
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("json.Decode: failed with: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+const (
+	// DefaultDataset is the name of the dataset served by the routes without a dataset.
+	DefaultDataset = "default"
+
+	// maxDatasetNameLength is the longest accepted dataset name.
+	maxDatasetNameLength = 32
+)
+
+// Dataset is a namespace of ports with its own repository and import source.
+type Dataset struct {
+	// Name identifies the dataset in the routes.
+	Name string
+
+	// Source is the file the ports of the dataset are imported from.
+	Source string
+
+	// Service serves the ports of the dataset.
+	Service *PortService
+}
+
+// DatasetStats holds the figures of a dataset.
+type DatasetStats struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Ports  int    `json:"ports"`
+}
+
+// Stats returns the figures of the dataset.
+func (d *Dataset) Stats(ctx context.Context) DatasetStats {
+	return DatasetStats{
+		Name:   d.Name,
+		Source: d.Source,
+		Ports:  d.Service.GetLength(ctx),
+	}
+}
+
+// Datasets holds the datasets served by the instance by their name.
+// It always holds the DefaultDataset.
+type Datasets struct {
+	mu       sync.RWMutex
+	datasets map[string]*Dataset
+}
+
+// NewDatasets creates a new Datasets instance holding the default dataset with the given source and service.
+func NewDatasets(source string, defaultService *PortService) *Datasets {
+	return &Datasets{
+		datasets: map[string]*Dataset{
+			DefaultDataset: {Name: DefaultDataset, Source: source, Service: defaultService},
+		},
+	}
+}
+
+// Add adds a dataset with the given name, source and service.
+// If the name is invalid, it returns an ErrInvalidDataset error,
+// and if it is already taken, it returns an ErrDatasetExists error.
+func (d *Datasets) Add(name, source string, portService *PortService) error {
+	if err := ValidateDatasetName(name); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.datasets[name]; ok {
+		return errs.ErrDatasetExists
+	}
+	d.datasets[name] = &Dataset{Name: name, Source: source, Service: portService}
+
+	return nil
+}
+
+// Get returns the dataset with the given name.
+// If there is no such dataset, it returns an ErrDatasetNotFound error.
+func (d *Datasets) Get(name string) (*Dataset, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	dataset, ok := d.datasets[name]
+	if !ok {
+		return nil, errs.ErrDatasetNotFound
+	}
+
+	return dataset, nil
+}
+
+// All returns the datasets ordered by their name.
+func (d *Datasets) All() []*Dataset {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	datasets := make([]*Dataset, 0, len(d.datasets))
+	for _, dataset := range d.datasets {
+		datasets = append(datasets, dataset)
+	}
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+
+	return datasets
+}
+
+// ValidateDatasetName validates the dataset name, which is also used in the file names of the storages.
+// It must be 1 to 32 lowercase letters, digits, dashes or underscores.
+func ValidateDatasetName(name string) error {
+	if len(name) == 0 || len(name) > maxDatasetNameLength {
+		return errs.ErrInvalidDataset
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return errs.ErrInvalidDataset
+		}
+	}
+
+	return nil
+}
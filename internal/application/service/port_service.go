@@ -3,46 +3,423 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/canbo-x/port-service/internal/application/filereader"
 	"github.com/canbo-x/port-service/internal/domain/model"
 	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/source"
 	errs "github.com/canbo-x/port-service/internal/error"
 )
 
+const (
+	// DefaultListLimit is the page size used when the caller does not provide one.
+	DefaultListLimit = 100
+
+	// MaxListLimit is the largest page size a caller can request.
+	MaxListLimit = 1000
+
+	// SourceImportPrefix prefixes the name of the port source in the source of the imported ports.
+	SourceImportPrefix = "import:"
+
+	// ImportBatchSize is the number of imported ports written to the repository as a single change.
+	ImportBatchSize = 500
+
+	// MaxSaveAttempts is the number of times a write without a version is tried on top of the concurrent ones.
+	MaxSaveAttempts = 10
+)
+
 // PortService encapsulates the logic for working with ports.
+// The service of a follower, see Follow, rejects the writes with an ErrFollowerReadOnly error.
 type PortService struct {
-	portRepo repository.PortRepository
+	// repo holds the current repository, which a reload replaces as a whole.
+	// It is read without locking, so the readers never wait for a reload.
+	repo atomic.Pointer[repositoryRef]
+
+	// reloadMu lets a single reload run at a time.
+	reloadMu sync.Mutex
+
+	// follower is set when the service follows a leader, which rejects the writes.
+	follower atomic.Pointer[follower]
+
+	// changeLog keeps the latest changes for the followers, see RunChangeLog.
+	changeLog changeLog
+}
+
+// repositoryRef wraps the repository, as the atomic pointer needs a concrete type.
+type repositoryRef struct {
+	repository.PortRepository
+
+	// replaced is closed once a reload replaces the repository.
+	replaced chan struct{}
+}
+
+// newRepositoryRef returns the reference of a repository which is not replaced yet.
+func newRepositoryRef(portRepo repository.PortRepository) *repositoryRef {
+	return &repositoryRef{PortRepository: portRepo, replaced: make(chan struct{})}
+}
+
+// RepositoryFactory creates the empty repository a reload imports the ports into.
+// It gets the current repository, which the new one is going to replace.
+type RepositoryFactory func(current repository.PortRepository) (repository.PortRepository, error)
+
+// SyncOptions holds the check a full-sync import must pass before it removes the ports missing from the source.
+type SyncOptions struct {
+	// MaxRemove is the largest share of the ports that can be removed, such as 0.1 for 10%.
+	// The check is disabled when zero.
+	MaxRemove float64
+}
+
+// ReloadOptions holds the checks a reloaded dataset must pass before it is swapped in.
+type ReloadOptions struct {
+	// MinCount is the least number of ports the reloaded dataset must have.
+	MinCount int
+
+	// MaxDelta is the largest change of the number of ports relative to the current one,
+	// such as 0.1 for 10%. The check is disabled when zero.
+	MaxDelta float64
 }
 
 // NewPortService creates a new PortService instance with the given port repository.
 func NewPortService(portRepo repository.PortRepository) *PortService {
-	return &PortService{
-		portRepo: portRepo,
-	}
+	s := &PortService{}
+	s.repo.Store(newRepositoryRef(portRepo))
+
+	return s
+}
+
+// portRepo returns the current repository.
+// Every operation uses a single repository, so it does not mix two of them across a reload.
+func (s *PortService) portRepo() repository.PortRepository {
+	return s.repo.Load().PortRepository
 }
 
 // UpsertPort inserts or updates a port in the repository.
 // If the port is nil, it returns an ErrInvalidInput error.
 func (s *PortService) UpsertPort(ctx context.Context, port *model.Port) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
 	if port == nil {
 		return errs.ErrInvalidInput
 	}
 
-	return s.portRepo.Upsert(ctx, port)
+	return s.portRepo().Upsert(ctx, port)
+}
+
+// UpsertPorts inserts or updates all the ports in the repository as a single change,
+// either all of them are written or none.
+// If any port is nil, it returns an ErrInvalidInput error.
+func (s *PortService) UpsertPorts(ctx context.Context, ports []*model.Port) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	return upsertPorts(ctx, s.portRepo(), ports)
+}
+
+// upsertPorts writes all the ports to the given repository as a single change.
+func upsertPorts(ctx context.Context, repo repository.PortRepository, ports []*model.Port) error {
+	for _, port := range ports {
+		if port == nil {
+			return errs.ErrInvalidInput
+		}
+	}
+
+	return repo.UpsertMany(ctx, ports)
 }
 
 // GetPort retrieves a port from the repository using the provided ID.
 // If the ID is invalid, it returns an appropriate error.
-// If the port is not found, it returns an ErrPortNotFound error.
+// If the port is deleted, it returns an ErrPortGone error,
+// and if it is not found at all, it returns an ErrPortNotFound error.
 func (s *PortService) GetPort(ctx context.Context, id string) (*model.Port, error) {
 	if err := model.ValidatePortID(id); err != nil {
 		return nil, err
 	}
 
-	port, err := s.portRepo.Get(ctx, id)
+	repo := s.portRepo()
+	port, err := repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if port == nil {
+		return nil, missingPortError(ctx, repo, id)
+	}
+
+	return port, nil
+}
+
+// GetPortVersioned retrieves a port together with its version from the repository using the provided ID.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port is deleted, it returns an ErrPortGone error,
+// and if it is not found at all, it returns an ErrPortNotFound error.
+func (s *PortService) GetPortVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	if err := model.ValidatePortID(id); err != nil {
+		return nil, 0, err
+	}
+
+	repo := s.portRepo()
+	port, version, err := repo.GetVersioned(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if port == nil {
+		return nil, 0, missingPortError(ctx, repo, id)
+	}
+
+	return port, version, nil
+}
+
+// missingPortError returns an ErrPortGone error if the missing port has a tombstone,
+// otherwise an ErrPortNotFound error.
+func missingPortError(ctx context.Context, repo repository.PortRepository, id string) error {
+	tombstone, err := repo.GetTombstone(ctx, id)
+	if err != nil {
+		return err
+	}
+	if tombstone != nil {
+		return errs.ErrPortGone
+	}
+
+	return errs.ErrPortNotFound
+}
+
+// SavePort validates and writes a port, and returns its new version.
+// If a version is given, the port is only written if it still has that version,
+// zero standing for a port that does not exist, otherwise it returns an ErrVersionMismatch error.
+// If no version is given and the port keeps changing, it returns an ErrWriteConflict error after MaxSaveAttempts.
+// If the port is invalid, it returns an ErrInvalidPortID or ErrInvalidInput error.
+func (s *PortService) SavePort(ctx context.Context, port *model.Port, version *uint64) (uint64, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := model.ValidatePort(port); err != nil {
+		return 0, err
+	}
+
+	repo := s.portRepo()
+	if version != nil {
+		return repo.UpsertIfVersion(ctx, port, *version)
+	}
+
+	// Without a version the write wins over the concurrent ones, so it is retried on top of them.
+	// The write is conditional all the same, so that the returned version is the one of this write.
+	for attempt := 0; attempt < MaxSaveAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		_, current, err := repo.GetVersioned(ctx, port.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		newVersion, err := repo.UpsertIfVersion(ctx, port, current)
+		if err != errs.ErrVersionMismatch {
+			return newVersion, err
+		}
+	}
+
+	return 0, errs.ErrWriteConflict
+}
+
+// DeletePortIfVersion removes the port with the provided ID if it still has the given version.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port is not found, it returns an ErrPortNotFound error,
+// and if it has another version, it returns an ErrVersionMismatch error.
+func (s *PortService) DeletePortIfVersion(ctx context.Context, id string, version uint64) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := model.ValidatePortID(id); err != nil {
+		return err
+	}
+
+	return s.portRepo().DeleteIfVersion(ctx, id, version)
+}
+
+// DeletePort removes the port with the provided ID from the repository.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port is not found, it returns an ErrPortNotFound error.
+func (s *PortService) DeletePort(ctx context.Context, id string) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := model.ValidatePortID(id); err != nil {
+		return err
+	}
+
+	return s.portRepo().Delete(ctx, id)
+}
+
+// RestorePort brings back the deleted port with the provided ID from its tombstone, and returns its new version.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port has no tombstone, it returns an ErrPortNotFound error.
+func (s *PortService) RestorePort(ctx context.Context, id string) (uint64, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := model.ValidatePortID(id); err != nil {
+		return 0, err
+	}
+
+	return s.portRepo().Restore(ctx, id)
+}
+
+// PurgeDeletedPorts removes for good the tombstones of the ports deleted longer than the retention ago,
+// and returns the number of removed tombstones.
+func (s *PortService) PurgeDeletedPorts(ctx context.Context, retention time.Duration) (int, error) {
+	return s.portRepo().PurgeTombstones(ctx, time.Now().Add(-retention))
+}
+
+// RunPurge purges the tombstones older than the retention on every interval, until the context is done.
+// A failed purge is logged and tried again on the next interval.
+func (s *PortService) RunPurge(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := s.PurgeDeletedPorts(ctx, retention)
+			if err != nil {
+				log.Printf("Error purging deleted ports: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted ports", purged)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ListPorts returns a page of ports ordered by their id, starting after the given cursor.
+// If deleted is true, the page holds the deleted ports kept as tombstones instead of the live ones.
+// A zero limit falls back to DefaultListLimit. If the limit is negative or greater
+// than MaxListLimit, it returns an ErrInvalidLimit error.
+func (s *PortService) ListPorts(
+	ctx context.Context,
+	cursor string,
+	limit int,
+	deleted bool,
+) (*repository.ListResult, error) {
+	limit, err := listLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.portRepo().List(ctx, repository.ListOptions{
+		Cursor:  cursor,
+		Limit:   limit,
+		Deleted: deleted,
+	})
+}
+
+// ListPortsAsOf returns a page of the ports as they were at the given time ordered by their id,
+// starting after the given cursor. The limit is handled as by ListPorts.
+// If the time is older than the kept history, it returns an ErrHistoryExpired error.
+// If the repository does not keep the history, it returns an ErrHistoryNotSupported error.
+func (s *PortService) ListPortsAsOf(
+	ctx context.Context,
+	at time.Time,
+	cursor string,
+	limit int,
+) (*repository.ListResult, error) {
+	limit, err := listLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	historyRepo, ok := s.portRepo().(repository.HistoryRepository)
+	if !ok {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	return historyRepo.ListAsOf(ctx, at, repository.ListOptions{
+		Cursor: cursor,
+		Limit:  limit,
+	})
+}
+
+// listLimit returns the page size of the given limit, DefaultListLimit for a zero one.
+// If the limit is negative or greater than MaxListLimit, it returns an ErrInvalidLimit error.
+func listLimit(limit int) (int, error) {
+	if limit == 0 {
+		return DefaultListLimit, nil
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return 0, errs.ErrInvalidLimit
+	}
+
+	return limit, nil
+}
+
+// GetPortHistory returns the kept revisions of the port with the provided ID ordered from the oldest.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port has no revision, it returns an ErrPortNotFound error.
+// If the repository does not keep the history, it returns an ErrHistoryNotSupported error.
+func (s *PortService) GetPortHistory(ctx context.Context, id string) ([]*repository.Revision, error) {
+	if err := model.ValidatePortID(id); err != nil {
+		return nil, err
+	}
+
+	historyRepo, ok := s.portRepo().(repository.HistoryRepository)
+	if !ok {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	return historyRepo.History(ctx, id)
+}
+
+// GetPortRevision returns the port with the provided ID as it was at the given revision.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the revision is not kept, it returns an ErrRevisionNotFound error,
+// and if the revision deleted the port, it returns an ErrPortNotFound error.
+// If the repository does not keep the history, it returns an ErrHistoryNotSupported error.
+func (s *PortService) GetPortRevision(ctx context.Context, id string, number uint64) (*model.Port, error) {
+	if err := model.ValidatePortID(id); err != nil {
+		return nil, err
+	}
+
+	historyRepo, ok := s.portRepo().(repository.HistoryRepository)
+	if !ok {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	revision, err := historyRepo.GetRevision(ctx, id, number)
+	if err != nil {
+		return nil, err
+	}
+	if revision.Deleted {
+		return nil, errs.ErrPortNotFound
+	}
+
+	return revision.Port, nil
+}
+
+// GetPortAsOf returns the port with the provided ID as it was at the given time.
+// If the ID is invalid, it returns an ErrInvalidPortID error.
+// If the port did not exist at that time, it returns an ErrPortNotFound error,
+// and if the time is older than the kept history of the port, it returns an ErrHistoryExpired error.
+// If the repository does not keep the history, it returns an ErrHistoryNotSupported error.
+func (s *PortService) GetPortAsOf(ctx context.Context, id string, at time.Time) (*model.Port, error) {
+	if err := model.ValidatePortID(id); err != nil {
+		return nil, err
+	}
+
+	historyRepo, ok := s.portRepo().(repository.HistoryRepository)
+	if !ok {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	port, err := historyRepo.GetAsOf(ctx, id, at)
 	if err != nil {
 		return nil, err
 	}
@@ -55,19 +432,215 @@ func (s *PortService) GetPort(ctx context.Context, id string) (*model.Port, erro
 
 // GetLength returns the number of ports stored in the repository.
 func (s *PortService) GetLength(ctx context.Context) int {
-	return s.portRepo.GetLength(ctx)
+	return s.portRepo().GetLength(ctx)
 }
 
-// StoreFileToDB reads ports from a JSON file and stores them in the repository.
+// StoreFileToDB reads the ports of the source and stores them in the repository.
+// The ports are written in batches of ImportBatchSize, each batch as a single change,
+// so a failure leaves the repository with the batches written before it.
 func (s *PortService) StoreFileToDB(
 	ctx context.Context,
-	fileReader *filereader.JSONFileReader,
+	src source.PortSource,
 	wg *sync.WaitGroup,
 ) error {
 	defer wg.Done()
 
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if _, err := importPorts(ctx, s.portRepo(), src, nil); err != nil {
+		return err
+	}
+	log.Printf("Ports of %s imported to DB. Number of ports in the repository: %d", src.Name(), s.GetLength(ctx))
+
+	return nil
+}
+
+// SyncFileToDB imports the ports of the source as StoreFileToDB does, then deletes the ports which were
+// stored before the import but are missing from the source, so that the repository mirrors the source.
+// The deleted ports are kept as tombstones, and the ports created during the import are kept.
+// Nothing is deleted if the import fails, and if the source skipped broken ports or more ports would be deleted
+// than the options allow, it returns an ErrSyncRejected error. It returns the number of deleted ports.
+func (s *PortService) SyncFileToDB(
+	ctx context.Context,
+	src source.PortSource,
+	opts SyncOptions,
+) (int, error) {
+	if err := s.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	repo := s.portRepo()
+	stored, err := listIDs(ctx, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]struct{})
+	skipped, err := importPorts(ctx, repo, src, seen)
+	if err != nil {
+		return 0, err
+	}
+
+	// A skipped port is missing from seen, while it may well be stored and still in the source
+	if skipped > 0 {
+		return 0, fmt.Errorf("%w: %d broken ports of the source were skipped, so the missing ports are unknown",
+			errs.ErrSyncRejected, skipped)
+	}
+
+	missing := make([]string, 0)
+	for _, id := range stored {
+		if _, ok := seen[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if err := opts.check(len(stored), len(missing)); err != nil {
+		return 0, err
+	}
+
+	// The deletes are recorded as coming from the source, like the imported ports
+	ctx = repository.WithSource(ctx, SourceImportPrefix+src.Name())
+	deleted := 0
+	for _, id := range missing {
+		err := repo.Delete(ctx, id)
+		if err == errs.ErrPortNotFound {
+			// Deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	log.Printf("Ports of %s synced to DB. Number of deleted ports: %d, number of ports in the repository: %d",
+		src.Name(), deleted, repo.GetLength(ctx))
+
+	return deleted, nil
+}
+
+// check returns an ErrSyncRejected error if removing the given number of the stored ports does not pass the options.
+func (o SyncOptions) check(stored, removed int) error {
+	if o.MaxRemove > 0 && removed > 0 && float64(removed) > o.MaxRemove*float64(stored) {
+		return fmt.Errorf("%w: %d of the %d ports would be removed, at most %.0f%% allowed",
+			errs.ErrSyncRejected, removed, stored, o.MaxRemove*100)
+	}
+
+	return nil
+}
+
+// listIDs returns the ids of all the ports stored in the repository.
+func listIDs(ctx context.Context, repo repository.PortRepository) ([]string, error) {
+	ids := make([]string, 0, repo.GetLength(ctx))
+	opts := repository.ListOptions{Limit: MaxListLimit}
+	for {
+		result, err := repo.List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, port := range result.Ports {
+			ids = append(ids, port.ID)
+		}
+
+		if result.NextCursor == "" {
+			return ids, nil
+		}
+		opts.Cursor = result.NextCursor
+	}
+}
+
+// Reload imports the ports of the source into a new repository created by the factory, and swaps it in for the current
+// one once it passes the checks of the options. The readers keep using the current repository meanwhile,
+// and never wait for the swap. The changes made to the current repository during the import are not carried over.
+// If the reloaded dataset fails the checks, it returns an ErrReloadRejected error, and if another reload
+// is running, it returns an ErrReloadInProgress error. The current repository is kept on any error.
+func (s *PortService) Reload(
+	ctx context.Context,
+	src source.PortSource,
+	newRepository RepositoryFactory,
+	opts ReloadOptions,
+) error {
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if !s.reloadMu.TryLock() {
+		return errs.ErrReloadInProgress
+	}
+	defer s.reloadMu.Unlock()
+
+	current := s.portRepo()
+	next, err := newRepository(current)
+	if err != nil {
+		return err
+	}
+
+	if _, err := importPorts(ctx, next, src, nil); err != nil {
+		return err
+	}
+	if err := opts.check(current.GetLength(ctx), next.GetLength(ctx)); err != nil {
+		return err
+	}
+
+	close(s.repo.Swap(newRepositoryRef(next)).replaced)
+	log.Printf("Ports of %s reloaded. Number of ports in the repository: %d", src.Name(), next.GetLength(ctx))
+
+	return nil
+}
+
+// check returns an ErrReloadRejected error if the number of reloaded ports does not pass the options.
+func (o ReloadOptions) check(current, next int) error {
+	if next < o.MinCount {
+		return fmt.Errorf("%w: %d ports, at least %d expected", errs.ErrReloadRejected, next, o.MinCount)
+	}
+
+	if o.MaxDelta > 0 && current > 0 {
+		delta := math.Abs(float64(next-current)) / float64(current)
+		if delta > o.MaxDelta {
+			return fmt.Errorf("%w: the number of ports goes from %d to %d, more than %.0f%% apart",
+				errs.ErrReloadRejected, current, next, o.MaxDelta*100)
+		}
+	}
+
+	return nil
+}
+
+// importPorts reads the ports of the source and writes them to the given repository
+// in batches of ImportBatchSize, each batch as a single change.
+// The ids of the read ports are added to seen, unless it is nil.
+// It returns the number of broken ports the source skipped.
+func importPorts(
+	ctx context.Context,
+	repo repository.PortRepository,
+	src source.PortSource,
+	seen map[string]struct{},
+) (int, error) {
+	// Record the imported ports as coming from the source in their history
+	ctx = repository.WithSource(ctx, SourceImportPrefix+src.Name())
+	log.Printf("Importing the ports of %s %v", src.Name(), src.Metadata())
+
+	// Log and count the broken ports skipped by the source
+	var skipped atomic.Int64
+	ctx = source.WithSkipped(ctx, func(err error) {
+		skipped.Add(1)
+		log.Printf("Skipping a broken port: %v", err)
+	})
+
+	// Stop the reading of the source when the import returns before it is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// Channels for ports and errors
-	portsCh, errCh := fileReader.ReadPorts(ctx, true)
+	portsCh, errCh := src.ReadPorts(ctx)
+
+	// Ports waiting to be written with the next batch
+	batch := make([]*model.Port, 0, ImportBatchSize)
+	flush := func() error {
+		if err := upsertPorts(ctx, repo, batch); err != nil {
+			log.Printf("Error upserting ports: %v", err)
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
 
 	// Process ports and errors from the channels
 	for {
@@ -76,10 +649,14 @@ func (s *PortService) StoreFileToDB(
 			if !ok {
 				portsCh = nil
 			} else {
-				err := s.UpsertPort(ctx, port)
-				if err != nil {
-					log.Printf("Error upserting port: %v", err)
-					return err
+				batch = append(batch, port)
+				if seen != nil {
+					seen[port.ID] = struct{}{}
+				}
+				if len(batch) == ImportBatchSize {
+					if err := flush(); err != nil {
+						return 0, err
+					}
 				}
 			}
 		case err, ok := <-errCh:
@@ -87,18 +664,19 @@ func (s *PortService) StoreFileToDB(
 				errCh = nil
 			} else {
 				log.Printf("Error reading ports: %v", err)
-				return err
+				return 0, err
 			}
 		case <-ctx.Done():
-			return ctx.Err()
+			return 0, ctx.Err()
 
 		}
 
 		if portsCh == nil && errCh == nil {
-			log.Printf("File imported to DB. Number of ports in the repository: %d", s.GetLength(ctx))
-			break
+			count := int(skipped.Load())
+			if count > 0 {
+				log.Printf("Skipped %d broken ports of %s", count, src.Name())
+			}
+			return count, flush()
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+const (
+	// DefaultChangeLogSize is the number of changes kept for the followers when a non-positive size is requested.
+	DefaultChangeLogSize = 10000
+
+	// MaxChangesWait is the longest time a request for changes waits for new ones.
+	// It stays below the write timeout of the HTTP server.
+	MaxChangesWait = 5 * time.Second
+
+	// MaxChangesBatch is the number of changes sent at most in reply to a request for changes.
+	// The changes sharing a version are never split, so a batch can hold a few more.
+	MaxChangesBatch = 1000
+
+	// RoleLeader and RoleFollower are the replication roles of an instance.
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// ReplicationSnapshot is the whole content of a dataset sent to a follower to start from.
+type ReplicationSnapshot struct {
+	Version    uint64                 `json:"version"`
+	Ports      []*model.Port          `json:"ports"`
+	Versions   map[string]uint64      `json:"versions"`
+	Tombstones []ReplicationTombstone `json:"tombstones"`
+}
+
+// ReplicationTombstone is a deleted port in a ReplicationSnapshot.
+type ReplicationTombstone struct {
+	Port      *model.Port `json:"port"`
+	DeletedAt time.Time   `json:"deleted_at"`
+}
+
+// ReplicationChanges holds the changes sent to a follower, ordered by their version,
+// together with the version of the last change applied by the leader.
+type ReplicationChanges struct {
+	Version uint64              `json:"version"`
+	Changes []ReplicationChange `json:"changes"`
+}
+
+// ReplicationChange is a change of a port in ReplicationChanges.
+// The type is created, updated, deleted or purged, and the port is the one after the change,
+// absent for a delete or a purge.
+type ReplicationChange struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id"`
+	Version uint64      `json:"version"`
+	Port    *model.Port `json:"port,omitempty"`
+}
+
+// ReplicationStatus describes the replication of a dataset.
+type ReplicationStatus struct {
+	// Role is RoleLeader or RoleFollower.
+	Role string `json:"role"`
+
+	// Leader is the URL of the leader followed by a follower.
+	Leader string `json:"leader,omitempty"`
+
+	// Connected reports whether the last request of a follower to its leader succeeded.
+	Connected bool `json:"connected"`
+
+	// Version is the version of the last applied change.
+	Version uint64 `json:"version"`
+
+	// LeaderVersion is the version of the last change applied by the leader, as last seen by a follower.
+	LeaderVersion uint64 `json:"leader_version,omitempty"`
+
+	// Lag is the number of versions a follower is behind its leader.
+	Lag uint64 `json:"lag"`
+
+	// LastContact is the time of the last successful request of a follower to its leader.
+	LastContact *time.Time `json:"last_contact,omitempty"`
+}
+
+// follower is the leader followed by the service, and the function reporting the replication status.
+type follower struct {
+	leader string
+	status func() ReplicationStatus
+}
+
+// changeLog keeps the latest changes of a repository for the followers.
+type changeLog struct {
+	mu sync.Mutex
+
+	// source is the recorded repository, nil until the log runs.
+	source repository.Replicable
+
+	// since is the version after which the log holds every change.
+	since uint64
+
+	// changes holds at most size changes, ordered by their version.
+	changes []repository.Change
+	size    int
+
+	// updated is closed and replaced whenever the log changes.
+	updated chan struct{}
+}
+
+// Follow marks the service as a follower of the leader at the given URL, whose status is reported by the function.
+// From then on, the service rejects the writes with an ErrFollowerReadOnly error, the ports being written by the
+// replication only.
+func (s *PortService) Follow(leader string, status func() ReplicationStatus) {
+	s.follower.Store(&follower{leader: leader, status: status})
+}
+
+// Leader returns the URL of the leader followed by the service, or an empty string if it is not a follower.
+func (s *PortService) Leader() string {
+	if f := s.follower.Load(); f != nil {
+		return f.leader
+	}
+
+	return ""
+}
+
+// checkWritable returns an ErrFollowerReadOnly error if the service is a follower.
+func (s *PortService) checkWritable() error {
+	if s.follower.Load() != nil {
+		return errs.ErrFollowerReadOnly
+	}
+
+	return nil
+}
+
+// ReplicationStatus returns the replication status of the service.
+// If the storage cannot be replicated, it returns an ErrReplicationNotSupported error.
+func (s *PortService) ReplicationStatus(ctx context.Context) (ReplicationStatus, error) {
+	if f := s.follower.Load(); f != nil {
+		return f.status(), nil
+	}
+
+	source, ok := s.portRepo().(repository.Replicable)
+	if !ok {
+		return ReplicationStatus{}, errs.ErrReplicationNotSupported
+	}
+
+	return ReplicationStatus{Role: RoleLeader, Connected: true, Version: source.Version(ctx)}, nil
+}
+
+// ReplicationSnapshot returns the whole content of the repository for a follower to start from.
+// If the storage cannot be replicated, it returns an ErrReplicationNotSupported error.
+func (s *PortService) ReplicationSnapshot(ctx context.Context) (*ReplicationSnapshot, error) {
+	source, ok := s.portRepo().(repository.Replicable)
+	if !ok {
+		return nil, errs.ErrReplicationNotSupported
+	}
+
+	snapshot, err := source.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ReplicationSnapshot{
+		Version:    snapshot.Version,
+		Ports:      snapshot.Ports,
+		Versions:   snapshot.Versions,
+		Tombstones: make([]ReplicationTombstone, 0, len(snapshot.Tombstones)),
+	}
+	for _, tombstone := range snapshot.Tombstones {
+		resp.Tombstones = append(resp.Tombstones, ReplicationTombstone{
+			Port:      tombstone.Port,
+			DeletedAt: tombstone.DeletedAt,
+		})
+	}
+
+	return resp, nil
+}
+
+// ChangesSince returns the recorded changes made after the given version, waiting up to the given time,
+// capped to MaxChangesWait, for one to be made if there is none yet. It returns no change once the time is up.
+// If the changes after the version are no longer kept, or the version is ahead of the repository,
+// it returns an ErrChangesExpired error and the follower has to start over from a snapshot.
+// If the changes are not recorded, see RunChangeLog, it returns an ErrReplicationNotSupported error.
+func (s *PortService) ChangesSince(ctx context.Context, after uint64, wait time.Duration) (*ReplicationChanges, error) {
+	if wait > MaxChangesWait {
+		wait = MaxChangesWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		changes, updated, err := s.changeLog.after(ctx, after, s.portRepo())
+		if err != nil || len(changes.Changes) > 0 {
+			return changes, err
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return changes, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ResetReplica replaces the whole content of the repository with the snapshot of the leader.
+// If the storage cannot be replicated, it returns an ErrReplicationNotSupported error.
+func (s *PortService) ResetReplica(ctx context.Context, snapshot *ReplicationSnapshot) error {
+	replica, ok := s.portRepo().(repository.Replicable)
+	if !ok {
+		return errs.ErrReplicationNotSupported
+	}
+
+	tombstones := make([]*repository.Tombstone, 0, len(snapshot.Tombstones))
+	for _, tombstone := range snapshot.Tombstones {
+		if tombstone.Port == nil {
+			return errs.ErrInvalidInput
+		}
+		tombstones = append(tombstones, &repository.Tombstone{Port: tombstone.Port, DeletedAt: tombstone.DeletedAt})
+	}
+	for _, port := range snapshot.Ports {
+		if port == nil {
+			return errs.ErrInvalidInput
+		}
+	}
+
+	return replica.ResetReplica(ctx, &repository.Snapshot{
+		Version:    snapshot.Version,
+		Ports:      snapshot.Ports,
+		Versions:   snapshot.Versions,
+		Tombstones: tombstones,
+	})
+}
+
+// ApplyReplicationChange applies a change sent by the leader, the changes being applied in the order they were sent.
+// If the change is invalid or does not follow the last applied one, it returns an ErrInvalidInput error,
+// and if the storage cannot be replicated, it returns an ErrReplicationNotSupported error.
+func (s *PortService) ApplyReplicationChange(ctx context.Context, change ReplicationChange) error {
+	replica, ok := s.portRepo().(repository.Replicable)
+	if !ok {
+		return errs.ErrReplicationNotSupported
+	}
+
+	changeType, ok := parseChangeType(change.Type)
+	if !ok {
+		return errs.ErrInvalidInput
+	}
+
+	return replica.ApplyReplica(ctx, repository.Change{
+		Type:    changeType,
+		ID:      change.ID,
+		Version: change.Version,
+		New:     change.Port,
+	})
+}
+
+// parseChangeType returns the type of a change sent to the followers by its name.
+func parseChangeType(name string) (repository.ChangeType, bool) {
+	for _, changeType := range []repository.ChangeType{
+		repository.ChangeCreated,
+		repository.ChangeUpdated,
+		repository.ChangeDeleted,
+		repository.ChangePurged,
+	} {
+		if changeType.String() == name {
+			return changeType, true
+		}
+	}
+
+	return 0, false
+}
+
+// RunChangeLog records the changes of the repository for the followers until the context is done,
+// keeping the latest size of them. A non-positive size falls back to DefaultChangeLogSize.
+// The log starts over whenever it misses changes, or a reload replaces the repository.
+// It returns right away if the storage cannot be replicated.
+func (s *PortService) RunChangeLog(ctx context.Context, size int) {
+	if size <= 0 {
+		size = DefaultChangeLogSize
+	}
+
+	for ctx.Err() == nil {
+		ref := s.repo.Load()
+		source, ok := ref.PortRepository.(repository.Replicable)
+		if !ok {
+			log.Printf("Replication is not supported by the storage, the changes are not recorded")
+			return
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		changes, err := source.Watch(watchCtx, repository.WatchFilter{})
+		if err != nil {
+			cancel()
+			return
+		}
+
+		// The changes made between the watch and the reset are sent again, and skipped as already held
+		s.changeLog.reset(source, source.Version(ctx), size)
+		s.recordChanges(ctx, changes, ref.replaced)
+		cancel()
+	}
+}
+
+// recordChanges appends the watched changes of the repository to the change log, until the watch misses changes,
+// the replaced channel of the repository is closed by a reload or the context is done.
+func (s *PortService) recordChanges(
+	ctx context.Context,
+	changes <-chan repository.Change,
+	replaced <-chan struct{},
+) {
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok || change.Type == repository.ChangeResync {
+				return
+			}
+			s.changeLog.append(change)
+		case <-replaced:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reset makes the log record the changes of the source made after the given version.
+func (l *changeLog) reset(source repository.Replicable, version uint64, size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.source = source
+	l.since = version
+	l.changes = nil
+	l.size = size
+	l.notify()
+}
+
+// append records the change, dropping the oldest ones over the size.
+func (l *changeLog) append(change repository.Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if change.Version <= l.since {
+		return
+	}
+
+	l.changes = append(l.changes, change)
+	if over := len(l.changes) - l.size; over > 0 {
+		// The dropped changes may share their version with kept ones, which are not enough to replay it
+		l.since = l.changes[over-1].Version
+		l.changes = append(l.changes[:0:0], l.changes[over:]...)
+	}
+	l.notify()
+}
+
+// notify wakes up the requests waiting for the log to change.
+// The caller must hold the lock.
+func (l *changeLog) notify() {
+	if l.updated != nil {
+		close(l.updated)
+	}
+	l.updated = make(chan struct{})
+}
+
+// after returns the recorded changes made after the given version, up to MaxChangesBatch of them,
+// and the channel closed once the log changes. The current repository is the one served by the service.
+func (l *changeLog) after(
+	ctx context.Context,
+	after uint64,
+	current repository.PortRepository,
+) (*ReplicationChanges, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.source == nil {
+		return nil, nil, errs.ErrReplicationNotSupported
+	}
+	if any(l.source) != any(current) {
+		// A reload replaced the repository, and the log is about to start over with the new one
+		return &ReplicationChanges{Version: after, Changes: make([]ReplicationChange, 0)}, l.updated, nil
+	}
+
+	version := l.source.Version(ctx)
+	if after < l.since || after > version {
+		return nil, nil, errs.ErrChangesExpired
+	}
+
+	resp := &ReplicationChanges{Version: version, Changes: make([]ReplicationChange, 0)}
+	i := sort.Search(len(l.changes), func(i int) bool { return l.changes[i].Version > after })
+	for ; i < len(l.changes); i++ {
+		change := l.changes[i]
+
+		// The changes of a version are applied together, so a batch never ends in the middle of them
+		if len(resp.Changes) >= MaxChangesBatch && change.Version != resp.Changes[len(resp.Changes)-1].Version {
+			break
+		}
+		resp.Changes = append(resp.Changes, ReplicationChange{
+			Type:    change.Type.String(),
+			ID:      change.ID,
+			Version: change.Version,
+			Port:    change.New,
+		})
+	}
+
+	return resp, l.updated, nil
+}
@@ -13,4 +13,31 @@ type Port struct {
 	Timezone    string    `json:"timezone"`
 	Unlocs      []string  `json:"unlocs"`
 	Code        string    `json:"code"`
+
+	// Function is the UN/LOCODE function classifier, such as "1-3-----" for a port with a road terminal.
+	Function string `json:"function,omitempty"`
+
+	// Status is the UN/LOCODE status of the entry, such as "AI" for a code adopted by an international organisation.
+	Status string `json:"status,omitempty"`
+}
+
+// Clone returns a deep copy of the port.
+// Empty lists stay empty and missing lists stay missing.
+func (p *Port) Clone() *Port {
+	clone := *p
+	clone.Alias = cloneSlice(p.Alias)
+	clone.Regions = cloneSlice(p.Regions)
+	clone.Coordinates = cloneSlice(p.Coordinates)
+	clone.Unlocs = cloneSlice(p.Unlocs)
+
+	return &clone
+}
+
+// cloneSlice returns a copy of the slice which is nil only if the slice is nil.
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+
+	return append(make([]T, 0, len(s)), s...)
 }
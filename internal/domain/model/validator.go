@@ -15,3 +15,19 @@ func ValidatePortID(id string) error {
 
 	return nil
 }
+
+// ValidatePort validates a port written through the API.
+// The coordinates must be either missing or a longitude and latitude pair.
+func ValidatePort(port *Port) error {
+	if port == nil {
+		return errs.ErrInvalidInput
+	}
+	if err := ValidatePortID(port.ID); err != nil {
+		return err
+	}
+	if len(port.Coordinates) != 0 && len(port.Coordinates) != 2 {
+		return errs.ErrInvalidInput
+	}
+
+	return nil
+}
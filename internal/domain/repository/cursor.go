@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"encoding/base64"
+
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// EncodeCursor returns an opaque cursor that points right after the port with the given id.
+// Every repository implementation should use it so that cursors stay interchangeable.
+func EncodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// DecodeCursor returns the port id encoded in the given cursor.
+// An empty cursor decodes to an empty id, which means the beginning of the listing.
+func DecodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", errs.ErrInvalidCursor
+	}
+
+	return string(id), nil
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// Revision is a recorded change of a port.
+type Revision struct {
+	// Number identifies the revision among the ones of the port.
+	// It starts at 1 and grows by one on every change, including the deletes.
+	Number uint64
+
+	// Timestamp is the time of the change.
+	Timestamp time.Time
+
+	// Source describes where the change came from, see WithSource.
+	Source string
+
+	// Deleted is set when the change removed the port.
+	Deleted bool
+
+	// Port is the state of the port after the change, nil when it was deleted.
+	Port *model.Port
+}
+
+// HistoryRepository is implemented by the repositories keeping the recent revisions of the ports.
+// The history is optional, so the callers check for it with a type assertion.
+type HistoryRepository interface {
+	// History returns the kept revisions of the port ordered from the oldest.
+	// It returns errs.ErrPortNotFound if the port has no revision.
+	History(ctx context.Context, id string) ([]*Revision, error)
+
+	// GetRevision returns the revision of the port with the given number.
+	// It returns errs.ErrRevisionNotFound if there is no such revision or it is no longer kept.
+	GetRevision(ctx context.Context, id string, number uint64) (*Revision, error)
+
+	// GetAsOf returns the port with the given id as it was at the given time.
+	// It returns nil and no error if the port did not exist at that time,
+	// or errs.ErrHistoryExpired if the time is older than the kept revisions of the port.
+	GetAsOf(ctx context.Context, id string, at time.Time) (*model.Port, error)
+
+	// ListAsOf returns a page of the ports as they were at the given time, ordered by their id.
+	// The options are the ones of PortRepository.List, except for Deleted which is not supported.
+	// It returns errs.ErrHistoryExpired if the time is older than the kept revisions of a listed port.
+	ListAsOf(ctx context.Context, at time.Time, opts ListOptions) (*ListResult, error)
+}
+
+// sourceKey is the context key of the change source.
+type sourceKey struct{}
+
+// WithSource returns a copy of the context which marks the changes made with it
+// as coming from the given source, such as an import or the API.
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the change source set by WithSource, or an empty string.
+func SourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
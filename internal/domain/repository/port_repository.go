@@ -2,18 +2,102 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/canbo-x/port-service/internal/domain/model"
 )
 
 // PortRepository defines the interface for the port repository.
+// Every implementation is expected to pass the repositorytest conformance suite.
+// All the methods return the context error once the context is done.
+//
+// Every write gives the port a new version, which is greater than any version the port had before,
+// even if it was deleted in between, so a stale version never matches again.
+//
+// The deletes are soft: a deleted port is left out of the reads, but it is kept in a tombstone
+// from which it can be restored, until the tombstone is purged.
 type PortRepository interface {
 	// Upsert inserts or updates a port in the repository.
+	// Writing a deleted port drops its tombstone.
 	Upsert(ctx context.Context, port *model.Port) error
 
+	// UpsertMany inserts or updates all the given ports as a single change, so either all of them
+	// are written or none of them is. The later ports win over the earlier ones with the same id.
+	// It returns errs.ErrInvalidInput if any of the ports is nil.
+	UpsertMany(ctx context.Context, ports []*model.Port) error
+
 	// Get returns the port with the given id.
+	// It returns nil and no error if there is no such port.
 	Get(ctx context.Context, id string) (*model.Port, error)
 
+	// Delete removes the port with the given id and leaves a tombstone in its place.
+	// It returns errs.ErrPortNotFound if there is no such port.
+	Delete(ctx context.Context, id string) error
+
+	// GetVersioned returns the port with the given id together with its version.
+	// It returns nil, zero and no error if there is no such port.
+	GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error)
+
+	// UpsertIfVersion inserts or updates a port only if its current version is the given one,
+	// zero standing for a port that does not exist. It returns the new version of the port,
+	// or errs.ErrVersionMismatch if the port was changed in the meantime.
+	UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error)
+
+	// DeleteIfVersion removes the port with the given id like Delete, only if its current version is the given one.
+	// It returns errs.ErrPortNotFound if there is no such port,
+	// or errs.ErrVersionMismatch if the port was changed in the meantime.
+	DeleteIfVersion(ctx context.Context, id string, version uint64) error
+
+	// GetTombstone returns the tombstone of the deleted port with the given id.
+	// It returns nil and no error if there is no such tombstone.
+	GetTombstone(ctx context.Context, id string) (*Tombstone, error)
+
+	// Restore brings the deleted port with the given id back from its tombstone and returns its new version.
+	// It returns errs.ErrPortNotFound if there is no such tombstone.
+	Restore(ctx context.Context, id string) (uint64, error)
+
+	// PurgeTombstones removes for good the tombstones of the ports deleted before the given time.
+	// It returns the number of removed tombstones.
+	PurgeTombstones(ctx context.Context, before time.Time) (int, error)
+
+	// FindByCountry returns the ports of the given country ordered by their id.
+	FindByCountry(ctx context.Context, country string) ([]*model.Port, error)
+
+	// FindByUnloc returns the ports listing the given UN/LOCODE ordered by their id.
+	FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error)
+
+	// FindByCode returns the ports having the given code ordered by their id.
+	FindByCode(ctx context.Context, code string) ([]*model.Port, error)
+
+	// FindByTimezone returns the ports in the given timezone ordered by their id.
+	FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error)
+
 	// GetLength returns the number of ports in the repository.
 	GetLength(ctx context.Context) int
+
+	// List returns a page of ports ordered by their id.
+	List(ctx context.Context, opts ListOptions) (*ListResult, error)
+}
+
+// ListOptions holds the parameters of a List call.
+type ListOptions struct {
+	// Cursor is the opaque position returned by a previous List call.
+	// An empty cursor starts from the first port.
+	Cursor string
+
+	// Limit is the maximum number of ports to return. It must be positive.
+	Limit int
+
+	// Deleted lists the deleted ports kept in tombstones instead of the live ones.
+	Deleted bool
+}
+
+// ListResult holds a single page of ports.
+type ListResult struct {
+	// Ports contains the ports of the page ordered by their id.
+	Ports []*model.Port
+
+	// NextCursor is the cursor of the following page.
+	// It is empty when there are no more ports.
+	NextCursor string
 }
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// Snapshot is the whole content of a repository at a version, which a replica starts from.
+type Snapshot struct {
+	// Version is the version of the last change the snapshot reflects.
+	Version uint64
+
+	// Ports holds the stored ports ordered by their id.
+	Ports []*model.Port
+
+	// Versions holds the version of every stored port by its id.
+	Versions map[string]uint64
+
+	// Tombstones holds the deleted ports ordered by their id.
+	Tombstones []*Tombstone
+}
+
+// Replicable is implemented by the repositories which can be copied to other instances, by sending a snapshot
+// followed by the watched changes. The replication is optional, so the callers check for it with a type assertion.
+type Replicable interface {
+	Watcher
+
+	// Version returns the version of the last applied change, which is zero for an empty repository.
+	Version(ctx context.Context) uint64
+
+	// Snapshot returns the whole content of the repository.
+	Snapshot(ctx context.Context) (*Snapshot, error)
+
+	// ResetReplica replaces the whole content of the repository with the snapshot of another one,
+	// the watchers are sent a ChangeResync.
+	ResetReplica(ctx context.Context, snapshot *Snapshot) error
+
+	// ApplyReplica applies a change watched on another repository, the port getting the version of the change.
+	// The changes must be applied in the order they were watched. It returns errs.ErrInvalidInput
+	// if the change is older than the last applied one, and errs.ErrPortNotFound if a deleted or purged port
+	// does not exist.
+	ApplyReplica(ctx context.Context, change Change) error
+}
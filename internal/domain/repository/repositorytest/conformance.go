@@ -0,0 +1,586 @@
+// Package repositorytest contains a conformance test suite for the PortRepository implementations.
+//
+// Every implementation is expected to pass it, so that they can replace each other
+// without any change in the behavior seen by the service:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func() repository.PortRepository {
+//			return NewMyRepository()
+//		})
+//	}
+package repositorytest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// Factory creates a new, empty repository for a single test case.
+// Any cleanup should be registered on the test that calls Run.
+type Factory func() repository.PortRepository
+
+// Run runs the conformance suite against the repositories created by the factory.
+// Every test case gets its own repository. Run it with -race to also check the
+// concurrent access.
+func Run(t *testing.T, newRepository Factory) {
+	t.Helper()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, repo repository.PortRepository)
+	}{
+		{name: "UpsertAndGet", testFunc: testUpsertAndGet},
+		{name: "UpsertReplaces", testFunc: testUpsertReplaces},
+		{name: "UpsertMany", testFunc: testUpsertMany},
+		{name: "GetNotFound", testFunc: testGetNotFound},
+		{name: "Delete", testFunc: testDelete},
+		{name: "List", testFunc: testList},
+		{name: "ListInvalidOptions", testFunc: testListInvalidOptions},
+		{name: "FindBy", testFunc: testFindBy},
+		{name: "Versions", testFunc: testVersions},
+		{name: "ConditionalDelete", testFunc: testConditionalDelete},
+		{name: "SoftDelete", testFunc: testSoftDelete},
+		{name: "PurgeTombstones", testFunc: testPurgeTombstones},
+		{name: "ContextCancellation", testFunc: testContextCancellation},
+		{name: "ConcurrentAccess", testFunc: testConcurrentAccess},
+		{name: "ConcurrentCompareAndSwap", testFunc: testConcurrentCompareAndSwap},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			tc.testFunc(t, newRepository())
+		})
+	}
+}
+
+// NewPort returns a fully populated port with the given id and country.
+func NewPort(id, country string) *model.Port {
+	return &model.Port{
+		ID:          id,
+		Name:        "Port " + id,
+		City:        "City " + id,
+		Country:     country,
+		Alias:       []string{"Alias " + id},
+		Regions:     []string{},
+		Coordinates: []float64{-0.0833, 51.5},
+		Province:    "Province " + id,
+		Timezone:    "Europe/London",
+		Unlocs:      []string{id},
+		Code:        "12345",
+		Function:    "1-3-----",
+		Status:      "AI",
+	}
+}
+
+func testUpsertAndGet(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+	port := NewPort("GBLON", "United Kingdom")
+
+	require.NoError(t, repo.Upsert(ctx, port))
+
+	retrievedPort, err := repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, port, retrievedPort)
+	assert.Equal(t, 1, repo.GetLength(ctx))
+}
+
+func testUpsertReplaces(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "United Kingdom")))
+
+	updated := NewPort("GBLON", "United Kingdom")
+	updated.Name = "London"
+	updated.Alias = []string{"Londres", "Londra"}
+	require.NoError(t, repo.Upsert(ctx, updated))
+
+	retrievedPort, err := repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, updated, retrievedPort)
+	assert.Equal(t, 1, repo.GetLength(ctx))
+}
+
+func testUpsertMany(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	require.NoError(t, repo.UpsertMany(ctx, nil))
+	assert.Equal(t, 0, repo.GetLength(ctx))
+
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "United Kingdom")))
+	_, before, err := repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+
+	// The later port wins when the batch has the same id twice
+	batch := []*model.Port{
+		NewPort("GBLON", "France"),
+		NewPort("FRPAR", "France"),
+		NewPort("GBLON", "Germany"),
+	}
+	require.NoError(t, repo.UpsertMany(ctx, batch))
+	assert.Equal(t, 2, repo.GetLength(ctx))
+
+	port, version, err := repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, NewPort("GBLON", "Germany"), port)
+	assert.Greater(t, version, before)
+
+	port, err = repo.Get(ctx, "FRPAR")
+	require.NoError(t, err)
+	assert.Equal(t, NewPort("FRPAR", "France"), port)
+
+	// A batch with an invalid port writes nothing
+	err = repo.UpsertMany(ctx, []*model.Port{NewPort("ESMAD", "Spain"), nil})
+	assert.ErrorIs(t, err, errs.ErrInvalidInput)
+
+	port, err = repo.Get(ctx, "ESMAD")
+	require.NoError(t, err)
+	assert.Nil(t, port)
+	assert.Equal(t, 2, repo.GetLength(ctx))
+}
+
+func testGetNotFound(t *testing.T, repo repository.PortRepository) {
+	// A missing port is not an error for the repository, the service turns it into ErrPortNotFound
+	retrievedPort, err := repo.Get(context.Background(), "NOPORT")
+	require.NoError(t, err)
+	assert.Nil(t, retrievedPort)
+}
+
+func testDelete(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "United Kingdom")))
+	require.NoError(t, repo.Upsert(ctx, NewPort("FRPAR", "France")))
+
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+
+	retrievedPort, err := repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, retrievedPort)
+	assert.Equal(t, 1, repo.GetLength(ctx))
+
+	assert.ErrorIs(t, repo.Delete(ctx, "GBLON"), errs.ErrPortNotFound)
+	assert.ErrorIs(t, repo.Delete(ctx, "NOPORT"), errs.ErrPortNotFound)
+}
+
+func testList(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	// Insert the ports out of order
+	expected := []string{"AEAJM", "DEHAM", "FRPAR", "GBLON", "NLRTM"}
+	for _, id := range []string{"GBLON", "AEAJM", "NLRTM", "FRPAR", "DEHAM"} {
+		require.NoError(t, repo.Upsert(ctx, NewPort(id, "")))
+	}
+
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < len(expected); pages++ {
+		page, err := repo.List(ctx, repository.ListOptions{Cursor: cursor, Limit: 2})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Ports), 2)
+
+		for _, port := range page.Ports {
+			ids = append(ids, port.ID)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, expected, ids)
+
+	// An exact fit must not announce an empty next page
+	page, err := repo.List(ctx, repository.ListOptions{Limit: len(expected)})
+	require.NoError(t, err)
+	assert.Len(t, page.Ports, len(expected))
+	assert.Empty(t, page.NextCursor)
+
+	// Deleted ports disappear from the listing
+	require.NoError(t, repo.Delete(ctx, "DEHAM"))
+	page, err = repo.List(ctx, repository.ListOptions{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Ports, 2)
+	assert.Equal(t, "AEAJM", page.Ports[0].ID)
+	assert.Equal(t, "FRPAR", page.Ports[1].ID)
+}
+
+func testListInvalidOptions(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	_, err := repo.List(ctx, repository.ListOptions{Limit: 0})
+	assert.ErrorIs(t, err, errs.ErrInvalidLimit)
+
+	_, err = repo.List(ctx, repository.ListOptions{Cursor: "!!!", Limit: 10})
+	assert.ErrorIs(t, err, errs.ErrInvalidCursor)
+
+	// An empty repository returns an empty page, not nil
+	page, err := repo.List(ctx, repository.ListOptions{Limit: 10})
+	require.NoError(t, err)
+	assert.NotNil(t, page.Ports)
+	assert.Empty(t, page.Ports)
+	assert.Empty(t, page.NextCursor)
+}
+
+func testFindBy(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	london := NewPort("GBLON", "United Kingdom")
+	southampton := NewPort("GBSOU", "United Kingdom")
+	southampton.Unlocs = []string{"GBSOU", "GBSO2"}
+	paris := NewPort("FRPAR", "France")
+	paris.Code = "23456"
+	paris.Timezone = "Europe/Paris"
+	for _, port := range []*model.Port{southampton, paris, london} {
+		require.NoError(t, repo.Upsert(ctx, port))
+	}
+
+	assertIDs := func(expected []string, ports []*model.Port, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		require.NotNil(t, ports)
+
+		ids := make([]string, 0, len(ports))
+		for _, port := range ports {
+			ids = append(ids, port.ID)
+		}
+		assert.Equal(t, expected, ids)
+	}
+
+	ports, err := repo.FindByCountry(ctx, "United Kingdom")
+	assertIDs([]string{"GBLON", "GBSOU"}, ports, err)
+
+	ports, err = repo.FindByUnloc(ctx, "GBSO2")
+	assertIDs([]string{"GBSOU"}, ports, err)
+
+	ports, err = repo.FindByCode(ctx, "23456")
+	assertIDs([]string{"FRPAR"}, ports, err)
+
+	ports, err = repo.FindByTimezone(ctx, "Europe/London")
+	assertIDs([]string{"GBLON", "GBSOU"}, ports, err)
+
+	// Values are matched exactly, an empty value matches nothing
+	ports, err = repo.FindByCountry(ctx, "United")
+	assertIDs([]string{}, ports, err)
+
+	ports, err = repo.FindByCountry(ctx, "")
+	assertIDs([]string{}, ports, err)
+
+	// Updates must drop the stale entries
+	moved := NewPort("GBSOU", "France")
+	require.NoError(t, repo.Upsert(ctx, moved))
+
+	ports, err = repo.FindByCountry(ctx, "United Kingdom")
+	assertIDs([]string{"GBLON"}, ports, err)
+
+	ports, err = repo.FindByCountry(ctx, "France")
+	assertIDs([]string{"FRPAR", "GBSOU"}, ports, err)
+
+	ports, err = repo.FindByUnloc(ctx, "GBSO2")
+	assertIDs([]string{}, ports, err)
+
+	// Deletes must drop the entries as well
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+	ports, err = repo.FindByTimezone(ctx, "Europe/London")
+	assertIDs([]string{"GBSOU"}, ports, err)
+}
+
+func testVersions(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	port, version, err := repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, port)
+	assert.Zero(t, version)
+
+	// Zero creates the port only if it does not exist yet
+	created, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "United Kingdom"), 0)
+	require.NoError(t, err)
+	assert.NotZero(t, created)
+
+	_, err = repo.UpsertIfVersion(ctx, NewPort("GBLON", "France"), 0)
+	assert.ErrorIs(t, err, errs.ErrVersionMismatch)
+
+	port, version, err = repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, NewPort("GBLON", "United Kingdom"), port)
+	assert.Equal(t, created, version)
+
+	// A matching version moves the port to a greater one
+	updated, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "France"), created)
+	require.NoError(t, err)
+	assert.Greater(t, updated, created)
+
+	_, err = repo.UpsertIfVersion(ctx, NewPort("GBLON", "Germany"), created)
+	assert.ErrorIs(t, err, errs.ErrVersionMismatch)
+
+	// Unconditional writes also move the version
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "Spain")))
+	port, version, err = repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, "Spain", port.Country)
+	assert.Greater(t, version, updated)
+
+	_, err = repo.UpsertIfVersion(ctx, NewPort("GBLON", "Germany"), updated)
+	assert.ErrorIs(t, err, errs.ErrVersionMismatch)
+
+	// A port created again never gets back an old version
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+	recreated, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "Italy"), 0)
+	require.NoError(t, err)
+	assert.Greater(t, recreated, version)
+
+	// The rejected writes must not have changed the port
+	port, err = repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, "Italy", port.Country)
+}
+
+func testConditionalDelete(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	assert.ErrorIs(t, repo.DeleteIfVersion(ctx, "GBLON", 1), errs.ErrPortNotFound)
+
+	version, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "United Kingdom"), 0)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, repo.DeleteIfVersion(ctx, "GBLON", version+1), errs.ErrVersionMismatch)
+	port, err := repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.NotNil(t, port)
+
+	require.NoError(t, repo.DeleteIfVersion(ctx, "GBLON", version))
+	port, err = repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, port)
+	assert.Equal(t, 0, repo.GetLength(ctx))
+}
+
+func testSoftDelete(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	_, err := repo.Restore(ctx, "GBLON")
+	assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "United Kingdom")))
+	require.NoError(t, repo.Upsert(ctx, NewPort("FRPAR", "France")))
+	_, version, err := repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+
+	// A live port has no tombstone and cannot be restored
+	tombstone, err := repo.GetTombstone(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, tombstone)
+	_, err = repo.Restore(ctx, "GBLON")
+	assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+
+	// The deleted port is left out of the reads
+	port, err := repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, port)
+	assert.Equal(t, 1, repo.GetLength(ctx))
+	found, err := repo.FindByCountry(ctx, "United Kingdom")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	assert.ErrorIs(t, repo.Delete(ctx, "GBLON"), errs.ErrPortNotFound)
+
+	page, err := repo.List(ctx, repository.ListOptions{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Ports, 1)
+	assert.Equal(t, "FRPAR", page.Ports[0].ID)
+
+	// But it is kept in its tombstone
+	tombstone, err = repo.GetTombstone(ctx, "GBLON")
+	require.NoError(t, err)
+	require.NotNil(t, tombstone)
+	assert.Equal(t, NewPort("GBLON", "United Kingdom"), tombstone.Port)
+	assert.True(t, tombstone.DeletedAt.After(before))
+
+	page, err = repo.List(ctx, repository.ListOptions{Limit: 10, Deleted: true})
+	require.NoError(t, err)
+	require.Len(t, page.Ports, 1)
+	assert.Equal(t, NewPort("GBLON", "United Kingdom"), page.Ports[0])
+
+	// The restored port comes back with a new version
+	restored, err := repo.Restore(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Greater(t, restored, version)
+
+	port, current, err := repo.GetVersioned(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, NewPort("GBLON", "United Kingdom"), port)
+	assert.Equal(t, restored, current)
+	assert.Equal(t, 2, repo.GetLength(ctx))
+
+	tombstone, err = repo.GetTombstone(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, tombstone)
+
+	// Writing a deleted port drops its tombstone
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+	require.NoError(t, repo.Upsert(ctx, NewPort("GBLON", "Spain")))
+	tombstone, err = repo.GetTombstone(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, tombstone)
+	_, err = repo.Restore(ctx, "GBLON")
+	assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+	port, err = repo.Get(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Equal(t, "Spain", port.Country)
+}
+
+func testPurgeTombstones(t *testing.T, repo repository.PortRepository) {
+	ctx := context.Background()
+
+	for _, id := range []string{"GBLON", "FRPAR", "ESMAD"} {
+		require.NoError(t, repo.Upsert(ctx, NewPort(id, "Country")))
+	}
+	require.NoError(t, repo.Delete(ctx, "GBLON"))
+	require.NoError(t, repo.Delete(ctx, "FRPAR"))
+
+	// The tombstones more recent than the given time are kept
+	purged, err := repo.PurgeTombstones(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	purged, err = repo.PurgeTombstones(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	// The purged ports are gone for good, the live ones stay
+	tombstone, err := repo.GetTombstone(ctx, "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, tombstone)
+	_, err = repo.Restore(ctx, "FRPAR")
+	assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+	page, err := repo.List(ctx, repository.ListOptions{Limit: 10, Deleted: true})
+	require.NoError(t, err)
+	assert.Empty(t, page.Ports)
+	assert.Equal(t, 1, repo.GetLength(ctx))
+
+	// A purged port can be created again from scratch
+	version, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "Country"), 0)
+	require.NoError(t, err)
+	assert.NotZero(t, version)
+}
+
+func testContextCancellation(t *testing.T, repo repository.PortRepository) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.ErrorIs(t, repo.Upsert(ctx, NewPort("GBLON", "United Kingdom")), context.Canceled)
+
+	_, err := repo.Get(ctx, "GBLON")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.List(ctx, repository.ListOptions{Limit: 10})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.FindByCountry(ctx, "United Kingdom")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.ErrorIs(t, repo.Delete(ctx, "GBLON"), context.Canceled)
+
+	_, err = repo.UpsertIfVersion(ctx, NewPort("GBLON", "United Kingdom"), 0)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, _, err = repo.GetVersioned(ctx, "GBLON")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	assert.ErrorIs(t, repo.UpsertMany(ctx, []*model.Port{NewPort("GBLON", "United Kingdom")}), context.Canceled)
+
+	_, err = repo.GetTombstone(ctx, "GBLON")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.Restore(ctx, "GBLON")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = repo.PurgeTombstones(ctx, time.Now())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Nothing must have been written with the canceled context
+	retrievedPort, err := repo.Get(context.Background(), "GBLON")
+	require.NoError(t, err)
+	assert.Nil(t, retrievedPort)
+}
+
+func testConcurrentAccess(t *testing.T, repo repository.PortRepository) {
+	const (
+		writers = 8
+		ports   = 25
+	)
+	ctx := context.Background()
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < ports; i++ {
+				// Every writer also updates a port shared with the others
+				id := fmt.Sprintf("W%dP%02d", w, i)
+				assert.NoError(t, repo.Upsert(ctx, NewPort(id, "Country")))
+				assert.NoError(t, repo.Upsert(ctx, NewPort("SHARED", "Country")))
+
+				_, err := repo.Get(ctx, id)
+				assert.NoError(t, err)
+				_, err = repo.List(ctx, repository.ListOptions{Limit: 10})
+				assert.NoError(t, err)
+				_, err = repo.FindByCountry(ctx, "Country")
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, writers*ports+1, repo.GetLength(ctx))
+
+	found, err := repo.FindByCountry(ctx, "Country")
+	require.NoError(t, err)
+	assert.Len(t, found, writers*ports+1)
+}
+
+func testConcurrentCompareAndSwap(t *testing.T, repo repository.PortRepository) {
+	const writers = 8
+	ctx := context.Background()
+
+	version, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", "United Kingdom"), 0)
+	require.NoError(t, err)
+
+	// All the writers read the same version, exactly one of them must win
+	wg := &sync.WaitGroup{}
+	var mu sync.Mutex
+	won := 0
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			_, err := repo.UpsertIfVersion(ctx, NewPort("GBLON", fmt.Sprintf("Country %d", w)), version)
+			if err == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, errs.ErrVersionMismatch)
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, won)
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// Tombstone keeps a deleted port, so that it can be restored until it is purged.
+type Tombstone struct {
+	// Port is the port as it was when it was deleted.
+	Port *model.Port
+
+	// DeletedAt is the time of the delete.
+	DeletedAt time.Time
+}
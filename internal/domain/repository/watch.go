@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// ChangeType describes how a change affected a port.
+type ChangeType int
+
+const (
+	// ChangeCreated is sent when a port is written for the first time, or again after a delete.
+	ChangeCreated ChangeType = iota + 1
+
+	// ChangeUpdated is sent when an existing port is written.
+	ChangeUpdated
+
+	// ChangeDeleted is sent when a port is removed.
+	ChangeDeleted
+
+	// ChangePurged is sent when the tombstone of a deleted port is removed for good.
+	ChangePurged
+
+	// ChangeResync is sent when the watcher missed changes, because it fell behind or the whole
+	// content of the repository was replaced. It is the last change sent before the channel is closed,
+	// so the watcher has to read the ports again and start a new watch.
+	ChangeResync
+)
+
+// String returns the name of the change type.
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeCreated:
+		return "created"
+	case ChangeUpdated:
+		return "updated"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangePurged:
+		return "purged"
+	case ChangeResync:
+		return "resync"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a change of a port sent to the watchers.
+type Change struct {
+	Type ChangeType
+
+	// ID is the id of the changed port, empty for a resync.
+	ID string
+
+	// Version is the version the change gave to the port, see PortRepository.
+	Version uint64
+
+	// Old is the port before the change, nil when it was created.
+	Old *model.Port
+
+	// New is the port after the change, nil when it was deleted.
+	New *model.Port
+}
+
+// WatchFilter selects the changes sent to a watcher. An empty filter selects all of them.
+type WatchFilter struct {
+	// IDs restricts the changes to the ports with the given ids.
+	IDs []string
+
+	// Types restricts the changes to the given types. A resync is always sent.
+	Types []ChangeType
+}
+
+// Match reports whether the change is selected by the filter.
+func (f WatchFilter) Match(change Change) bool {
+	if change.Type == ChangeResync {
+		return true
+	}
+
+	return contains(f.IDs, change.ID) && contains(f.Types, change.Type)
+}
+
+// contains reports whether the value is in the values, an empty list containing every value.
+func contains[T comparable](values []T, value T) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watcher is implemented by the repositories notifying the changes of the ports.
+// The notifications are optional, so the callers check for them with a type assertion.
+type Watcher interface {
+	// Watch returns a channel receiving the changes matching the filter, made after the call,
+	// in the order they were applied. The channel is closed once the context is done.
+	//
+	// The changes are buffered for every watcher, and the writes never wait for a slow one:
+	// a watcher whose buffer is full receives a ChangeResync and its channel is closed.
+	Watch(ctx context.Context, filter WatchFilter) (<-chan Change, error)
+}
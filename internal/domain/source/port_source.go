@@ -0,0 +1,146 @@
+// Package source contains the sources the ports are imported from.
+package source
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// PortSource is a stream of ports to import, such as a file.
+type PortSource interface {
+	// Name identifies the source, it is recorded as the source of the imported ports in their history.
+	Name() string
+
+	// Metadata describes the source, such as its format, for the logs and the operators.
+	Metadata() map[string]string
+
+	// ReadPorts starts reading the ports and sends them to the first channel, which is closed once the source
+	// is read or the context is done. A failure stops the reading and is sent to the second channel,
+	// which is closed after the first one. How the broken ports are handled is up to the source,
+	// and the ones it skips are reported with ReportSkipped before the channels are closed.
+	ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error)
+}
+
+// skippedKey is the context key of the function told about the skipped ports.
+type skippedKey struct{}
+
+// WithSkipped returns a copy of the context which makes the sources reading with it call the function
+// for every broken port they skip, with the error that made them skip it.
+func WithSkipped(ctx context.Context, fn func(err error)) context.Context {
+	return context.WithValue(ctx, skippedKey{}, fn)
+}
+
+// ReportSkipped tells the function set by WithSkipped, if any, that the source skipped a broken port.
+func ReportSkipped(ctx context.Context, src PortSource, err error) {
+	if fn, ok := ctx.Value(skippedKey{}).(func(error)); ok {
+		fn(fmt.Errorf("source %s: %w", src.Name(), err))
+	}
+}
+
+// chainedSource reads several sources one after the other.
+type chainedSource struct {
+	sources []PortSource
+}
+
+// Chain returns a source reading the given sources in order, so a port of a later source replaces the port
+// with the same id of an earlier one. Its name joins the names of the sources with a "+", and its metadata
+// joins their distinct values of every key the same way. The reading stops at the first failure of a source.
+func Chain(sources ...PortSource) PortSource {
+	return &chainedSource{sources: sources}
+}
+
+// Name returns the names of the chained sources joined with a "+".
+func (c *chainedSource) Name() string {
+	names := make([]string, 0, len(c.sources))
+	for _, src := range c.sources {
+		names = append(names, src.Name())
+	}
+
+	return strings.Join(names, "+")
+}
+
+// Metadata returns the distinct values of every key of the chained sources joined with a "+".
+func (c *chainedSource) Metadata() map[string]string {
+	values := make(map[string][]string)
+	for _, src := range c.sources {
+		for key, value := range src.Metadata() {
+			if !contains(values[key], value) {
+				values[key] = append(values[key], value)
+			}
+		}
+	}
+
+	metadata := make(map[string]string, len(values))
+	for key, list := range values {
+		metadata[key] = strings.Join(list, "+")
+	}
+
+	return metadata
+}
+
+// ReadPorts reads the ports of the chained sources in order.
+func (c *chainedSource) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
+	portsCh := make(chan *model.Port, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+		defer close(portsCh)
+
+		for _, src := range c.sources {
+			if err := forward(ctx, src, portsCh); err != nil {
+				errCh <- fmt.Errorf("source %s: failed with: %w", src.Name(), err)
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	return portsCh, errCh
+}
+
+// forward sends the ports of the source to the channel until the source is read,
+// and returns the failure of the source if any.
+func forward(ctx context.Context, src PortSource, portsCh chan<- *model.Port) error {
+	ports, errs := src.ReadPorts(ctx)
+	for ports != nil || errs != nil {
+		select {
+		case port, ok := <-ports:
+			if !ok {
+				ports = nil
+				continue
+			}
+			select {
+			case portsCh <- port:
+			case <-ctx.Done():
+				return nil
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// contains reports whether the list holds the value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
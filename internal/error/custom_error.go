@@ -8,11 +8,87 @@ var (
 	// ErrPortNotFound is returned when the requested port is not found in the repository.
 	ErrPortNotFound = errors.New("port not found")
 
+	// ErrPortGone is returned when the requested port was deleted and only its tombstone is left.
+	ErrPortGone = errors.New("port deleted")
+
 	// ErrInvalidPortID is returned when the provided port ID is invalid.
 	ErrInvalidPortID = errors.New("invalid port id")
 
 	// ErrInvalidInput is returned when the provided input to a function or method is invalid.
 	ErrInvalidInput = errors.New("invalid input")
+
+	// ErrInvalidCursor is returned when the provided pagination cursor cannot be decoded.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrInvalidLimit is returned when the provided page size is out of the accepted range.
+	ErrInvalidLimit = errors.New("invalid limit")
+
+	// ErrSnapshotNotFound is returned when there is no valid snapshot to restore from.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrCorruptSnapshot is returned when a snapshot file fails the format or checksum verification.
+	ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+	// ErrLogGap is returned when the write-ahead log does not continue the restored snapshot,
+	// which means that changes were lost in between.
+	ErrLogGap = errors.New("write-ahead log does not continue the snapshot")
+
+	// ErrRevisionNotFound is returned when the requested revision of a port is unknown or no longer kept.
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	// ErrInvalidRevision is returned when the provided revision number is not a positive integer.
+	ErrInvalidRevision = errors.New("invalid revision")
+
+	// ErrHistoryExpired is returned when the requested time is older than the kept history of the ports.
+	ErrHistoryExpired = errors.New("requested time is older than the retained history")
+
+	// ErrInvalidTime is returned when the provided time is not an RFC 3339 timestamp.
+	ErrInvalidTime = errors.New("invalid time")
+
+	// ErrHistoryNotSupported is returned when the storage does not keep the history of the ports.
+	ErrHistoryNotSupported = errors.New("history is not supported by the storage")
+
+	// ErrVersionMismatch is returned when a conditional write expects another version of the port.
+	ErrVersionMismatch = errors.New("version mismatch")
+
+	// ErrWriteConflict is returned when an unconditional write keeps losing to the concurrent writes of the port.
+	ErrWriteConflict = errors.New("port is being changed concurrently, try again")
+
+	// ErrInvalidDataset is returned when the provided dataset name is invalid.
+	ErrInvalidDataset = errors.New("invalid dataset name")
+
+	// ErrDatasetNotFound is returned when the requested dataset is not served by the instance.
+	ErrDatasetNotFound = errors.New("dataset not found")
+
+	// ErrDatasetExists is returned when a dataset is added with the name of another one.
+	ErrDatasetExists = errors.New("dataset already exists")
+
+	// ErrReloadRejected is returned when a reloaded dataset fails the validation and is not swapped in.
+	ErrReloadRejected = errors.New("reload rejected")
+
+	// ErrReloadInProgress is returned when a reload is requested while another one is running.
+	ErrReloadInProgress = errors.New("reload already in progress")
+
+	// ErrSyncRejected is returned when a full-sync import would remove more ports than allowed,
+	// so none of them is removed.
+	ErrSyncRejected = errors.New("sync rejected")
+
+	// ErrReplicationNotSupported is returned when the storage cannot be replicated,
+	// or the instance does not record the changes for the followers.
+	ErrReplicationNotSupported = errors.New("replication is not supported by the storage")
+
+	// ErrChangesExpired is returned when a follower asks for changes older than the kept change log,
+	// so it has to start over from a snapshot.
+	ErrChangesExpired = errors.New("requested changes are older than the retained change log")
+
+	// ErrFollowerReadOnly is returned when a write is sent to a follower instead of its leader.
+	ErrFollowerReadOnly = errors.New("writes are not accepted by a follower, send them to the leader")
+
+	// ErrInvalidVersion is returned when the provided version is not a non-negative integer.
+	ErrInvalidVersion = errors.New("invalid version")
+
+	// ErrUnsupportedFormat is returned when the format of a ports file is unknown.
+	ErrUnsupportedFormat = errors.New("unsupported file format")
 )
 
 // CustomError is a custom error type that can be used for more complex error handling.
@@ -5,7 +5,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,15 +18,24 @@ import (
 	"github.com/canbo-x/port-service/internal/application/service"
 )
 
+const (
+	// replicationRateLimit is the number of requests per second allowed to a caller on the routes for changes and status.
+	replicationRateLimit = 100
+	// snapshotRateLimit is the number of snapshots per second allowed to a caller, after the burst.
+	snapshotRateLimit = 1
+	// snapshotBurst is the number of snapshots a caller can get at once, such as a follower of several datasets.
+	snapshotBurst = 10
+)
+
 // HTTPServer represents the main structure for the HTTP server.
 type HTTPServer struct {
-	portService *service.PortService
+	datasets *service.Datasets
 }
 
-// NewHTTPServer creates a new instance of HTTPServer with the given port service.
-func NewHTTPServer(portService *service.PortService) *HTTPServer {
+// NewHTTPServer creates a new instance of HTTPServer serving the given datasets.
+func NewHTTPServer(datasets *service.Datasets) *HTTPServer {
 	return &HTTPServer{
-		portService: portService,
+		datasets: datasets,
 	}
 }
 
@@ -35,13 +46,12 @@ func (s *HTTPServer) StartServer(
 	cancel context.CancelFunc,
 	wg *sync.WaitGroup,
 ) error {
-	// Check if the port service is nil just in case
-	if s.portService == nil {
-		return fmt.Errorf("port service is nil")
+	// Check if the datasets are nil just in case
+	if s.datasets == nil {
+		return fmt.Errorf("datasets are nil")
 	}
-	// Initialize the HTTP server and the port handler
-	e := echo.New()
-	portHandler := handler.NewPortHandler(s.portService)
+	// Initialize the HTTP server
+	e := s.newEcho()
 
 	defer func() {
 		// Shutdown the HTTP server with a timeout
@@ -52,7 +62,56 @@ func (s *HTTPServer) StartServer(
 		}
 	}()
 
+	// Bind the listener before signaling that the server has started,
+	// so that the callers can send requests right after wg.Wait returns.
+	// Port should be configurable and not hard-coded
+	// This is just for demonstration purposes
+	// Configuration file logic is not implemented
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		wg.Done()
+		return fmt.Errorf("net.Listen: failed with: %w", err)
+	}
+	e.Listener = listener
+
+	// Start the HTTP server
+	serverErrors := make(chan error)
+	go func() {
+		if err := e.Start(""); err != nil && err != http.ErrServerClosed {
+			serverErrors <- err
+		}
+	}()
+
+	// Signal that the server has started
+	wg.Done()
+
+	// Wait for the context to be canceled or for the server to fail
+	select {
+	case <-ctx.Done():
+		// Context was canceled
+		log.Println("Context canceled")
+		return ctx.Err()
+
+	case err := <-serverErrors:
+		// HTTP server encountered an error
+		log.Printf("HTTP server shutting down: %v", err)
+		return err
+	}
+}
+
+// Handler returns the routes of the server with their middleware, without listening,
+// such as to serve them from a test server.
+func (s *HTTPServer) Handler() http.Handler {
+	return s.newEcho()
+}
+
+// newEcho creates the echo instance with the timeouts, the middleware and the routes of the server.
+func (s *HTTPServer) newEcho() *echo.Echo {
+	e := echo.New()
+	portHandler := handler.NewDatasetHandler(s.datasets)
+
 	// Set the timeouts for the server
+	// The requests of the followers for changes wait for less than the write timeout, see service.MaxChangesWait
 	e.Server.ReadTimeout = 10 * time.Second
 	e.Server.WriteTimeout = 10 * time.Second
 
@@ -66,14 +125,30 @@ func (s *HTTPServer) StartServer(
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowMethods: []string{http.MethodGet},
+		AllowOrigins:  []string{"*"},
+		AllowMethods:  []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
+		ExposeHeaders: []string{"ETag", "Link"},
 	}))
 
+	// Limit the size of the request bodies of the writes
+	e.Use(middleware.BodyLimit("1M"))
+
 	// This is just for demonstration purposes
 	// In production, more sophisticated rate limiting should be used
-	// Limit the number of requests to 10 per second
-	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(10)))
+	// Limit the number of requests to 10 per second, the replication routes have their own limits below
+	e.Use(middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			return strings.Contains(c.Path(), "/replication/")
+		},
+		Store: middleware.NewRateLimiterMemoryStore(10),
+	}))
+
+	// The followers catching up send their requests for changes back to back, so they get a higher limit
+	// Sending a snapshot serializes the whole dataset, so a follower only needs a few of them at once
+	replicationLimiter := middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(replicationRateLimit))
+	snapshotLimiter := middleware.RateLimiter(middleware.NewRateLimiterMemoryStoreWithConfig(
+		middleware.RateLimiterMemoryStoreConfig{Rate: snapshotRateLimit, Burst: snapshotBurst},
+	))
 
 	// Add health check endpoint
 	e.GET("/healthz", func(c echo.Context) error {
@@ -81,32 +156,21 @@ func (s *HTTPServer) StartServer(
 	})
 
 	// Routes
-	e.GET("/ports/:id", portHandler.GetPort)
-
-	// Start the HTTP server
-	serverErrors := make(chan error)
-	go func() {
-		// Port should be configurable and not hard-coded
-		// This is just for demonstration purposes
-		// Configuration file logic is not implemented
-		if err := e.Start(":8080"); err != nil && err != http.ErrServerClosed {
-			serverErrors <- err
-		}
-	}()
-
-	// Signal that the server has started
-	wg.Done()
-
-	// Wait for the context to be canceled or for the server to fail
-	select {
-	case <-ctx.Done():
-		// Context was canceled
-		log.Println("Context canceled")
-		return ctx.Err()
-
-	case err := <-serverErrors:
-		// HTTP server encountered an error
-		log.Printf("HTTP server shutting down: %v", err)
-		return err
+	// The ports of the default dataset are served without the dataset prefix
+	e.GET("/datasets", portHandler.ListDatasets)
+	e.GET("/datasets/:ds", portHandler.GetDataset)
+	for _, g := range []*echo.Group{e.Group(""), e.Group("/datasets/:ds")} {
+		g.GET("/ports", portHandler.ListPorts)
+		g.GET("/ports/:id", portHandler.GetPort)
+		g.GET("/ports/:id/history", portHandler.GetPortHistory)
+		g.GET("/ports/:id/*", portHandler.InvalidPortPath)
+		g.PUT("/ports/:id", portHandler.PutPort)
+		g.DELETE("/ports/:id", portHandler.DeletePort)
+		g.POST("/ports/:id/restore", portHandler.RestorePort)
+		g.GET("/replication/snapshot", portHandler.GetReplicationSnapshot, snapshotLimiter)
+		g.GET("/replication/changes", portHandler.GetReplicationChanges, replicationLimiter)
+		g.GET("/replication/status", portHandler.GetReplicationStatus, replicationLimiter)
 	}
+
+	return e
 }
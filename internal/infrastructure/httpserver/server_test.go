@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/canbo-x/port-service/internal/application/service"
+	"github.com/canbo-x/port-service/internal/infrastructure/repository/memory"
+)
+
+func TestRateLimits(t *testing.T) {
+	testCases := []struct {
+		name    string
+		path    string
+		allowed int
+		// The token refilled while sending many requests can let one more pass, so the 429 is checked for the low limits
+		limited bool
+	}{
+		{
+			name:    "Ports",
+			path:    "/ports",
+			allowed: 10,
+			limited: true,
+		},
+		{
+			name:    "ReplicationStatus",
+			path:    "/replication/status",
+			allowed: replicationRateLimit,
+		},
+		{
+			name:    "ReplicationSnapshot",
+			path:    "/datasets/default/replication/snapshot",
+			allowed: snapshotBurst,
+			limited: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			portService := service.NewPortService(memory.NewMemoryDB())
+			e := NewHTTPServer(service.NewDatasets("ports.json", portService)).newEcho()
+
+			// Every request comes from the same caller
+			for i := 0; i < tc.allowed; i++ {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+				assert.Equal(t, http.StatusOK, rec.Code, "request %d", i+1)
+			}
+
+			if !tc.limited {
+				return
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		})
+	}
+}
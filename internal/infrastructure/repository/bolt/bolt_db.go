@@ -0,0 +1,695 @@
+// Package bolt contains a port repository stored in an embedded bbolt file.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// openTimeout is how long opening the file waits for the lock held by another process.
+const openTimeout = 1 * time.Second
+
+// Bucket names. The primary bucket maps the port ids to their JSON encoding,
+// the index buckets hold `value + separator + id` keys with empty values,
+// so a prefix scan returns the matching ids already ordered.
+// The versions bucket maps the port ids to their version, taken from the sequence of the primary bucket.
+// The tombstones bucket maps the ids of the deleted ports to their JSON encoded tombstone.
+var (
+	portsBucket      = []byte("ports")
+	versionsBucket   = []byte("versions")
+	tombstonesBucket = []byte("tombstones")
+	metaBucket       = []byte("meta")
+	countryBucket    = []byte("idx_country")
+	unlocBucket      = []byte("idx_unloc")
+	codeBucket       = []byte("idx_code")
+	timezoneBucket   = []byte("idx_timezone")
+	allBuckets       = [][]byte{
+		portsBucket, versionsBucket, tombstonesBucket, metaBucket,
+		countryBucket, unlocBucket, codeBucket, timezoneBucket,
+	}
+
+	countKey       = []byte("count")
+	indexSeparator = []byte{0}
+)
+
+// indexEntry is a value of a port registered in an index bucket.
+type indexEntry struct {
+	bucket []byte
+	value  string
+}
+
+// storedTombstone is the encoding of a tombstone in the tombstones bucket.
+type storedTombstone struct {
+	Port      *model.Port `json:"port"`
+	DeletedAt time.Time   `json:"deleted_at"`
+}
+
+// BoltDB represents a port repository persisted in a bbolt file.
+type BoltDB struct {
+	db *bbolt.DB
+}
+
+// Ensure that BoltDB implements the PortRepository interface.
+var _ repository.PortRepository = (*BoltDB)(nil)
+
+// NewBoltDB opens the bbolt file at the given path, creating it and its buckets if needed.
+// The file is locked while it is open, so a second process fails instead of corrupting it.
+func NewBoltDB(path string) (*BoltDB, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("bbolt.Open: failed with: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("bbolt.CreateBucketIfNotExists: failed with: %w", err)
+	}
+
+	if err := db.Update(addMissingVersions); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("add missing versions: failed with: %w", err)
+	}
+
+	return &BoltDB{db: db}, nil
+}
+
+// addMissingVersions gives a version to the ports stored by the releases without versions.
+func addMissingVersions(tx *bbolt.Tx) error {
+	versions := tx.Bucket(versionsBucket)
+
+	return tx.Bucket(portsBucket).ForEach(func(k, _ []byte) error {
+		if versions.Get(k) != nil {
+			return nil
+		}
+
+		_, err := nextVersion(tx, string(k))
+		return err
+	})
+}
+
+// Close releases the bbolt file.
+func (b *BoltDB) Close() error {
+	return b.db.Close()
+}
+
+// Upsert inserts or updates a port, replacing the index entries of its previous values.
+func (b *BoltDB) Upsert(ctx context.Context, port *model.Port) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	value, err := json.Marshal(port)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: failed with: %w", err)
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		_, err := putPort(tx, port, value)
+		return err
+	})
+}
+
+// UpsertMany inserts or updates all the ports within a single transaction.
+// It returns ErrInvalidInput if any port is nil, in which case nothing is written.
+func (b *BoltDB) UpsertMany(ctx context.Context, ports []*model.Port) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	values := make([][]byte, len(ports))
+	for i, port := range ports {
+		if port == nil {
+			return errs.ErrInvalidInput
+		}
+
+		value, err := json.Marshal(port)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: failed with: %w", err)
+		}
+		values[i] = value
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		for i, port := range ports {
+			if _, err := putPort(tx, port, values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertIfVersion inserts or updates a port if its current version is the given one.
+// It returns the new version, or ErrVersionMismatch if the port has another version.
+func (b *BoltDB) UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	value, err := json.Marshal(port)
+	if err != nil {
+		return 0, fmt.Errorf("json.Marshal: failed with: %w", err)
+	}
+
+	var newVersion uint64
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		if getVersion(tx, port.ID) != version {
+			return errs.ErrVersionMismatch
+		}
+
+		var err error
+		newVersion, err = putPort(tx, port, value)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newVersion, nil
+}
+
+// Get returns the port with the given id, or nil if there is no such port.
+func (b *BoltDB) Get(ctx context.Context, id string) (*model.Port, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var port *model.Port
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		port, err = getPort(tx, id)
+		return err
+	})
+
+	return port, err
+}
+
+// GetVersioned returns the port with the given id together with its version,
+// or nil and zero if there is no such port.
+func (b *BoltDB) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	default:
+	}
+
+	var port *model.Port
+	var version uint64
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		if port, err = getPort(tx, id); err != nil || port == nil {
+			return err
+		}
+		version = getVersion(tx, id)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return port, version, nil
+}
+
+// Delete moves the port with the given id to its tombstone and removes its index entries.
+// It returns ErrPortNotFound if the port does not exist.
+func (b *BoltDB) Delete(ctx context.Context, id string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return deletePort(tx, id, time.Now().UTC())
+	})
+}
+
+// DeleteIfVersion removes the port with the given id if its current version is the given one.
+// It returns ErrPortNotFound if the port does not exist, or ErrVersionMismatch if it has another version.
+func (b *BoltDB) DeleteIfVersion(ctx context.Context, id string, version uint64) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if current := getVersion(tx, id); current != 0 && current != version {
+			return errs.ErrVersionMismatch
+		}
+
+		return deletePort(tx, id, time.Now().UTC())
+	})
+}
+
+// GetTombstone returns the tombstone of the deleted port with the given id, or nil if there is no such tombstone.
+func (b *BoltDB) GetTombstone(ctx context.Context, id string) (*repository.Tombstone, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var tombstone *repository.Tombstone
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(tombstonesBucket).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		tombstone, err = decodeTombstone(v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tombstone, nil
+}
+
+// Restore brings the deleted port with the given id back from its tombstone and returns its new version.
+// It returns ErrPortNotFound if there is no such tombstone.
+func (b *BoltDB) Restore(ctx context.Context, id string) (uint64, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	var version uint64
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(tombstonesBucket).Get([]byte(id))
+		if v == nil {
+			return errs.ErrPortNotFound
+		}
+
+		tombstone, err := decodeTombstone(v)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(tombstone.Port)
+		if err != nil {
+			return fmt.Errorf("json.Marshal: failed with: %w", err)
+		}
+
+		// Writing the port drops its tombstone
+		version, err = putPort(tx, tombstone.Port, value)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// PurgeTombstones removes for good the tombstones of the ports deleted before the given time.
+// It returns the number of removed tombstones.
+func (b *BoltDB) PurgeTombstones(ctx context.Context, before time.Time) (int, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	purged := 0
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(tombstonesBucket)
+
+		// The bucket must not change while it is iterated, so the keys are collected first
+		var ids [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			tombstone, err := decodeTombstone(v)
+			if err != nil {
+				return err
+			}
+			if tombstone.DeletedAt.Before(before) {
+				ids = append(ids, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		purged = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// List returns a page of ports ordered by their id, or of the deleted ones if requested.
+func (b *BoltDB) List(ctx context.Context, opts repository.ListOptions) (*repository.ListResult, error) {
+	if opts.Limit <= 0 {
+		return nil, errs.ErrInvalidLimit
+	}
+
+	after, err := repository.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	result := &repository.ListResult{
+		Ports: make([]*model.Port, 0, opts.Limit),
+	}
+	bucket, decode := portsBucket, decodePort
+	if opts.Deleted {
+		bucket, decode = tombstonesBucket, decodeTombstonePort
+	}
+
+	err = b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+
+		k, v := c.First()
+		if after != "" {
+			k, v = c.Seek([]byte(after))
+			if k != nil && string(k) == after {
+				k, v = c.Next()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if len(result.Ports) == opts.Limit {
+				result.NextCursor = repository.EncodeCursor(result.Ports[len(result.Ports)-1].ID)
+				break
+			}
+
+			port, err := decode(v)
+			if err != nil {
+				return err
+			}
+			result.Ports = append(result.Ports, port)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FindByCountry returns the ports of the given country ordered by their id.
+func (b *BoltDB) FindByCountry(ctx context.Context, country string) ([]*model.Port, error) {
+	return b.find(ctx, countryBucket, country)
+}
+
+// FindByUnloc returns the ports having the given UN/LOCODE ordered by their id.
+func (b *BoltDB) FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error) {
+	return b.find(ctx, unlocBucket, unloc)
+}
+
+// FindByCode returns the ports having the given code ordered by their id.
+func (b *BoltDB) FindByCode(ctx context.Context, code string) ([]*model.Port, error) {
+	return b.find(ctx, codeBucket, code)
+}
+
+// FindByTimezone returns the ports in the given timezone ordered by their id.
+func (b *BoltDB) FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error) {
+	return b.find(ctx, timezoneBucket, timezone)
+}
+
+// GetLength returns the number of ports in the repository.
+func (b *BoltDB) GetLength(ctx context.Context) int {
+	select {
+	case <-ctx.Done():
+		return 0
+	default:
+	}
+
+	count := 0
+	b.db.View(func(tx *bbolt.Tx) error {
+		if v := tx.Bucket(metaBucket).Get(countKey); v != nil {
+			count = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+
+	return count
+}
+
+// find returns the ports registered under the value in the given index bucket.
+func (b *BoltDB) find(ctx context.Context, bucket []byte, value string) ([]*model.Port, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	ports := make([]*model.Port, 0)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		prefix := indexKey(value, "")
+		c := tx.Bucket(bucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			port, err := getPort(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if port != nil {
+				ports = append(ports, port)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ports, nil
+}
+
+// getPort reads and decodes the port with the given id, or returns nil if there is no such port.
+func getPort(tx *bbolt.Tx, id string) (*model.Port, error) {
+	v := tx.Bucket(portsBucket).Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+
+	return decodePort(v)
+}
+
+// putPort stores the encoded port, replacing the index entries of its previous values
+// and dropping its tombstone, and returns its new version.
+func putPort(tx *bbolt.Tx, port *model.Port, value []byte) (uint64, error) {
+	old, err := getPort(tx, port.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	if old != nil {
+		if err := unindexPort(tx, old); err != nil {
+			return 0, err
+		}
+	} else if err := addCount(tx, 1); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Bucket(portsBucket).Put([]byte(port.ID), value); err != nil {
+		return 0, err
+	}
+	if err := tx.Bucket(tombstonesBucket).Delete([]byte(port.ID)); err != nil {
+		return 0, err
+	}
+	if err := indexPort(tx, port); err != nil {
+		return 0, err
+	}
+
+	return nextVersion(tx, port.ID)
+}
+
+// deletePort removes the port with the given id together with its version and index entries,
+// and keeps it in a tombstone with the given time of the delete.
+// It returns ErrPortNotFound if the port does not exist.
+func deletePort(tx *bbolt.Tx, id string, at time.Time) error {
+	old, err := getPort(tx, id)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return errs.ErrPortNotFound
+	}
+
+	if err := unindexPort(tx, old); err != nil {
+		return err
+	}
+	if err := addCount(tx, -1); err != nil {
+		return err
+	}
+	if err := tx.Bucket(versionsBucket).Delete([]byte(id)); err != nil {
+		return err
+	}
+
+	tombstone, err := json.Marshal(storedTombstone{Port: old, DeletedAt: at})
+	if err != nil {
+		return fmt.Errorf("json.Marshal: failed with: %w", err)
+	}
+	if err := tx.Bucket(tombstonesBucket).Put([]byte(id), tombstone); err != nil {
+		return err
+	}
+
+	return tx.Bucket(portsBucket).Delete([]byte(id))
+}
+
+// nextVersion gives the port the next value of the sequence of the ports bucket as its version.
+// The sequence only grows, so a port deleted and created again never gets back an old version.
+func nextVersion(tx *bbolt.Tx, id string) (uint64, error) {
+	version, err := tx.Bucket(portsBucket).NextSequence()
+	if err != nil {
+		return 0, err
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, version)
+	if err := tx.Bucket(versionsBucket).Put([]byte(id), v); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// getVersion returns the version of the port with the given id, or zero if there is no such port.
+func getVersion(tx *bbolt.Tx, id string) uint64 {
+	v := tx.Bucket(versionsBucket).Get([]byte(id))
+	if v == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+// decodeTombstone decodes a stored tombstone. Like decodePort, it stays valid after the transaction is closed.
+func decodeTombstone(v []byte) (*repository.Tombstone, error) {
+	var stored storedTombstone
+	if err := json.Unmarshal(v, &stored); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: failed with: %w", err)
+	}
+
+	return &repository.Tombstone{Port: stored.Port, DeletedAt: stored.DeletedAt}, nil
+}
+
+// decodeTombstonePort decodes the port kept in a stored tombstone.
+func decodeTombstonePort(v []byte) (*model.Port, error) {
+	tombstone, err := decodeTombstone(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return tombstone.Port, nil
+}
+
+// decodePort decodes a stored port. The value is copied by the decoding,
+// so the port stays valid after the transaction is closed.
+func decodePort(v []byte) (*model.Port, error) {
+	port := new(model.Port)
+	if err := json.Unmarshal(v, port); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: failed with: %w", err)
+	}
+
+	return port, nil
+}
+
+// addCount adjusts the stored number of ports.
+func addCount(tx *bbolt.Tx, delta int) error {
+	meta := tx.Bucket(metaBucket)
+
+	var count uint64
+	if v := meta.Get(countKey); v != nil {
+		count = binary.BigEndian.Uint64(v)
+	}
+	count = uint64(int64(count) + int64(delta))
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, count)
+
+	return meta.Put(countKey, v)
+}
+
+// indexPort adds the index entries of the port.
+func indexPort(tx *bbolt.Tx, port *model.Port) error {
+	return forEachIndexKey(port, func(bucket, key []byte) error {
+		return tx.Bucket(bucket).Put(key, []byte{})
+	})
+}
+
+// unindexPort removes the index entries of the port.
+func unindexPort(tx *bbolt.Tx, port *model.Port) error {
+	return forEachIndexKey(port, func(bucket, key []byte) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+// forEachIndexKey calls fn with every index bucket and key of the port. Empty values are not indexed.
+func forEachIndexKey(port *model.Port, fn func(bucket, key []byte) error) error {
+	entries := []indexEntry{
+		{bucket: countryBucket, value: port.Country},
+		{bucket: codeBucket, value: port.Code},
+		{bucket: timezoneBucket, value: port.Timezone},
+	}
+	for _, unloc := range port.Unlocs {
+		entries = append(entries, indexEntry{bucket: unlocBucket, value: unloc})
+	}
+
+	for _, entry := range entries {
+		if entry.value == "" {
+			continue
+		}
+		if err := fn(entry.bucket, indexKey(entry.value, port.ID)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexKey builds the key of an index entry. The separator is not expected in
+// the values, so a value is not mistaken for the prefix of a longer one.
+func indexKey(value, id string) []byte {
+	key := make([]byte, 0, len(value)+len(indexSeparator)+len(id))
+	key = append(key, value...)
+	key = append(key, indexSeparator...)
+
+	return append(key, id...)
+}
@@ -0,0 +1,192 @@
+package bolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+func createPort(id, country string) *model.Port {
+	return &model.Port{
+		ID:          id,
+		Name:        "London",
+		City:        "London",
+		Country:     country,
+		Alias:       []string{},
+		Regions:     []string{},
+		Coordinates: []float64{-0.0833, 51.5},
+		Province:    "Greater London",
+		Timezone:    "Europe/London",
+		Unlocs:      []string{id},
+		Code:        "12345",
+	}
+}
+
+// openTestDB opens a new bolt database in a temporary directory.
+func openTestDB(t *testing.T) (*BoltDB, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ports.db")
+	db, err := NewBoltDB(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db, path
+}
+
+func TestBoltDB(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, db *BoltDB, path string)
+	}{
+		{
+			name: "UpsertGetAndDelete",
+			testFunc: func(t *testing.T, db *BoltDB, _ string) {
+				port := createPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(ctx, port))
+
+				retrievedPort, err := db.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, port, retrievedPort)
+				assert.Equal(t, 1, db.GetLength(ctx))
+
+				// Updating the port must not change the length
+				require.NoError(t, db.Upsert(ctx, port))
+				assert.Equal(t, 1, db.GetLength(ctx))
+
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				retrievedPort, err = db.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Nil(t, retrievedPort)
+				assert.Equal(t, 0, db.GetLength(ctx))
+
+				assert.ErrorIs(t, db.Delete(ctx, "GBLON"), errs.ErrPortNotFound)
+			},
+		},
+		{
+			name: "List",
+			testFunc: func(t *testing.T, db *BoltDB, _ string) {
+				for _, id := range []string{"FRPAR", "GBLON", "AEAJM"} {
+					require.NoError(t, db.Upsert(ctx, createPort(id, "")))
+				}
+
+				page, err := db.List(ctx, repository.ListOptions{Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 2)
+				assert.Equal(t, "AEAJM", page.Ports[0].ID)
+				assert.Equal(t, "FRPAR", page.Ports[1].ID)
+				require.NotEmpty(t, page.NextCursor)
+
+				page, err = db.List(ctx, repository.ListOptions{Cursor: page.NextCursor, Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 1)
+				assert.Equal(t, "GBLON", page.Ports[0].ID)
+				assert.Empty(t, page.NextCursor)
+			},
+		},
+		{
+			name: "Indexes",
+			testFunc: func(t *testing.T, db *BoltDB, _ string) {
+				require.NoError(t, db.Upsert(ctx, createPort("GBLON", "United Kingdom")))
+				require.NoError(t, db.Upsert(ctx, createPort("GBSOU", "United Kingdom")))
+
+				ports, err := db.FindByCountry(ctx, "United Kingdom")
+				require.NoError(t, err)
+				assert.Len(t, ports, 2)
+
+				// Moving a port to another country must remove the stale entry
+				require.NoError(t, db.Upsert(ctx, createPort("GBSOU", "United")))
+				ports, err = db.FindByCountry(ctx, "United Kingdom")
+				require.NoError(t, err)
+				require.Len(t, ports, 1)
+				assert.Equal(t, "GBLON", ports[0].ID)
+
+				// A value must not match as the prefix of a longer one
+				ports, err = db.FindByCountry(ctx, "United")
+				require.NoError(t, err)
+				require.Len(t, ports, 1)
+				assert.Equal(t, "GBSOU", ports[0].ID)
+
+				ports, err = db.FindByUnloc(ctx, "GBSOU")
+				require.NoError(t, err)
+				assert.Len(t, ports, 1)
+
+				ports, err = db.FindByCode(ctx, "12345")
+				require.NoError(t, err)
+				assert.Len(t, ports, 2)
+
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				ports, err = db.FindByTimezone(ctx, "Europe/London")
+				require.NoError(t, err)
+				assert.Len(t, ports, 1)
+			},
+		},
+		{
+			name: "Persistence",
+			testFunc: func(t *testing.T, db *BoltDB, path string) {
+				port := createPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(ctx, port))
+				require.NoError(t, db.Close())
+
+				reopened, err := NewBoltDB(path)
+				require.NoError(t, err)
+				defer reopened.Close()
+
+				retrievedPort, err := reopened.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, port, retrievedPort)
+				assert.Equal(t, 1, reopened.GetLength(ctx))
+			},
+		},
+		{
+			name: "MissingVersionsAreAdded",
+			testFunc: func(t *testing.T, db *BoltDB, path string) {
+				require.NoError(t, db.Upsert(ctx, createPort("GBLON", "United Kingdom")))
+				require.NoError(t, db.Upsert(ctx, createPort("FRPAR", "France")))
+
+				// Drop the versions, like a file written before the versions were added
+				require.NoError(t, db.db.Update(func(tx *bbolt.Tx) error {
+					return tx.Bucket(versionsBucket).Delete([]byte("GBLON"))
+				}))
+				require.NoError(t, db.Close())
+
+				reopened, err := NewBoltDB(path)
+				require.NoError(t, err)
+				defer reopened.Close()
+
+				_, version, err := reopened.GetVersioned(ctx, "GBLON")
+				require.NoError(t, err)
+				_, other, err := reopened.GetVersioned(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Greater(t, version, other)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db, path := openTestDB(t)
+			tc.testFunc(t, db, path)
+		})
+	}
+}
+
+func TestBoltDBConformance(t *testing.T) {
+	repositorytest.Run(t, func() repository.PortRepository {
+		db, _ := openTestDB(t)
+		return db
+	})
+}
@@ -0,0 +1,322 @@
+// Package cache contains a read-through caching decorator for the port repositories.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+)
+
+const (
+	// DefaultSize is the number of ports cached when a non-positive size is requested.
+	DefaultSize = 10000
+
+	// DefaultTTL is how long a port stays cached when a non-positive duration is requested.
+	DefaultTTL = time.Minute
+
+	// DefaultNegativeTTL is how long an unknown id stays cached when a non-positive duration is requested.
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// Options holds the limits of the cache.
+type Options struct {
+	// Size is the largest number of cached ids, the least recently used one is evicted beyond it.
+	Size int
+
+	// TTL is how long a found port stays cached.
+	TTL time.Duration
+
+	// NegativeTTL is how long an id without a port stays cached as not found.
+	NegativeTTL time.Duration
+}
+
+// entry is a cached lookup of a port, the port being nil when it was not found.
+type entry struct {
+	id      string
+	port    *model.Port
+	version uint64
+	expires time.Time
+}
+
+// call is a backend lookup shared by the concurrent misses of the same id.
+type call struct {
+	// done is closed once the lookup returned.
+	done chan struct{}
+
+	port    *model.Port
+	version uint64
+	err     error
+
+	// stale is set when the port was written during the lookup, so its result is not cached.
+	stale bool
+}
+
+// CachedRepository decorates a port repository with a size-bounded LRU cache of the lookups by id.
+//
+// Get and GetVersioned are served from the cache, which also remembers the unknown ids for a shorter time.
+// The concurrent misses of the same id share a single backend lookup. The writes made through the decorator
+// invalidate the ids they touch, while the writes made to the backend directly are only seen once the entries
+// expire. The other reads, such as List and the lookups by field, always go to the backend.
+type CachedRepository struct {
+	backend repository.PortRepository
+	opts    Options
+
+	// now returns the current time, it is replaced by the tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+	calls   map[string]*call
+}
+
+// Ensure that CachedRepository implements the PortRepository interface.
+var _ repository.PortRepository = (*CachedRepository)(nil)
+
+// NewCachedRepository creates a new CachedRepository in front of the given backend.
+// The non-positive options fall back to DefaultSize, DefaultTTL and DefaultNegativeTTL.
+func NewCachedRepository(backend repository.PortRepository, opts Options) *CachedRepository {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.NegativeTTL <= 0 {
+		opts.NegativeTTL = DefaultNegativeTTL
+	}
+
+	return &CachedRepository{
+		backend: backend,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		calls:   make(map[string]*call),
+	}
+}
+
+// Upsert inserts or updates a port in the backend and invalidates its cache entry.
+func (c *CachedRepository) Upsert(ctx context.Context, port *model.Port) error {
+	err := c.backend.Upsert(ctx, port)
+	c.invalidate(port.ID)
+
+	return err
+}
+
+// UpsertMany inserts or updates all the ports in the backend as a single change and invalidates their entries.
+func (c *CachedRepository) UpsertMany(ctx context.Context, ports []*model.Port) error {
+	err := c.backend.UpsertMany(ctx, ports)
+	for _, port := range ports {
+		if port != nil {
+			c.invalidate(port.ID)
+		}
+	}
+
+	return err
+}
+
+// Get returns the port with the given id from the cache, or from the backend on a miss.
+func (c *CachedRepository) Get(ctx context.Context, id string) (*model.Port, error) {
+	port, _, err := c.GetVersioned(ctx, id)
+
+	return port, err
+}
+
+// GetVersioned returns the port with the given id together with its version from the cache,
+// or from the backend on a miss.
+func (c *CachedRepository) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	default:
+	}
+
+	for {
+		c.mu.Lock()
+		if e, ok := c.lookup(id); ok {
+			c.mu.Unlock()
+			return e.port, e.version, nil
+		}
+
+		// Join the lookup of another miss of the same id
+		if cl, ok := c.calls[id]; ok {
+			c.mu.Unlock()
+			select {
+			case <-cl.done:
+			case <-ctx.Done():
+				return nil, 0, ctx.Err()
+			}
+
+			// The lookup failed with the context of the caller who started it, so it is tried again
+			if isContextError(cl.err) && ctx.Err() == nil {
+				continue
+			}
+			return cl.port, cl.version, cl.err
+		}
+
+		cl := &call{done: make(chan struct{})}
+		c.calls[id] = cl
+		c.mu.Unlock()
+
+		cl.port, cl.version, cl.err = c.backend.GetVersioned(ctx, id)
+
+		c.mu.Lock()
+		if c.calls[id] == cl {
+			delete(c.calls, id)
+		}
+		if cl.err == nil && !cl.stale {
+			c.store(id, cl.port, cl.version)
+		}
+		c.mu.Unlock()
+		close(cl.done)
+
+		return cl.port, cl.version, cl.err
+	}
+}
+
+// Delete removes a port from the backend and invalidates its cache entry.
+func (c *CachedRepository) Delete(ctx context.Context, id string) error {
+	err := c.backend.Delete(ctx, id)
+	c.invalidate(id)
+
+	return err
+}
+
+// UpsertIfVersion inserts or updates a port in the backend if its current version is the given one,
+// and invalidates its cache entry.
+func (c *CachedRepository) UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error) {
+	newVersion, err := c.backend.UpsertIfVersion(ctx, port, version)
+	c.invalidate(port.ID)
+
+	return newVersion, err
+}
+
+// DeleteIfVersion removes a port from the backend if its current version is the given one,
+// and invalidates its cache entry.
+func (c *CachedRepository) DeleteIfVersion(ctx context.Context, id string, version uint64) error {
+	err := c.backend.DeleteIfVersion(ctx, id, version)
+	c.invalidate(id)
+
+	return err
+}
+
+// GetTombstone returns the tombstone of the deleted port from the backend.
+func (c *CachedRepository) GetTombstone(ctx context.Context, id string) (*repository.Tombstone, error) {
+	return c.backend.GetTombstone(ctx, id)
+}
+
+// Restore brings the deleted port back from its tombstone in the backend and invalidates its cache entry.
+func (c *CachedRepository) Restore(ctx context.Context, id string) (uint64, error) {
+	version, err := c.backend.Restore(ctx, id)
+	c.invalidate(id)
+
+	return version, err
+}
+
+// PurgeTombstones removes for good the tombstones of the ports deleted before the given time from the backend.
+// The tombstones are not cached, so the cache is left as is.
+func (c *CachedRepository) PurgeTombstones(ctx context.Context, before time.Time) (int, error) {
+	return c.backend.PurgeTombstones(ctx, before)
+}
+
+// FindByCountry returns the ports of the given country from the backend.
+func (c *CachedRepository) FindByCountry(ctx context.Context, country string) ([]*model.Port, error) {
+	return c.backend.FindByCountry(ctx, country)
+}
+
+// FindByUnloc returns the ports having the given UN/LOCODE from the backend.
+func (c *CachedRepository) FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error) {
+	return c.backend.FindByUnloc(ctx, unloc)
+}
+
+// FindByCode returns the ports having the given code from the backend.
+func (c *CachedRepository) FindByCode(ctx context.Context, code string) ([]*model.Port, error) {
+	return c.backend.FindByCode(ctx, code)
+}
+
+// FindByTimezone returns the ports in the given timezone from the backend.
+func (c *CachedRepository) FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error) {
+	return c.backend.FindByTimezone(ctx, timezone)
+}
+
+// GetLength returns the number of ports in the backend.
+func (c *CachedRepository) GetLength(ctx context.Context) int {
+	return c.backend.GetLength(ctx)
+}
+
+// List returns a page of ports from the backend.
+func (c *CachedRepository) List(ctx context.Context, opts repository.ListOptions) (*repository.ListResult, error) {
+	return c.backend.List(ctx, opts)
+}
+
+// lookup returns the unexpired entry of the id and marks it as the most recently used.
+// The caller must hold the lock.
+func (c *CachedRepository) lookup(id string) (*entry, bool) {
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+
+	return e, true
+}
+
+// store caches the lookup of the id, evicting the least recently used entries beyond the size.
+// The caller must hold the lock.
+func (c *CachedRepository) store(id string, port *model.Port, version uint64) {
+	ttl := c.opts.TTL
+	if port == nil {
+		ttl = c.opts.NegativeTTL
+	}
+	e := &entry{id: id, port: port, version: version, expires: c.now().Add(ttl)}
+
+	if elem, ok := c.entries[id]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidate drops the cache entry of the id, and keeps the running lookup of the id from being cached,
+// as it may have read the port before the write. The following misses start a new lookup.
+func (c *CachedRepository) invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+	if cl, ok := c.calls[id]; ok {
+		cl.stale = true
+		delete(c.calls, id)
+	}
+}
+
+// remove drops the cache entry of the list element.
+// The caller must hold the lock.
+func (c *CachedRepository) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).id)
+}
+
+// isContextError reports whether the error comes from a canceled or expired context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,271 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	"github.com/canbo-x/port-service/internal/infrastructure/repository/memory"
+)
+
+// countingRepository counts the lookups reaching the backend, and holds them until the gate is closed if any.
+type countingRepository struct {
+	repository.PortRepository
+
+	lookups atomic.Int32
+	gate    chan struct{}
+}
+
+func (r *countingRepository) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	r.lookups.Add(1)
+	if r.gate != nil {
+		<-r.gate
+	}
+
+	return r.PortRepository.GetVersioned(ctx, id)
+}
+
+// newTestCache returns a cache in front of an empty memory database, with a clock moved by the returned function.
+func newTestCache(opts Options) (*CachedRepository, *countingRepository, func(time.Duration)) {
+	backend := &countingRepository{PortRepository: memory.NewMemoryDB()}
+	c := NewCachedRepository(backend, opts)
+
+	var mu sync.Mutex
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+
+	return c, backend, advance
+}
+
+func TestCachedRepository(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "HitsSkipTheBackend",
+			testFunc: func(t *testing.T) {
+				c, backend, _ := newTestCache(Options{})
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+
+				for i := 0; i < 3; i++ {
+					port, version, err := c.GetVersioned(ctx, "GBLON")
+					require.NoError(t, err)
+					assert.Equal(t, "GBLON", port.ID)
+					assert.Equal(t, uint64(1), version)
+				}
+				_, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, int32(1), backend.lookups.Load())
+			},
+		},
+		{
+			name: "NotFoundIsCachedForTheNegativeTTL",
+			testFunc: func(t *testing.T) {
+				c, backend, advance := newTestCache(Options{TTL: time.Minute, NegativeTTL: time.Second})
+
+				for i := 0; i < 2; i++ {
+					port, err := c.Get(ctx, "GBLON")
+					require.NoError(t, err)
+					assert.Nil(t, port)
+				}
+				assert.Equal(t, int32(1), backend.lookups.Load())
+
+				advance(time.Second)
+				_, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, int32(2), backend.lookups.Load())
+			},
+		},
+		{
+			name: "PortsExpireAfterTheTTL",
+			testFunc: func(t *testing.T) {
+				c, backend, advance := newTestCache(Options{TTL: time.Minute})
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+
+				_, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				advance(59 * time.Second)
+				_, err = c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, int32(1), backend.lookups.Load())
+
+				// A write made to the backend directly is seen once the entry expires
+				require.NoError(t, backend.Upsert(ctx, repositorytest.NewPort("GBLON", "France")))
+				advance(time.Second)
+				port, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "France", port.Country)
+				assert.Equal(t, int32(2), backend.lookups.Load())
+			},
+		},
+		{
+			name: "LeastRecentlyUsedIsEvicted",
+			testFunc: func(t *testing.T) {
+				c, backend, _ := newTestCache(Options{Size: 2})
+
+				_, _ = c.Get(ctx, "GBLON")
+				_, _ = c.Get(ctx, "FRPAR")
+				_, _ = c.Get(ctx, "GBLON")
+				_, _ = c.Get(ctx, "NLRTM")
+				assert.Equal(t, int32(3), backend.lookups.Load())
+
+				_, _ = c.Get(ctx, "GBLON")
+				_, _ = c.Get(ctx, "NLRTM")
+				assert.Equal(t, int32(3), backend.lookups.Load())
+
+				_, _ = c.Get(ctx, "FRPAR")
+				assert.Equal(t, int32(4), backend.lookups.Load())
+			},
+		},
+		{
+			name: "WritesInvalidate",
+			testFunc: func(t *testing.T) {
+				c, _, _ := newTestCache(Options{})
+
+				// The cached not found is dropped by the create
+				port, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Nil(t, port)
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				port, err = c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "United Kingdom", port.Country)
+
+				require.NoError(t, c.UpsertMany(ctx, []*model.Port{repositorytest.NewPort("GBLON", "France")}))
+				port, _, err = c.GetVersioned(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "France", port.Country)
+
+				version, err := c.UpsertIfVersion(ctx, repositorytest.NewPort("GBLON", "Spain"), 2)
+				require.NoError(t, err)
+				port, current, err := c.GetVersioned(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "Spain", port.Country)
+				assert.Equal(t, version, current)
+
+				require.NoError(t, c.DeleteIfVersion(ctx, "GBLON", current))
+				port, err = c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Nil(t, port)
+
+				_, err = c.Restore(ctx, "GBLON")
+				require.NoError(t, err)
+				port, err = c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "Spain", port.Country)
+
+				require.NoError(t, c.Delete(ctx, "GBLON"))
+				port, err = c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Nil(t, port)
+			},
+		},
+		{
+			name: "ConcurrentMissesShareALookup",
+			testFunc: func(t *testing.T) {
+				c, backend, _ := newTestCache(Options{})
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				backend.gate = make(chan struct{})
+
+				const readers = 10
+				var wg sync.WaitGroup
+				for i := 0; i < readers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						port, err := c.Get(ctx, "GBLON")
+						assert.NoError(t, err)
+						assert.Equal(t, "GBLON", port.ID)
+					}()
+				}
+
+				// Let the lookup return once every reader is waiting for it
+				require.Eventually(t, func() bool {
+					c.mu.Lock()
+					defer c.mu.Unlock()
+					return c.calls["GBLON"] != nil
+				}, time.Second, time.Millisecond)
+				time.Sleep(10 * time.Millisecond)
+				close(backend.gate)
+				wg.Wait()
+
+				assert.Equal(t, int32(1), backend.lookups.Load())
+			},
+		},
+		{
+			name: "LookupRacingAWriteIsNotCached",
+			testFunc: func(t *testing.T) {
+				c, backend, _ := newTestCache(Options{})
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				backend.gate = make(chan struct{})
+
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					_, _ = c.Get(ctx, "GBLON")
+				}()
+				require.Eventually(t, func() bool { return backend.lookups.Load() == 1 }, time.Second, time.Millisecond)
+
+				// The write lands while the lookup is held, which may have read the old port
+				require.NoError(t, c.Upsert(ctx, repositorytest.NewPort("GBLON", "France")))
+				close(backend.gate)
+				<-done
+
+				port, err := c.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, "France", port.Country)
+				assert.Equal(t, int32(2), backend.lookups.Load())
+			},
+		},
+		{
+			name: "CanceledWaiterReturns",
+			testFunc: func(t *testing.T) {
+				c, backend, _ := newTestCache(Options{})
+				backend.gate = make(chan struct{})
+				defer close(backend.gate)
+
+				go func() { _, _ = c.Get(ctx, "GBLON") }()
+				require.Eventually(t, func() bool { return backend.lookups.Load() == 1 }, time.Second, time.Millisecond)
+
+				waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+				defer cancel()
+				_, err := c.Get(waitCtx, "GBLON")
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
+
+func TestCachedRepositoryConformance(t *testing.T) {
+	repositorytest.Run(t, func() repository.PortRepository {
+		return NewCachedRepository(memory.NewMemoryDB(), Options{})
+	})
+}
@@ -0,0 +1,252 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// DefaultHistoryLimit is the number of revisions kept per port by default.
+const DefaultHistoryLimit = 10
+
+// SetHistoryLimit sets the number of revisions kept per port and drops the older ones.
+// A limit of zero or less disables the history.
+func (db *MemoryDB) SetHistoryLimit(limit int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if limit < 0 {
+		limit = 0
+	}
+	if db.historyLimit == 0 && limit > 0 {
+		// The changes made without a history are not in it
+		db.historySince = time.Now()
+	}
+	db.historyLimit = limit
+
+	for id, revisions := range db.history {
+		if limit == 0 {
+			delete(db.history, id)
+			continue
+		}
+		if len(revisions) > limit {
+			db.history[id] = append([]*repository.Revision(nil), revisions[len(revisions)-limit:]...)
+		}
+	}
+}
+
+// History returns the kept revisions of the port ordered from the oldest.
+// It returns ErrPortNotFound if the port has no revision.
+func (db *MemoryDB) History(ctx context.Context, id string) ([]*repository.Revision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	revisions, ok := db.history[id]
+	if !ok {
+		return nil, errs.ErrPortNotFound
+	}
+
+	return append([]*repository.Revision(nil), revisions...), nil
+}
+
+// GetRevision returns the revision of the port with the given number.
+// It returns ErrRevisionNotFound if there is no such revision or it is no longer kept.
+func (db *MemoryDB) GetRevision(ctx context.Context, id string, number uint64) (*repository.Revision, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// The numbers are consecutive, so the position of the revision follows from the first one
+	revisions := db.history[id]
+	if len(revisions) == 0 || number < revisions[0].Number {
+		return nil, errs.ErrRevisionNotFound
+	}
+
+	i := number - revisions[0].Number
+	if i >= uint64(len(revisions)) {
+		return nil, errs.ErrRevisionNotFound
+	}
+
+	return revisions[i], nil
+}
+
+// GetAsOf returns the port as it was at the given time, or nil if it did not exist then.
+// It returns ErrHistoryExpired if the time is older than the kept revisions of the port,
+// and ErrHistoryNotSupported if the history is disabled.
+func (db *MemoryDB) GetAsOf(ctx context.Context, id string, at time.Time) (*model.Port, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if db.historyLimit == 0 {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	return db.portAsOf(id, at)
+}
+
+// ListAsOf returns a page of the ports as they were at the given time, ordered by their id.
+// It returns ErrHistoryExpired if the time is older than the kept revisions of a listed port,
+// and ErrHistoryNotSupported if the history is disabled.
+func (db *MemoryDB) ListAsOf(
+	ctx context.Context,
+	at time.Time,
+	opts repository.ListOptions,
+) (*repository.ListResult, error) {
+	if opts.Limit <= 0 {
+		return nil, errs.ErrInvalidLimit
+	}
+	if opts.Deleted {
+		return nil, errs.ErrInvalidInput
+	}
+
+	after, err := repository.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	db.rLockSorted()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if db.historyLimit == 0 {
+		return nil, errs.ErrHistoryNotSupported
+	}
+
+	// A port existing at that time is either still there or deleted, since the history
+	// of a port only goes away with its tombstone.
+	// The tombstones are expected to be few, so they are sorted on every call
+	tombstones := make([]string, 0, len(db.tombstones))
+	for id := range db.tombstones {
+		if id > after {
+			tombstones = append(tombstones, id)
+		}
+	}
+	sort.Strings(tombstones)
+	ids := db.ids[sort.Search(len(db.ids), func(i int) bool { return db.ids[i] > after }):]
+
+	result := &repository.ListResult{
+		Ports: make([]*model.Port, 0, opts.Limit),
+	}
+	// Merge the two orderings, which never hold the same id
+	for len(ids) > 0 || len(tombstones) > 0 {
+		var id string
+		if len(tombstones) == 0 || (len(ids) > 0 && ids[0] < tombstones[0]) {
+			id, ids = ids[0], ids[1:]
+		} else {
+			id, tombstones = tombstones[0], tombstones[1:]
+		}
+
+		port, err := db.portAsOf(id, at)
+		if err != nil {
+			return nil, err
+		}
+		if port == nil {
+			continue
+		}
+
+		// The page is full, and the port shows that another one follows
+		if len(result.Ports) == opts.Limit {
+			result.NextCursor = repository.EncodeCursor(result.Ports[len(result.Ports)-1].ID)
+			break
+		}
+		result.Ports = append(result.Ports, port)
+	}
+
+	return result, nil
+}
+
+// portAsOf returns the port as it was at the given time, or nil if it did not exist then.
+// The caller must hold the lock.
+func (db *MemoryDB) portAsOf(id string, at time.Time) (*model.Port, error) {
+	// The revisions are appended in the order of their time, so the last one made
+	// until then holds the port as it was
+	revisions := db.history[id]
+	i := sort.Search(len(revisions), func(i int) bool { return revisions[i].Timestamp.After(at) })
+	if i > 0 {
+		if revisions[i-1].Deleted {
+			return nil, nil
+		}
+		return revisions[i-1].Port, nil
+	}
+
+	if at.Before(db.historySince) {
+		return nil, errs.ErrHistoryExpired
+	}
+	if len(revisions) == 0 {
+		// The port did not change since the start of the history
+		return db.ports[id], nil
+	}
+	if revisions[0].Number == 1 && db.historySince.IsZero() {
+		// The port was created afterwards
+		return nil, nil
+	}
+
+	// The revision made until then is no longer kept, or was made before the start of the history
+	return nil, errs.ErrHistoryExpired
+}
+
+// remember appends the logged change to the history of the port and drops the revisions over the limit.
+// The port is copied, so that changing the stored port in place does not rewrite its history.
+// The caller must hold the write lock.
+func (db *MemoryDB) remember(record walRecord) {
+	if db.historyLimit == 0 {
+		return
+	}
+
+	switch record.Op {
+	case walOpUpsertMany:
+		// Every port of a batch gets its own revision
+		for _, port := range record.Ports {
+			db.remember(walRecord{Op: walOpUpsert, ID: port.ID, Port: port, Time: record.Time, Source: record.Source})
+		}
+		return
+	case walOpPurge:
+		// The history went away with the purged port
+		return
+	}
+
+	revisions := db.history[record.ID]
+	revision := &repository.Revision{
+		Number:    1,
+		Timestamp: record.Time,
+		Source:    record.Source,
+		Deleted:   record.Op == walOpDelete,
+	}
+	if n := len(revisions); n > 0 {
+		revision.Number = revisions[n-1].Number + 1
+	}
+	if record.Port != nil {
+		revision.Port = record.Port.Clone()
+	}
+
+	if len(revisions) == db.historyLimit {
+		// Shift in place, so the backing array does not grow with every change
+		copy(revisions, revisions[1:])
+		revisions = revisions[:len(revisions)-1]
+	}
+	db.history[record.ID] = append(revisions, revision)
+}
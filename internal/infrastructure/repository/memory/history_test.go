@@ -0,0 +1,357 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// historyRepository is a repository keeping the history with a configurable limit.
+type historyRepository interface {
+	repository.PortRepository
+	repository.HistoryRepository
+	SetHistoryLimit(limit int)
+}
+
+func TestHistory(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, db historyRepository)
+	}{
+		{
+			name: "RevisionsAreRecordedWithTheirSource",
+			testFunc: func(t *testing.T, db historyRepository) {
+				importCtx := repository.WithSource(context.Background(), "import:ports.json")
+				apiCtx := repository.WithSource(context.Background(), "api")
+
+				port := repositorytest.NewPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(importCtx, port))
+
+				moved := repositorytest.NewPort("GBLON", "United Kingdom")
+				moved.Coordinates = []float64{-0.1, 51.6}
+				require.NoError(t, db.Upsert(apiCtx, moved))
+				require.NoError(t, db.Delete(apiCtx, "GBLON"))
+
+				revisions, err := db.History(context.Background(), "GBLON")
+				require.NoError(t, err)
+				require.Len(t, revisions, 3)
+
+				assert.Equal(t, uint64(1), revisions[0].Number)
+				assert.Equal(t, "import:ports.json", revisions[0].Source)
+				assert.Equal(t, port, revisions[0].Port)
+				assert.False(t, revisions[0].Timestamp.IsZero())
+
+				assert.Equal(t, uint64(2), revisions[1].Number)
+				assert.Equal(t, "api", revisions[1].Source)
+				assert.Equal(t, []float64{-0.1, 51.6}, revisions[1].Port.Coordinates)
+
+				assert.Equal(t, uint64(3), revisions[2].Number)
+				assert.True(t, revisions[2].Deleted)
+				assert.Nil(t, revisions[2].Port)
+
+				// The history survives the delete and continues when the port comes back
+				require.NoError(t, db.Upsert(importCtx, port))
+				revision, err := db.GetRevision(context.Background(), "GBLON", 4)
+				require.NoError(t, err)
+				assert.Equal(t, port, revision.Port)
+			},
+		},
+		{
+			name: "RevisionsAreCopies",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				port := repositorytest.NewPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(ctx, port))
+
+				// Changing the stored port in place must not rewrite the history
+				port.Coordinates[0] = 10
+				port.Name = "Changed"
+				require.NoError(t, db.Upsert(ctx, port))
+
+				revision, err := db.GetRevision(ctx, "GBLON", 1)
+				require.NoError(t, err)
+				assert.Equal(t, repositorytest.NewPort("GBLON", "United Kingdom"), revision.Port)
+			},
+		},
+		{
+			name: "HistoryIsBounded",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				db.SetHistoryLimit(3)
+
+				for i := 0; i < 5; i++ {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				}
+
+				revisions, err := db.History(ctx, "GBLON")
+				require.NoError(t, err)
+				require.Len(t, revisions, 3)
+				assert.Equal(t, uint64(3), revisions[0].Number)
+				assert.Equal(t, uint64(5), revisions[2].Number)
+
+				_, err = db.GetRevision(ctx, "GBLON", 2)
+				assert.ErrorIs(t, err, errs.ErrRevisionNotFound)
+				_, err = db.GetRevision(ctx, "GBLON", 6)
+				assert.ErrorIs(t, err, errs.ErrRevisionNotFound)
+
+				// Lowering the limit drops the older revisions right away
+				db.SetHistoryLimit(1)
+				revisions, err = db.History(ctx, "GBLON")
+				require.NoError(t, err)
+				require.Len(t, revisions, 1)
+				assert.Equal(t, uint64(5), revisions[0].Number)
+			},
+		},
+		{
+			name: "HistoryCanBeDisabled",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				db.SetHistoryLimit(0)
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+
+				_, err := db.History(ctx, "GBLON")
+				assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+				_, err = db.GetAsOf(ctx, "GBLON", time.Now())
+				assert.ErrorIs(t, err, errs.ErrHistoryNotSupported)
+			},
+		},
+		{
+			name: "PortAsOfATime",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+
+				// The writes are apart, so that every revision has its own time
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				time.Sleep(time.Millisecond)
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "France")))
+				time.Sleep(time.Millisecond)
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+
+				revisions, err := db.History(ctx, "GBLON")
+				require.NoError(t, err)
+				require.Len(t, revisions, 3)
+
+				port, err := db.GetAsOf(ctx, "GBLON", revisions[0].Timestamp.Add(-time.Nanosecond))
+				require.NoError(t, err)
+				assert.Nil(t, port)
+
+				port, err = db.GetAsOf(ctx, "GBLON", revisions[0].Timestamp)
+				require.NoError(t, err)
+				assert.Equal(t, "United Kingdom", port.Country)
+
+				port, err = db.GetAsOf(ctx, "GBLON", revisions[1].Timestamp.Add(-time.Nanosecond))
+				require.NoError(t, err)
+				assert.Equal(t, "United Kingdom", port.Country)
+
+				port, err = db.GetAsOf(ctx, "GBLON", revisions[1].Timestamp)
+				require.NoError(t, err)
+				assert.Equal(t, "France", port.Country)
+
+				port, err = db.GetAsOf(ctx, "GBLON", revisions[2].Timestamp)
+				require.NoError(t, err)
+				assert.Nil(t, port)
+
+				port, err = db.GetAsOf(ctx, "NOPORT", time.Now())
+				require.NoError(t, err)
+				assert.Nil(t, port)
+			},
+		},
+		{
+			name: "TrimmedHistoryExpires",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				db.SetHistoryLimit(2)
+
+				for _, country := range []string{"United Kingdom", "France", "Spain"} {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", country)))
+					time.Sleep(time.Millisecond)
+				}
+
+				revisions, err := db.History(ctx, "GBLON")
+				require.NoError(t, err)
+				require.Len(t, revisions, 2)
+
+				_, err = db.GetAsOf(ctx, "GBLON", revisions[0].Timestamp.Add(-time.Nanosecond))
+				assert.ErrorIs(t, err, errs.ErrHistoryExpired)
+				_, err = db.ListAsOf(ctx, revisions[0].Timestamp.Add(-time.Nanosecond), repository.ListOptions{Limit: 10})
+				assert.ErrorIs(t, err, errs.ErrHistoryExpired)
+
+				port, err := db.GetAsOf(ctx, "GBLON", revisions[0].Timestamp)
+				require.NoError(t, err)
+				assert.Equal(t, "France", port.Country)
+			},
+		},
+		{
+			name: "PortsListedAsOfATime",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("FRPAR", "France")))
+				time.Sleep(time.Millisecond)
+				at := time.Now()
+				time.Sleep(time.Millisecond)
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("NLRTM", "Netherlands")))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+
+				page, err := db.ListAsOf(ctx, at, repository.ListOptions{Limit: 1})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 1)
+				assert.Equal(t, "FRPAR", page.Ports[0].ID)
+				require.NotEmpty(t, page.NextCursor)
+
+				page, err = db.ListAsOf(ctx, at, repository.ListOptions{Cursor: page.NextCursor, Limit: 1})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 1)
+				assert.Equal(t, "GBLON", page.Ports[0].ID)
+				assert.Empty(t, page.NextCursor)
+
+				page, err = db.ListAsOf(ctx, time.Now(), repository.ListOptions{Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 2)
+				assert.Equal(t, "FRPAR", page.Ports[0].ID)
+				assert.Equal(t, "NLRTM", page.Ports[1].ID)
+
+				_, err = db.ListAsOf(ctx, at, repository.ListOptions{})
+				assert.ErrorIs(t, err, errs.ErrInvalidLimit)
+			},
+		},
+		{
+			name: "PagesMergeStoredAndDeletedPorts",
+			testFunc: func(t *testing.T, db historyRepository) {
+				ctx := context.Background()
+				ids := []string{"BEANR", "DEHAM", "ESBCN", "FRPAR", "GBLON", "NLRTM"}
+				for _, id := range ids {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort(id, "Europe")))
+				}
+				require.NoError(t, db.Delete(ctx, "NLRTM"))
+				time.Sleep(time.Millisecond)
+				at := time.Now()
+				time.Sleep(time.Millisecond)
+				require.NoError(t, db.Delete(ctx, "DEHAM"))
+				require.NoError(t, db.Delete(ctx, "FRPAR"))
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("CNSHA", "China")))
+
+				listed := make([]string, 0, len(ids))
+				opts := repository.ListOptions{Limit: 2}
+				for {
+					page, err := db.ListAsOf(ctx, at, opts)
+					require.NoError(t, err)
+					for _, port := range page.Ports {
+						listed = append(listed, port.ID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+				assert.Equal(t, ids[:len(ids)-1], listed)
+			},
+		},
+		{
+			name: "UnknownPort",
+			testFunc: func(t *testing.T, db historyRepository) {
+				_, err := db.History(context.Background(), "NOPORT")
+				assert.ErrorIs(t, err, errs.ErrPortNotFound)
+
+				_, err = db.GetRevision(context.Background(), "NOPORT", 1)
+				assert.ErrorIs(t, err, errs.ErrRevisionNotFound)
+			},
+		},
+	}
+
+	implementations := []struct {
+		name string
+		new  func() historyRepository
+	}{
+		{name: "MemoryDB", new: func() historyRepository { return NewMemoryDB() }},
+		{name: "ShardedMemoryDB", new: func() historyRepository { return NewShardedMemoryDB(4) }},
+	}
+
+	for _, impl := range implementations {
+		for _, tc := range testCases {
+			impl, tc := impl, tc // Capture range variables
+			t.Run(impl.name+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+				tc.testFunc(t, impl.new())
+			})
+		}
+	}
+}
+
+func TestHistoryWALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.wal")
+	ctx := repository.WithSource(context.Background(), "api")
+
+	db, wal := openTestDB(t, path)
+	require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+	require.NoError(t, db.Delete(ctx, "GBLON"))
+	expected, err := db.History(ctx, "GBLON")
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	// The replayed changes keep their original time and source
+	replayed, _ := openTestDB(t, path)
+	revisions, err := replayed.History(ctx, "GBLON")
+	require.NoError(t, err)
+	require.Len(t, revisions, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Number, revisions[i].Number)
+		assert.True(t, expected[i].Timestamp.Equal(revisions[i].Timestamp))
+		assert.Equal(t, expected[i].Source, revisions[i].Source)
+		assert.Equal(t, expected[i].Deleted, revisions[i].Deleted)
+		assert.Equal(t, expected[i].Port, revisions[i].Port)
+	}
+}
+
+func TestAsOfHistoryStart(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+
+		db := NewMemoryDB()
+		require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+		dir := t.TempDir()
+		_, err := NewSnapshotter(db, dir, 1).Save(ctx)
+		require.NoError(t, err)
+
+		restored := NewMemoryDB()
+		_, err = NewSnapshotter(restored, dir, 1).LoadLatest(ctx)
+		require.NoError(t, err)
+
+		// The restored port is known from the time of the snapshot only
+		_, err = restored.GetAsOf(ctx, "GBLON", restored.historySince.Add(-time.Nanosecond))
+		assert.ErrorIs(t, err, errs.ErrHistoryExpired)
+		port, err := restored.GetAsOf(ctx, "GBLON", restored.historySince)
+		require.NoError(t, err)
+		assert.Equal(t, "United Kingdom", port.Country)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		t.Parallel()
+
+		db := NewMemoryDB()
+		require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+		created := time.Now()
+		time.Sleep(time.Millisecond)
+		require.NoError(t, db.Delete(ctx, "GBLON"))
+		_, err := db.PurgeTombstones(ctx, time.Now().Add(time.Second))
+		require.NoError(t, err)
+
+		// The purged port may have existed before its delete
+		_, err = db.GetAsOf(ctx, "GBLON", created)
+		assert.ErrorIs(t, err, errs.ErrHistoryExpired)
+		port, err := db.GetAsOf(ctx, "GBLON", time.Now())
+		require.NoError(t, err)
+		assert.Nil(t, port)
+	})
+}
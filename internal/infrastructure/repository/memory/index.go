@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+)
+
+// index maps a field value to the set of port ids having that value.
+type index map[string]map[string]struct{}
+
+// add registers the id under the given value. Empty values are not indexed.
+func (idx index) add(value, id string) {
+	if value == "" {
+		return
+	}
+
+	ids, ok := idx[value]
+	if !ok {
+		ids = make(map[string]struct{})
+		idx[value] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// remove unregisters the id from the given value and drops the value once it has no ids left.
+func (idx index) remove(value, id string) {
+	ids, ok := idx[value]
+	if !ok {
+		return
+	}
+
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(idx, value)
+	}
+}
+
+// lookup returns the ids registered under the given value in ascending order.
+func (idx index) lookup(value string) []string {
+	ids := make([]string, 0, len(idx[value]))
+	for id := range idx[value] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// indexKeys holds the values a port was indexed with.
+// They are kept apart from the stored port, so that stale entries can be removed
+// even if the caller modified the port after handing it to the database.
+type indexKeys struct {
+	country  string
+	code     string
+	timezone string
+	unlocs   []string
+}
+
+// newIndexKeys copies the indexed values of the given port.
+func newIndexKeys(port *model.Port) indexKeys {
+	return indexKeys{
+		country:  port.Country,
+		code:     port.Code,
+		timezone: port.Timezone,
+		unlocs:   append([]string(nil), port.Unlocs...),
+	}
+}
+
+// indexes groups the secondary indexes of the memory database.
+type indexes struct {
+	keys       map[string]indexKeys
+	byCountry  index
+	byCode     index
+	byTimezone index
+	byUnloc    index
+}
+
+// newIndexes creates empty secondary indexes.
+func newIndexes() indexes {
+	return indexes{
+		keys:       make(map[string]indexKeys),
+		byCountry:  make(index),
+		byCode:     make(index),
+		byTimezone: make(index),
+		byUnloc:    make(index),
+	}
+}
+
+// put indexes the port, replacing the entries of its previous values.
+func (idx indexes) put(port *model.Port) {
+	idx.drop(port.ID)
+
+	keys := newIndexKeys(port)
+	idx.keys[port.ID] = keys
+
+	idx.byCountry.add(keys.country, port.ID)
+	idx.byCode.add(keys.code, port.ID)
+	idx.byTimezone.add(keys.timezone, port.ID)
+	for _, unloc := range keys.unlocs {
+		idx.byUnloc.add(unloc, port.ID)
+	}
+}
+
+// drop removes every index entry of the port with the given id.
+func (idx indexes) drop(id string) {
+	keys, ok := idx.keys[id]
+	if !ok {
+		return
+	}
+
+	idx.byCountry.remove(keys.country, id)
+	idx.byCode.remove(keys.code, id)
+	idx.byTimezone.remove(keys.timezone, id)
+	for _, unloc := range keys.unlocs {
+		idx.byUnloc.remove(unloc, id)
+	}
+	delete(idx.keys, id)
+}
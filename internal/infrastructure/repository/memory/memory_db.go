@@ -2,25 +2,102 @@ package memory
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/canbo-x/port-service/internal/domain/model"
 	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
 )
 
 // MemoryDB represents an in-memory database for ports.
 type MemoryDB struct {
 	mu    sync.RWMutex
 	ports map[string]*model.Port
+
+	// versions keeps the version of every port, which is the sequence number of its last change.
+	versions map[string]uint64
+
+	// ids keeps the port ids in ascending order for the listing.
+	// It is rebuilt lazily when idsDirty is set, so that bulk imports
+	// in random order do not pay for a sorted insert on every write.
+	ids      []string
+	idsDirty bool
+
+	// indexes keeps the secondary indexes for the lookups by field.
+	indexes indexes
+
+	// seq is the sequence number of the last applied change.
+	// It ties the snapshots and the write-ahead log together.
+	seq uint64
+
+	// wal is the optional write-ahead log every change is appended to before it is applied.
+	wal *WAL
+
+	// history keeps the latest revisions of every port, including the deleted ones,
+	// ordered from the oldest. At most historyLimit revisions are kept per port.
+	history      map[string][]*repository.Revision
+	historyLimit int
+
+	// historySince is the time since which the history holds every change, apart from the revisions
+	// over the limit. It is zero when the history holds every change since the database was created.
+	historySince time.Time
+
+	// tombstones keeps the deleted ports until they are restored or purged.
+	tombstones map[string]*repository.Tombstone
+
+	// watchers receive the applied changes. They have their own lock,
+	// so that they can be removed without waiting for the writes.
+	watchMu     sync.Mutex
+	watchers    map[*watcher]struct{}
+	watchBuffer int
 }
 
+// Ensure that MemoryDB implements the PortRepository, HistoryRepository and Replicable interfaces.
+var (
+	_ repository.PortRepository    = (*MemoryDB)(nil)
+	_ repository.HistoryRepository = (*MemoryDB)(nil)
+	_ repository.Replicable        = (*MemoryDB)(nil)
+)
+
 // NewMemoryDB creates a new instance of MemoryDB.
-func NewMemoryDB() repository.PortRepository {
+// It returns the concrete type so that the callers can also reach the
+// memory specific features such as the snapshots.
+func NewMemoryDB() *MemoryDB {
 	return &MemoryDB{
-		ports: make(map[string]*model.Port),
+		ports:        make(map[string]*model.Port),
+		versions:     make(map[string]uint64),
+		indexes:      newIndexes(),
+		history:      make(map[string][]*repository.Revision),
+		historyLimit: DefaultHistoryLimit,
+		tombstones:   make(map[string]*repository.Tombstone),
+		watchers:     make(map[*watcher]struct{}),
+		watchBuffer:  DefaultWatchBuffer,
 	}
 }
 
+// Successor returns a new, empty memory database meant to replace this one, such as by a reload.
+// It has the same history limit and watch buffer, and its versions continue after the ones given here,
+// so that no version is given twice across the replacement. It has no write-ahead log.
+func (db *MemoryDB) Successor() *MemoryDB {
+	next := NewMemoryDB()
+
+	db.mu.RLock()
+	next.seq = db.seq
+	next.historyLimit = db.historyLimit
+	db.mu.RUnlock()
+
+	// The replaced ports are not in the history of the successor
+	next.historySince = time.Now()
+
+	db.watchMu.Lock()
+	next.watchBuffer = db.watchBuffer
+	db.watchMu.Unlock()
+
+	return next
+}
+
 // Upsert inserts or updates a port in the memory database.
 // Please read the readme file for more information about the context.
 // This is just a demonstration and more details can be found in the `Personal Thoughts and Notes` section.
@@ -32,10 +109,47 @@ func (db *MemoryDB) Upsert(ctx context.Context, port *model.Port) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
-		db.ports[port.ID] = port
+		return db.upsert(ctx, port)
 	}
+}
 
-	return nil
+// UpsertIfVersion inserts or updates a port in the memory database if its current version is the given one.
+// It returns the new version, or ErrVersionMismatch if the port has another version.
+func (db *MemoryDB) UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+		if db.versions[port.ID] != version {
+			return 0, errs.ErrVersionMismatch
+		}
+		if err := db.upsert(ctx, port); err != nil {
+			return 0, err
+		}
+
+		return db.versions[port.ID], nil
+	}
+}
+
+// UpsertMany inserts or updates all the ports in the memory database as a single change.
+// The ports are logged as a single record, so a crash cannot leave only some of them applied.
+func (db *MemoryDB) UpsertMany(ctx context.Context, ports []*model.Port) error {
+	if err := validateBatch(ports); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return db.upsertMany(ctx, ports)
+	}
 }
 
 // Get returns a port from the memory database.
@@ -57,6 +171,92 @@ func (db *MemoryDB) Get(ctx context.Context, id string) (*model.Port, error) {
 	}
 }
 
+// GetVersioned returns a port from the memory database together with its version.
+func (db *MemoryDB) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	default:
+		return db.ports[id], db.versions[id], nil
+	}
+}
+
+// Delete removes a port from the memory database.
+// It returns ErrPortNotFound if the port does not exist.
+func (db *MemoryDB) Delete(ctx context.Context, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return db.delete(ctx, id)
+	}
+}
+
+// DeleteIfVersion removes a port from the memory database if its current version is the given one.
+// It returns ErrPortNotFound if the port does not exist, or ErrVersionMismatch if it has another version.
+func (db *MemoryDB) DeleteIfVersion(ctx context.Context, id string, version uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		current, ok := db.versions[id]
+		if ok && current != version {
+			return errs.ErrVersionMismatch
+		}
+
+		return db.delete(ctx, id)
+	}
+}
+
+// FindByCountry returns the ports of the given country ordered by their id.
+func (db *MemoryDB) FindByCountry(ctx context.Context, country string) ([]*model.Port, error) {
+	return db.find(ctx, db.indexes.byCountry, country)
+}
+
+// FindByUnloc returns the ports having the given UN/LOCODE ordered by their id.
+func (db *MemoryDB) FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error) {
+	return db.find(ctx, db.indexes.byUnloc, unloc)
+}
+
+// FindByCode returns the ports having the given code ordered by their id.
+func (db *MemoryDB) FindByCode(ctx context.Context, code string) ([]*model.Port, error) {
+	return db.find(ctx, db.indexes.byCode, code)
+}
+
+// FindByTimezone returns the ports in the given timezone ordered by their id.
+func (db *MemoryDB) FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error) {
+	return db.find(ctx, db.indexes.byTimezone, timezone)
+}
+
+// find returns the ports registered under the value of the given secondary index.
+func (db *MemoryDB) find(ctx context.Context, idx index, value string) ([]*model.Port, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	ids := idx.lookup(value)
+	ports := make([]*model.Port, 0, len(ids))
+	for _, id := range ids {
+		ports = append(ports, db.ports[id])
+	}
+
+	return ports, nil
+}
+
 // GetLength returns the number of ports in the memory database.
 // no test provided for this method to not complicate the example
 func (db *MemoryDB) GetLength(ctx context.Context) int {
@@ -70,3 +270,284 @@ func (db *MemoryDB) GetLength(ctx context.Context) int {
 		return len(db.ports)
 	}
 }
+
+// List returns a page of ports ordered by their id.
+// The cursor is the one returned by the previous call, or empty for the first page.
+func (db *MemoryDB) List(ctx context.Context, opts repository.ListOptions) (*repository.ListResult, error) {
+	if opts.Limit <= 0 {
+		return nil, errs.ErrInvalidLimit
+	}
+
+	after, err := repository.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Deleted {
+		return db.listTombstones(ctx, after, opts.Limit)
+	}
+
+	db.rLockSorted()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	start := 0
+	if after != "" {
+		start = sort.SearchStrings(db.ids, after)
+		if start < len(db.ids) && db.ids[start] == after {
+			start++
+		}
+	}
+
+	end := start + opts.Limit
+	if end > len(db.ids) {
+		end = len(db.ids)
+	}
+
+	result := &repository.ListResult{
+		Ports: make([]*model.Port, 0, end-start),
+	}
+	for _, id := range db.ids[start:end] {
+		result.Ports = append(result.Ports, db.ports[id])
+	}
+
+	if end < len(db.ids) {
+		result.NextCursor = repository.EncodeCursor(db.ids[end-1])
+	}
+
+	return result, nil
+}
+
+// upsert logs and applies the upsert of the port, which gets the sequence number of the change as its version.
+// The caller must hold the write lock.
+func (db *MemoryDB) upsert(ctx context.Context, port *model.Port) error {
+	record := newWALRecord(ctx, walOpUpsert, port.ID, port)
+	if err := db.log(record); err != nil {
+		return err
+	}
+	db.applyUpsert(port, db.seq)
+	db.remember(record)
+
+	return nil
+}
+
+// upsertMany logs and applies the upsert of the ports as a single change,
+// all of them get the sequence number of the change as their version.
+// The caller must hold the write lock and check the context, nothing can fail once the change is logged.
+func (db *MemoryDB) upsertMany(ctx context.Context, ports []*model.Port) error {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	record := newWALRecord(ctx, walOpUpsertMany, "", nil)
+	record.Ports = ports
+	if err := db.log(record); err != nil {
+		return err
+	}
+	db.applyUpsertMany(ports, db.seq)
+	db.remember(record)
+
+	return nil
+}
+
+// delete logs and applies the delete of the port.
+// It returns ErrPortNotFound if the port does not exist.
+// The caller must hold the write lock.
+func (db *MemoryDB) delete(ctx context.Context, id string) error {
+	if _, ok := db.ports[id]; !ok {
+		return errs.ErrPortNotFound
+	}
+
+	record := newWALRecord(ctx, walOpDelete, id, nil)
+	if err := db.log(record); err != nil {
+		return err
+	}
+	db.applyDelete(id, db.seq, record.Time)
+	db.remember(record)
+
+	return nil
+}
+
+// log appends the change to the write-ahead log, if any, and advances the sequence number.
+// Nothing is applied when the append fails, so the log never misses an applied change.
+// The caller must hold the write lock.
+func (db *MemoryDB) log(record walRecord) error {
+	record.Seq = db.seq + 1
+	if db.wal != nil {
+		if err := db.wal.append(record); err != nil {
+			return err
+		}
+	}
+	db.seq = record.Seq
+
+	return nil
+}
+
+// applyUpsert stores the port with its new version, updates the ordering and the indexes,
+// drops the tombstone of the port if any and notifies the watchers.
+// The caller must hold the write lock.
+func (db *MemoryDB) applyUpsert(port *model.Port, version uint64) {
+	old, ok := db.ports[port.ID]
+	if !ok {
+		db.addID(port.ID)
+	}
+	delete(db.tombstones, port.ID)
+	db.ports[port.ID] = port
+	db.versions[port.ID] = version
+	db.indexes.put(port)
+	db.publish(old, port, version)
+}
+
+// applyUpsertMany stores the ports of a batch with their new version.
+// The caller must hold the write lock.
+func (db *MemoryDB) applyUpsertMany(ports []*model.Port, version uint64) {
+	for _, port := range ports {
+		db.applyUpsert(port, version)
+	}
+}
+
+// validateBatch returns ErrInvalidInput if any port of the batch is nil.
+func validateBatch(ports []*model.Port) error {
+	for _, port := range ports {
+		if port == nil {
+			return errs.ErrInvalidInput
+		}
+	}
+
+	return nil
+}
+
+// applyDelete moves the port to a tombstone, removes its ordering and index entries and notifies the watchers.
+// The version is the sequence number of the delete and the time is the one of the delete.
+// The caller must hold the write lock.
+func (db *MemoryDB) applyDelete(id string, version uint64, at time.Time) {
+	old := db.ports[id]
+	db.tombstones[id] = &repository.Tombstone{Port: old, DeletedAt: at}
+	delete(db.ports, id)
+	delete(db.versions, id)
+	db.indexes.drop(id)
+	db.removeID(id)
+	db.publish(old, nil, version)
+}
+
+// snapshot returns all the ports and the tombstones ordered by their id together with the sequence number
+// they reflect.
+func (db *MemoryDB) snapshot() ([]*model.Port, []*repository.Tombstone, uint64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ports := make([]*model.Port, 0, len(db.ports))
+	for _, port := range db.ports {
+		ports = append(ports, port)
+	}
+	sortPorts(ports)
+
+	return ports, db.sortedTombstones(), db.seq
+}
+
+// restore replaces the whole content of the memory database with the given ports, as they were at the given time.
+// The snapshots do not hold the history, so it starts over at that time. They do not hold the versions either,
+// so every port gets the sequence number of the snapshot, which is not older than any version it had.
+// The watchers cannot follow the replacement, so they are sent a resync.
+func (db *MemoryDB) restore(ports []*model.Port, tombstones []*repository.Tombstone, seq uint64, at time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.replace(ports, nil, tombstones, seq, at)
+}
+
+// replace replaces the whole content of the memory database as restore does, the ports getting their version
+// from the versions, or the sequence number when they have none.
+// The caller must hold the write lock.
+func (db *MemoryDB) replace(
+	ports []*model.Port,
+	versions map[string]uint64,
+	tombstones []*repository.Tombstone,
+	seq uint64,
+	at time.Time,
+) {
+	db.ports = make(map[string]*model.Port, len(ports))
+	db.versions = make(map[string]uint64, len(ports))
+	db.indexes = newIndexes()
+	db.history = make(map[string][]*repository.Revision)
+	db.historySince = at
+	db.tombstones = make(map[string]*repository.Tombstone, len(tombstones))
+	db.ids = db.ids[:0]
+	db.idsDirty = true
+	db.seq = seq
+	for _, port := range ports {
+		version, ok := versions[port.ID]
+		if !ok {
+			version = seq
+		}
+		db.ports[port.ID] = port
+		db.versions[port.ID] = version
+		db.indexes.put(port)
+	}
+	for _, tombstone := range tombstones {
+		db.tombstones[tombstone.Port.ID] = tombstone
+	}
+	db.resync()
+}
+
+// addID registers a new port id in the ordering.
+// Ids arriving in ascending order are appended, any other id invalidates the ordering.
+// The caller must hold the write lock.
+func (db *MemoryDB) addID(id string) {
+	if db.idsDirty {
+		return
+	}
+
+	if n := len(db.ids); n > 0 && db.ids[n-1] > id {
+		db.idsDirty = true
+		return
+	}
+
+	db.ids = append(db.ids, id)
+}
+
+// removeID removes a deleted port id from the ordering.
+// The caller must hold the write lock.
+func (db *MemoryDB) removeID(id string) {
+	if db.idsDirty {
+		return
+	}
+
+	i := sort.SearchStrings(db.ids, id)
+	if i < len(db.ids) && db.ids[i] == id {
+		db.ids = append(db.ids[:i], db.ids[i+1:]...)
+	}
+}
+
+// rLockSorted acquires the read lock once the ordering of the port ids is up to date.
+func (db *MemoryDB) rLockSorted() {
+	db.mu.RLock()
+	// The ordering can only be rebuilt under the write lock.
+	// Loop because another writer can invalidate it again before the read lock is re-acquired.
+	for db.idsDirty {
+		db.mu.RUnlock()
+		db.mu.Lock()
+		db.sortIDs()
+		db.mu.Unlock()
+		db.mu.RLock()
+	}
+}
+
+// sortIDs rebuilds the ordering from the stored ports if it was invalidated.
+// The caller must hold the write lock.
+func (db *MemoryDB) sortIDs() {
+	if !db.idsDirty {
+		return
+	}
+
+	db.ids = db.ids[:0]
+	for id := range db.ports {
+		db.ids = append(db.ids, id)
+	}
+	sort.Strings(db.ids)
+	db.idsDirty = false
+}
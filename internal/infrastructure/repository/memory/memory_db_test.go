@@ -10,6 +10,8 @@ import (
 
 	"github.com/canbo-x/port-service/internal/domain/model"
 	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	errs "github.com/canbo-x/port-service/internal/error"
 )
 
 func createPort() *model.Port {
@@ -58,17 +60,36 @@ func TestMemoryDB(t *testing.T) {
 				assert.Nil(t, retrievedPort)
 			},
 		},
+		{
+			name: "Delete",
+			testFunc: func(t *testing.T, db repository.PortRepository) {
+				port := createPort()
+				port.ID = "NLRTM"
+
+				ctx := context.Background()
+				require.NoError(t, db.Upsert(ctx, port))
+
+				// Test Delete
+				require.NoError(t, db.Delete(ctx, "NLRTM"))
+				retrievedPort, err := db.Get(ctx, "NLRTM")
+				require.NoError(t, err)
+				assert.Nil(t, retrievedPort)
+
+				// Test Delete with non-existent ID
+				assert.ErrorIs(t, db.Delete(ctx, "NLRTM"), errs.ErrPortNotFound)
+			},
+		},
 		{
 			name: "ContextCancellation_Upsert",
 			testFunc: func(t *testing.T, db repository.PortRepository) {
 				port := createPort()
 
 				// Test context cancellation for Upsert
-				ctx, cancel := context.WithTimeout(context.Background(), 1*time.Millisecond)
+				// A deadline which has already passed marks the context as done right away
+				ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
 				defer cancel()
-				time.Sleep(2 * time.Millisecond)
 				err := db.Upsert(ctx, port)
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
 			},
 		},
 	}
@@ -82,3 +103,115 @@ func TestMemoryDB(t *testing.T) {
 		})
 	}
 }
+
+func TestMemoryDBList(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+
+	// Insert the ports out of order to exercise the lazy ordering
+	for _, id := range []string{"FRPAR", "GBLON", "AEAJM", "NLRTM", "DEHAM"} {
+		port := createPort()
+		port.ID = id
+		require.NoError(t, db.Upsert(ctx, port))
+	}
+
+	var ids []string
+	cursor := ""
+	for {
+		page, err := db.List(ctx, repository.ListOptions{Cursor: cursor, Limit: 2})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Ports), 2)
+
+		for _, port := range page.Ports {
+			ids = append(ids, port.ID)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []string{"AEAJM", "DEHAM", "FRPAR", "GBLON", "NLRTM"}, ids)
+
+	// Updating an existing port must not duplicate it in the listing
+	require.NoError(t, db.Upsert(ctx, createPort()))
+	page, err := db.List(ctx, repository.ListOptions{Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, page.Ports, 5)
+	assert.Empty(t, page.NextCursor)
+
+	// Deleted ports must disappear from the listing
+	require.NoError(t, db.Delete(ctx, "DEHAM"))
+	page, err = db.List(ctx, repository.ListOptions{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "AEAJM", page.Ports[0].ID)
+	assert.Equal(t, "FRPAR", page.Ports[1].ID)
+
+	_, err = db.List(ctx, repository.ListOptions{Cursor: "!!!", Limit: 10})
+	assert.ErrorIs(t, err, errs.ErrInvalidCursor)
+
+	_, err = db.List(ctx, repository.ListOptions{Limit: 0})
+	assert.ErrorIs(t, err, errs.ErrInvalidLimit)
+}
+
+func TestMemoryDBIndexes(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+
+	london := createPort()
+	paris := createPort()
+	paris.ID = "FRPAR"
+	paris.Country = "France"
+	paris.Timezone = "Europe/Paris"
+	paris.Unlocs = []string{"FRPAR", "FRPA2"}
+	paris.Code = "23456"
+	require.NoError(t, db.Upsert(ctx, london))
+	require.NoError(t, db.Upsert(ctx, paris))
+
+	ports, err := db.FindByCountry(ctx, "United Kingdom")
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Port{london}, ports)
+
+	ports, err = db.FindByUnloc(ctx, "FRPA2")
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Port{paris}, ports)
+
+	ports, err = db.FindByCode(ctx, "12345")
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Port{london}, ports)
+
+	ports, err = db.FindByTimezone(ctx, "Europe/Paris")
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Port{paris}, ports)
+
+	// Moving a port to another country must remove the stale entries,
+	// even when the caller modified the stored port in place
+	paris.Country = "United Kingdom"
+	paris.Unlocs = []string{"FRPAR"}
+	require.NoError(t, db.Upsert(ctx, paris))
+
+	ports, err = db.FindByCountry(ctx, "United Kingdom")
+	require.NoError(t, err)
+	assert.Equal(t, []*model.Port{paris, london}, ports)
+
+	ports, err = db.FindByCountry(ctx, "France")
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+
+	ports, err = db.FindByUnloc(ctx, "FRPA2")
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+
+	// Deleted ports must disappear from the indexes
+	require.NoError(t, db.Delete(ctx, "GBLON"))
+	ports, err = db.FindByCode(ctx, "12345")
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+}
+
+func TestMemoryDBConformance(t *testing.T) {
+	repositorytest.Run(t, func() repository.PortRepository {
+		return NewMemoryDB()
+	})
+}
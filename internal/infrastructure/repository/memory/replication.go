@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// Version returns the sequence number of the last applied change.
+func (db *MemoryDB) Version(ctx context.Context) uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.seq
+}
+
+// Snapshot returns all the ports with their versions and the tombstones, ordered by their id,
+// together with the sequence number they reflect.
+func (db *MemoryDB) Snapshot(ctx context.Context) (*repository.Snapshot, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	snapshot := &repository.Snapshot{
+		Version:    db.seq,
+		Ports:      make([]*model.Port, 0, len(db.ports)),
+		Versions:   make(map[string]uint64, len(db.versions)),
+		Tombstones: db.sortedTombstones(),
+	}
+	for id, port := range db.ports {
+		snapshot.Ports = append(snapshot.Ports, port)
+		snapshot.Versions[id] = db.versions[id]
+	}
+	sortPorts(snapshot.Ports)
+
+	return snapshot, nil
+}
+
+// ResetReplica replaces the whole content of the memory database with the snapshot,
+// and continues the sequence numbers after the one of the snapshot. The history starts over.
+// A database with a write-ahead log numbers the changes itself, so it returns ErrReplicationNotSupported.
+func (db *MemoryDB) ResetReplica(ctx context.Context, snapshot *repository.Snapshot) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if db.wal != nil {
+		return errs.ErrReplicationNotSupported
+	}
+
+	db.replace(snapshot.Ports, snapshot.Versions, snapshot.Tombstones, snapshot.Version, time.Now())
+
+	return nil
+}
+
+// ApplyReplica applies the change made by another database, which becomes the last applied change.
+// The changes of a batch share their version, so a change can have the version of the last applied one.
+// A deleted or purged port which does not exist returns ErrPortNotFound.
+// A database with a write-ahead log numbers the changes itself, so it returns ErrReplicationNotSupported.
+func (db *MemoryDB) ApplyReplica(ctx context.Context, change repository.Change) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if db.wal != nil {
+		return errs.ErrReplicationNotSupported
+	}
+	if change.Version < db.seq {
+		return errs.ErrInvalidInput
+	}
+
+	switch change.Type {
+	case repository.ChangeCreated, repository.ChangeUpdated:
+		if change.New == nil || change.New.ID != change.ID {
+			return errs.ErrInvalidInput
+		}
+
+		record := newWALRecord(ctx, walOpUpsert, change.ID, change.New)
+		record.Seq = change.Version
+		db.seq = change.Version
+		db.applyUpsert(change.New, change.Version)
+		db.remember(record)
+	case repository.ChangeDeleted:
+		if _, ok := db.ports[change.ID]; !ok {
+			return errs.ErrPortNotFound
+		}
+
+		record := newWALRecord(ctx, walOpDelete, change.ID, nil)
+		record.Seq = change.Version
+		db.seq = change.Version
+		db.applyDelete(change.ID, change.Version, record.Time)
+		db.remember(record)
+	case repository.ChangePurged:
+		if _, ok := db.tombstones[change.ID]; !ok {
+			return errs.ErrPortNotFound
+		}
+
+		db.seq = change.Version
+		db.applyPurge(change.ID, change.Version)
+	default:
+		return errs.ErrInvalidInput
+	}
+
+	return nil
+}
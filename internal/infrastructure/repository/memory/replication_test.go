@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// replicate applies the watched changes of the leader to the replica until the leader reaches the version.
+func replicate(t *testing.T, changes <-chan repository.Change, replica *MemoryDB, version uint64) {
+	t.Helper()
+
+	ctx := context.Background()
+	for replica.Version(ctx) < version {
+		require.NoError(t, replica.ApplyReplica(ctx, receive(t, changes)))
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, leader, replica *MemoryDB)
+	}{
+		{
+			name: "ReplicaStartsFromTheSnapshotWithTheSameVersions",
+			testFunc: func(t *testing.T, leader, replica *MemoryDB) {
+				require.NoError(t, leader.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				require.NoError(t, leader.Upsert(ctx, repositorytest.NewPort("FRPAR", "France")))
+				require.NoError(t, leader.Upsert(ctx, repositorytest.NewPort("NLRTM", "Netherlands")))
+				require.NoError(t, leader.Delete(ctx, "NLRTM"))
+
+				snapshot, err := leader.Snapshot(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, uint64(4), snapshot.Version)
+				require.Len(t, snapshot.Ports, 2)
+				assert.Equal(t, "FRPAR", snapshot.Ports[0].ID)
+				assert.Equal(t, map[string]uint64{"GBLON": 1, "FRPAR": 2}, snapshot.Versions)
+				require.Len(t, snapshot.Tombstones, 1)
+
+				require.NoError(t, replica.ResetReplica(ctx, snapshot))
+				assert.Equal(t, uint64(4), replica.Version(ctx))
+				_, version, err := replica.GetVersioned(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, uint64(1), version)
+				tombstone, err := replica.GetTombstone(ctx, "NLRTM")
+				require.NoError(t, err)
+				assert.Equal(t, "Netherlands", tombstone.Port.Country)
+			},
+		},
+		{
+			name: "ReplicaAppliesTheWatchedChanges",
+			testFunc: func(t *testing.T, leader, replica *MemoryDB) {
+				changes, err := leader.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				require.NoError(t, leader.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				require.NoError(t, leader.UpsertMany(ctx, []*model.Port{
+					repositorytest.NewPort("GBLON", "France"),
+					repositorytest.NewPort("FRPAR", "France"),
+				}))
+				require.NoError(t, leader.Delete(ctx, "FRPAR"))
+				_, err = leader.Restore(ctx, "FRPAR")
+				require.NoError(t, err)
+				require.NoError(t, leader.Delete(ctx, "GBLON"))
+				purged, err := leader.PurgeTombstones(ctx, time.Now().Add(time.Hour))
+				require.NoError(t, err)
+				require.Equal(t, 1, purged)
+
+				replicate(t, changes, replica, leader.Version(ctx))
+
+				expected, err := leader.Snapshot(ctx)
+				require.NoError(t, err)
+				actual, err := replica.Snapshot(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Ports, actual.Ports)
+				assert.Equal(t, expected.Versions, actual.Versions)
+				assert.Empty(t, actual.Tombstones)
+
+				// The replica keeps its own history of the applied changes
+				revisions, err := replica.History(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Len(t, revisions, 3)
+			},
+		},
+		{
+			name: "OlderOrInvalidChangesAreRejected",
+			testFunc: func(t *testing.T, leader, replica *MemoryDB) {
+				port := repositorytest.NewPort("GBLON", "United Kingdom")
+				created := repository.Change{Type: repository.ChangeCreated, ID: "GBLON", Version: 5, New: port}
+				require.NoError(t, replica.ApplyReplica(ctx, created))
+
+				// The changes of a batch share their version
+				batched := repository.Change{Type: repository.ChangeCreated, ID: "FRPAR", Version: 5,
+					New: repositorytest.NewPort("FRPAR", "France")}
+				require.NoError(t, replica.ApplyReplica(ctx, batched))
+
+				created.Version = 4
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, created), errs.ErrInvalidInput)
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, repository.Change{
+					Type: repository.ChangeUpdated, ID: "FRPAR", Version: 6, New: port,
+				}), errs.ErrInvalidInput)
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, repository.Change{
+					Type: repository.ChangeResync, Version: 6,
+				}), errs.ErrInvalidInput)
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, repository.Change{
+					Type: repository.ChangeDeleted, ID: "NLRTM", Version: 6,
+				}), errs.ErrPortNotFound)
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, repository.Change{
+					Type: repository.ChangePurged, ID: "GBLON", Version: 6,
+				}), errs.ErrPortNotFound)
+				assert.Equal(t, uint64(5), replica.Version(ctx))
+			},
+		},
+		{
+			name: "ResetSendsAResync",
+			testFunc: func(t *testing.T, leader, replica *MemoryDB) {
+				changes, err := replica.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				snapshot, err := leader.Snapshot(ctx)
+				require.NoError(t, err)
+				require.NoError(t, replica.ResetReplica(ctx, snapshot))
+
+				assert.Equal(t, repository.ChangeResync, receive(t, changes).Type)
+				requireClosed(t, changes)
+			},
+		},
+		{
+			name: "ReplicaWithAWriteAheadLogIsNotSupported",
+			testFunc: func(t *testing.T, leader, replica *MemoryDB) {
+				wal, err := OpenWAL(filepath.Join(t.TempDir(), "ports.wal"), false)
+				require.NoError(t, err)
+				defer wal.Close()
+				_, err = replica.AttachWAL(wal)
+				require.NoError(t, err)
+
+				snapshot, err := leader.Snapshot(ctx)
+				require.NoError(t, err)
+				assert.ErrorIs(t, replica.ResetReplica(ctx, snapshot), errs.ErrReplicationNotSupported)
+				assert.ErrorIs(t, replica.ApplyReplica(ctx, repository.Change{
+					Type: repository.ChangeCreated, ID: "GBLON", Version: 1,
+					New: repositorytest.NewPort("GBLON", "United Kingdom"),
+				}), errs.ErrReplicationNotSupported)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t, NewMemoryDB(), NewMemoryDB())
+		})
+	}
+}
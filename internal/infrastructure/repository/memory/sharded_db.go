@@ -0,0 +1,300 @@
+package memory
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+)
+
+// DefaultShardCount is the number of shards used when a non-positive count is requested.
+const DefaultShardCount = 32
+
+// ShardedMemoryDB represents an in-memory database for ports split into independently locked shards.
+//
+// The ports are assigned to the shards by the hash of their id, so the writes of different ports
+// rarely wait for each other. The methods reading several shards, such as List and the lookups,
+// visit the shards one after the other and do not see them at a single point in time.
+// The snapshots and the write-ahead log are not supported.
+type ShardedMemoryDB struct {
+	shards []*MemoryDB
+}
+
+// Ensure that ShardedMemoryDB implements the PortRepository and HistoryRepository interfaces.
+var (
+	_ repository.PortRepository    = (*ShardedMemoryDB)(nil)
+	_ repository.HistoryRepository = (*ShardedMemoryDB)(nil)
+)
+
+// NewShardedMemoryDB creates a new instance of ShardedMemoryDB with the given number of shards.
+// DefaultShardCount is used if the count is not positive.
+func NewShardedMemoryDB(count int) *ShardedMemoryDB {
+	if count <= 0 {
+		count = DefaultShardCount
+	}
+
+	shards := make([]*MemoryDB, count)
+	for i := range shards {
+		shards[i] = NewMemoryDB()
+	}
+
+	return &ShardedMemoryDB{shards: shards}
+}
+
+// Successor returns a new, empty sharded database meant to replace this one, such as by a reload.
+// Every shard is the successor of the shard at the same position, which owns the same ports.
+func (db *ShardedMemoryDB) Successor() *ShardedMemoryDB {
+	shards := make([]*MemoryDB, len(db.shards))
+	for i, shard := range db.shards {
+		shards[i] = shard.Successor()
+	}
+
+	return &ShardedMemoryDB{shards: shards}
+}
+
+// shard returns the shard owning the given port id.
+func (db *ShardedMemoryDB) shard(id string) *MemoryDB {
+	return db.shards[db.shardIndex(id)]
+}
+
+// shardIndex returns the position of the shard owning the given port id.
+func (db *ShardedMemoryDB) shardIndex(id string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+
+	return int(h.Sum32() % uint32(len(db.shards)))
+}
+
+// Upsert inserts or updates a port in its shard.
+func (db *ShardedMemoryDB) Upsert(ctx context.Context, port *model.Port) error {
+	return db.shard(port.ID).Upsert(ctx, port)
+}
+
+// UpsertMany inserts or updates all the ports in their shards as a single change.
+// The shards of the batch are locked together, so no write or single port read sees only a part of it.
+// The methods reading several shards can still see a part of it, as they do not lock the shards together.
+func (db *ShardedMemoryDB) UpsertMany(ctx context.Context, ports []*model.Port) error {
+	if err := validateBatch(ports); err != nil {
+		return err
+	}
+
+	batches := make(map[int][]*model.Port)
+	for _, port := range ports {
+		i := db.shardIndex(port.ID)
+		batches[i] = append(batches[i], port)
+	}
+
+	// Lock the shards in ascending order, so that concurrent batches cannot deadlock
+	indexes := make([]int, 0, len(batches))
+	for i := range batches {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		db.shards[i].mu.Lock()
+		defer db.shards[i].mu.Unlock()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	// The shards have no write-ahead log, so applying a batch cannot fail
+	for _, i := range indexes {
+		if err := db.shards[i].upsertMany(ctx, batches[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a port from its shard.
+func (db *ShardedMemoryDB) Get(ctx context.Context, id string) (*model.Port, error) {
+	return db.shard(id).Get(ctx, id)
+}
+
+// Delete removes a port from its shard.
+// It returns ErrPortNotFound if the port does not exist.
+func (db *ShardedMemoryDB) Delete(ctx context.Context, id string) error {
+	return db.shard(id).Delete(ctx, id)
+}
+
+// GetVersioned returns a port from its shard together with its version.
+// The versions are given by every shard on its own, which is enough as a port never changes its shard.
+func (db *ShardedMemoryDB) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	return db.shard(id).GetVersioned(ctx, id)
+}
+
+// UpsertIfVersion inserts or updates a port in its shard if its current version is the given one.
+func (db *ShardedMemoryDB) UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error) {
+	return db.shard(port.ID).UpsertIfVersion(ctx, port, version)
+}
+
+// DeleteIfVersion removes a port from its shard if its current version is the given one.
+func (db *ShardedMemoryDB) DeleteIfVersion(ctx context.Context, id string, version uint64) error {
+	return db.shard(id).DeleteIfVersion(ctx, id, version)
+}
+
+// GetTombstone returns the tombstone of the deleted port from its shard.
+func (db *ShardedMemoryDB) GetTombstone(ctx context.Context, id string) (*repository.Tombstone, error) {
+	return db.shard(id).GetTombstone(ctx, id)
+}
+
+// Restore brings the deleted port back from its tombstone in its shard.
+func (db *ShardedMemoryDB) Restore(ctx context.Context, id string) (uint64, error) {
+	return db.shard(id).Restore(ctx, id)
+}
+
+// PurgeTombstones removes for good the tombstones of the ports deleted before the given time in every shard.
+// It returns the number of removed tombstones, including the ones of the shards purged before a failure.
+func (db *ShardedMemoryDB) PurgeTombstones(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	for _, shard := range db.shards {
+		n, err := shard.PurgeTombstones(ctx, before)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+
+	return purged, nil
+}
+
+// SetHistoryLimit sets the number of revisions kept per port in every shard.
+// A limit of zero or less disables the history.
+func (db *ShardedMemoryDB) SetHistoryLimit(limit int) {
+	for _, shard := range db.shards {
+		shard.SetHistoryLimit(limit)
+	}
+}
+
+// History returns the kept revisions of the port from its shard.
+func (db *ShardedMemoryDB) History(ctx context.Context, id string) ([]*repository.Revision, error) {
+	return db.shard(id).History(ctx, id)
+}
+
+// GetRevision returns the revision of the port with the given number from its shard.
+func (db *ShardedMemoryDB) GetRevision(ctx context.Context, id string, number uint64) (*repository.Revision, error) {
+	return db.shard(id).GetRevision(ctx, id, number)
+}
+
+// GetAsOf returns the port as it was at the given time from its shard.
+func (db *ShardedMemoryDB) GetAsOf(ctx context.Context, id string, at time.Time) (*model.Port, error) {
+	return db.shard(id).GetAsOf(ctx, id, at)
+}
+
+// ListAsOf returns a page of the ports as they were at the given time, ordered by their id.
+// Every shard returns its own page after the cursor, the page is the start of their merge.
+func (db *ShardedMemoryDB) ListAsOf(
+	ctx context.Context,
+	at time.Time,
+	opts repository.ListOptions,
+) (*repository.ListResult, error) {
+	return db.merge(opts, func(shard *MemoryDB) (*repository.ListResult, error) {
+		return shard.ListAsOf(ctx, at, opts)
+	})
+}
+
+// FindByCountry returns the ports of the given country ordered by their id.
+func (db *ShardedMemoryDB) FindByCountry(ctx context.Context, country string) ([]*model.Port, error) {
+	return db.find(ctx, func(shard *MemoryDB) ([]*model.Port, error) {
+		return shard.FindByCountry(ctx, country)
+	})
+}
+
+// FindByUnloc returns the ports having the given UN/LOCODE ordered by their id.
+func (db *ShardedMemoryDB) FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error) {
+	return db.find(ctx, func(shard *MemoryDB) ([]*model.Port, error) {
+		return shard.FindByUnloc(ctx, unloc)
+	})
+}
+
+// FindByCode returns the ports having the given code ordered by their id.
+func (db *ShardedMemoryDB) FindByCode(ctx context.Context, code string) ([]*model.Port, error) {
+	return db.find(ctx, func(shard *MemoryDB) ([]*model.Port, error) {
+		return shard.FindByCode(ctx, code)
+	})
+}
+
+// FindByTimezone returns the ports in the given timezone ordered by their id.
+func (db *ShardedMemoryDB) FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error) {
+	return db.find(ctx, func(shard *MemoryDB) ([]*model.Port, error) {
+		return shard.FindByTimezone(ctx, timezone)
+	})
+}
+
+// find merges the results of the given lookup in every shard.
+func (db *ShardedMemoryDB) find(
+	ctx context.Context,
+	lookup func(shard *MemoryDB) ([]*model.Port, error),
+) ([]*model.Port, error) {
+	ports := make([]*model.Port, 0)
+	for _, shard := range db.shards {
+		found, err := lookup(shard)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, found...)
+	}
+	sortPorts(ports)
+
+	return ports, nil
+}
+
+// GetLength returns the number of ports summed across the shards.
+func (db *ShardedMemoryDB) GetLength(ctx context.Context) int {
+	length := 0
+	for _, shard := range db.shards {
+		length += shard.GetLength(ctx)
+	}
+
+	return length
+}
+
+// List returns a page of ports ordered by their id.
+// Every shard returns its own page after the cursor, the page is the start of their merge.
+func (db *ShardedMemoryDB) List(ctx context.Context, opts repository.ListOptions) (*repository.ListResult, error) {
+	return db.merge(opts, func(shard *MemoryDB) (*repository.ListResult, error) {
+		return shard.List(ctx, opts)
+	})
+}
+
+// merge merges the pages returned by the given listing in every shard into the page of the options.
+func (db *ShardedMemoryDB) merge(
+	opts repository.ListOptions,
+	list func(shard *MemoryDB) (*repository.ListResult, error),
+) (*repository.ListResult, error) {
+	ports := make([]*model.Port, 0)
+	more := false
+	for _, shard := range db.shards {
+		page, err := list(shard)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, page.Ports...)
+		more = more || page.NextCursor != ""
+	}
+	sortPorts(ports)
+
+	result := &repository.ListResult{Ports: ports}
+	if len(ports) > opts.Limit {
+		result.Ports = ports[:opts.Limit]
+		more = true
+	}
+	if more {
+		result.NextCursor = repository.EncodeCursor(result.Ports[len(result.Ports)-1].ID)
+	}
+
+	return result, nil
+}
+
+// sortPorts orders the ports by their id.
+func sortPorts(ports []*model.Port) {
+	sort.Slice(ports, func(i, j int) bool { return ports[i].ID < ports[j].ID })
+}
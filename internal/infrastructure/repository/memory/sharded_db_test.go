@@ -0,0 +1,197 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+)
+
+func TestShardedMemoryDB(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{
+			name: "DefaultShardCount",
+			testFunc: func(t *testing.T) {
+				db := NewShardedMemoryDB(0)
+				assert.Len(t, db.shards, DefaultShardCount)
+			},
+		},
+		{
+			name: "PortsAreSpreadAcrossShards",
+			testFunc: func(t *testing.T) {
+				ctx := context.Background()
+				db := NewShardedMemoryDB(4)
+
+				for i := 0; i < 100; i++ {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort(fmt.Sprintf("P%03d", i), "")))
+				}
+
+				// Every port lives in exactly one shard and the length is their sum
+				total := 0
+				for _, shard := range db.shards {
+					assert.NotZero(t, shard.GetLength(ctx))
+					total += shard.GetLength(ctx)
+				}
+				assert.Equal(t, 100, total)
+				assert.Equal(t, 100, db.GetLength(ctx))
+			},
+		},
+		{
+			name: "ListAcrossShards",
+			testFunc: func(t *testing.T) {
+				ctx := context.Background()
+				db := NewShardedMemoryDB(8)
+
+				for i := 99; i >= 0; i-- {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort(fmt.Sprintf("P%03d", i), "")))
+				}
+
+				var ids []string
+				opts := repository.ListOptions{Limit: 7}
+				for {
+					page, err := db.List(ctx, opts)
+					require.NoError(t, err)
+					for _, port := range page.Ports {
+						ids = append(ids, port.ID)
+					}
+					if page.NextCursor == "" {
+						break
+					}
+					opts.Cursor = page.NextCursor
+				}
+
+				require.Len(t, ids, 100)
+				for i, id := range ids {
+					assert.Equal(t, fmt.Sprintf("P%03d", i), id)
+				}
+			},
+		},
+		{
+			name: "SuccessorContinuesTheVersions",
+			testFunc: func(t *testing.T) {
+				ctx := context.Background()
+				db := NewShardedMemoryDB(4)
+
+				var versions []uint64
+				for i := 0; i < 20; i++ {
+					id := fmt.Sprintf("P%03d", i)
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort(id, "")))
+					_, version, err := db.GetVersioned(ctx, id)
+					require.NoError(t, err)
+					versions = append(versions, version)
+				}
+
+				// The successor starts empty, but never gives a port one of its previous versions
+				next := db.Successor()
+				assert.Equal(t, 0, next.GetLength(ctx))
+				for i, version := range versions {
+					id := fmt.Sprintf("P%03d", i)
+					created, err := next.UpsertIfVersion(ctx, repositorytest.NewPort(id, ""), 0)
+					require.NoError(t, err)
+					assert.Greater(t, created, version)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t)
+		})
+	}
+}
+
+func TestShardedMemoryDBConformance(t *testing.T) {
+	repositorytest.Run(t, func() repository.PortRepository {
+		return NewShardedMemoryDB(4)
+	})
+}
+
+// benchmarkProcs are the GOMAXPROCS settings the implementations are compared at.
+var benchmarkProcs = []int{1, 2, 4, 8}
+
+// benchmarkRepositories creates the compared implementations.
+var benchmarkRepositories = []struct {
+	name string
+	new  func() repository.PortRepository
+}{
+	{name: "MemoryDB", new: func() repository.PortRepository { return NewMemoryDB() }},
+	{name: "ShardedMemoryDB", new: func() repository.PortRepository { return NewShardedMemoryDB(DefaultShardCount) }},
+}
+
+// runParallelBenchmark runs the operation of every implementation at every GOMAXPROCS setting.
+// Each call gets a distinct counter value, which the operation can turn into a port id.
+// The repository is filled with the benchmark ports first when prefill is set.
+func runParallelBenchmark(
+	b *testing.B,
+	prefill bool,
+	op func(ctx context.Context, repo repository.PortRepository, n uint64),
+) {
+	for _, procs := range benchmarkProcs {
+		for _, impl := range benchmarkRepositories {
+			procs, impl := procs, impl // Capture range variables
+			b.Run(fmt.Sprintf("%s/procs=%d", impl.name, procs), func(b *testing.B) {
+				defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+
+				ctx := context.Background()
+				repo := impl.new()
+				if prefill {
+					for _, port := range benchmarkPorts {
+						require.NoError(b, repo.Upsert(ctx, port))
+					}
+				}
+				counter := uint64(0)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						op(ctx, repo, atomic.AddUint64(&counter, 1))
+					}
+				})
+			})
+		}
+	}
+}
+
+// benchmarkPorts are prepared upfront so that building them is not measured.
+var benchmarkPorts = func() []*model.Port {
+	ports := make([]*model.Port, 10000)
+	for i := range ports {
+		ports[i] = repositorytest.NewPort(fmt.Sprintf("P%05d", i), "Country")
+	}
+
+	return ports
+}()
+
+// BenchmarkUpsert measures the write throughput, like a parallel import.
+func BenchmarkUpsert(b *testing.B) {
+	runParallelBenchmark(b, false, func(ctx context.Context, repo repository.PortRepository, n uint64) {
+		_ = repo.Upsert(ctx, benchmarkPorts[n%uint64(len(benchmarkPorts))])
+	})
+}
+
+// BenchmarkMixed measures a workload of nine reads for every write, like the HTTP traffic.
+func BenchmarkMixed(b *testing.B) {
+	runParallelBenchmark(b, true, func(ctx context.Context, repo repository.PortRepository, n uint64) {
+		port := benchmarkPorts[n%uint64(len(benchmarkPorts))]
+		if n%10 == 0 {
+			_ = repo.Upsert(ctx, port)
+			return
+		}
+		_, _ = repo.Get(ctx, port.ID)
+	})
+}
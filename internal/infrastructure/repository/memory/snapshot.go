@@ -0,0 +1,390 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+const (
+	// snapshotFormat identifies the snapshot files written by this package.
+	snapshotFormat = "port-service-snapshot"
+
+	// snapshotVersion is the version of the snapshot file layout.
+	// The version 2 adds the deleted ports, the files of the version 1 are still read.
+	snapshotVersion = 2
+
+	// snapshotPrefix and snapshotExt frame the name of the snapshot files.
+	// The creation time in between is zero padded, so the names sort chronologically.
+	snapshotPrefix = "ports-"
+	snapshotExt    = ".snapshot"
+)
+
+// snapshotHeader is the first line of a snapshot file.
+// The sequence is the one of the last change included in the snapshot,
+// the write-ahead log is replayed from the following one.
+type snapshotHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Sequence  uint64    `json:"sequence"`
+}
+
+// snapshotEntry is a port line of a snapshot file.
+// The deleted ports carry the time of their delete, so that their tombstone can be restored.
+type snapshotEntry struct {
+	*model.Port
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// snapshotTrailer is the last line of a snapshot file.
+// The checksum covers every byte that precedes the trailer.
+type snapshotTrailer struct {
+	Count    int    `json:"count"`
+	Checksum string `json:"checksum"`
+}
+
+// Snapshotter writes the content of a MemoryDB to snapshot files and restores it from them.
+//
+// A snapshot file is a JSON Lines document: a header, one line per port, including the deleted ones,
+// and a trailer holding the number of ports and the SHA-256 checksum of the preceding lines.
+// Files are written to a temporary file first and renamed once complete,
+// so a crash never leaves a partially written snapshot behind.
+type Snapshotter struct {
+	db   *MemoryDB
+	dir  string
+	keep int
+}
+
+// NewSnapshotter creates a new Snapshotter that stores the snapshots of the given
+// database in dir and keeps the newest keep snapshots. A keep below 1 is treated as 1.
+func NewSnapshotter(db *MemoryDB, dir string, keep int) *Snapshotter {
+	if keep < 1 {
+		keep = 1
+	}
+
+	return &Snapshotter{
+		db:   db,
+		dir:  dir,
+		keep: keep,
+	}
+}
+
+// Run saves a snapshot every interval until the context is canceled,
+// then saves a last one so that the shutdown does not lose any change.
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Save(ctx); err != nil {
+				log.Printf("Error saving snapshot: %v", err)
+			}
+		case <-ctx.Done():
+			// The context is already canceled, the last snapshot must not depend on it
+			path, err := s.Save(context.Background())
+			if err != nil {
+				log.Printf("Error saving snapshot on shutdown: %v", err)
+				return
+			}
+			log.Printf("Snapshot saved on shutdown: %s", path)
+			return
+		}
+	}
+}
+
+// Save writes a snapshot of the database and removes the snapshots beyond the retention.
+// If the database has a write-ahead log, the log is compacted by dropping the records
+// folded into the snapshot. It returns the path of the new snapshot.
+func (s *Snapshotter) Save(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return "", fmt.Errorf("os.MkdirAll: failed with: %w", err)
+	}
+
+	ports, tombstones, seq := s.db.snapshot()
+	now := time.Now().UTC()
+	path := filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", snapshotPrefix, now.UnixNano(), snapshotExt))
+	header := snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, CreatedAt: now, Sequence: seq}
+	if err := writeSnapshotFile(ctx, path, header, ports, tombstones); err != nil {
+		return "", err
+	}
+
+	// The snapshot is durable at this point, so the records it contains can go
+	if wal := s.db.attachedWAL(); wal != nil {
+		if err := wal.compact(seq); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.prune(); err != nil {
+		log.Printf("Error pruning snapshots: %v", err)
+	}
+
+	return path, nil
+}
+
+// LoadLatest restores the database from the newest valid snapshot and returns its path.
+// Snapshots failing the verification are skipped in favor of the older ones.
+// It returns an ErrSnapshotNotFound error if there is no valid snapshot.
+func (s *Snapshotter) LoadLatest(ctx context.Context) (string, error) {
+	paths, err := s.list()
+	if err != nil {
+		return "", err
+	}
+
+	// Newest first
+	for i := len(paths) - 1; i >= 0; i-- {
+		header, ports, tombstones, err := readSnapshotFile(ctx, paths[i])
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			log.Printf("Skipping snapshot %s: %v", paths[i], err)
+			continue
+		}
+
+		s.db.restore(ports, tombstones, header.Sequence, header.CreatedAt)
+		return paths[i], nil
+	}
+
+	return "", errs.ErrSnapshotNotFound
+}
+
+// list returns the paths of the snapshot files ordered from the oldest to the newest.
+func (s *Snapshotter) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: failed with: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		paths = append(paths, filepath.Join(s.dir, name))
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// prune removes the oldest snapshots beyond the retention.
+func (s *Snapshotter) prune() error {
+	paths, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for len(paths) > s.keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return fmt.Errorf("os.Remove: failed with: %w", err)
+		}
+		paths = paths[1:]
+	}
+
+	return nil
+}
+
+// writeSnapshotFile atomically writes the ports and the tombstones to a snapshot file at the given path.
+func writeSnapshotFile(
+	ctx context.Context,
+	path string,
+	header snapshotHeader,
+	ports []*model.Port,
+	tombstones []*repository.Tombstone,
+) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: failed with: %w", err)
+	}
+
+	// Remove the temporary file if anything goes wrong before the rename
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	buf := bufio.NewWriter(tmp)
+	checksum := sha256.New()
+	enc := json.NewEncoder(io.MultiWriter(buf, checksum))
+
+	if err = enc.Encode(header); err != nil {
+		return fmt.Errorf("json.Encode: failed with: %w", err)
+	}
+
+	for i, port := range ports {
+		// Check the context from time to time without slowing down the encoding
+		if i%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err = enc.Encode(port); err != nil {
+			return fmt.Errorf("json.Encode: failed with: %w", err)
+		}
+	}
+	for _, tombstone := range tombstones {
+		deletedAt := tombstone.DeletedAt
+		if err = enc.Encode(snapshotEntry{Port: tombstone.Port, DeletedAt: &deletedAt}); err != nil {
+			return fmt.Errorf("json.Encode: failed with: %w", err)
+		}
+	}
+
+	trailer := snapshotTrailer{Count: len(ports) + len(tombstones), Checksum: hex.EncodeToString(checksum.Sum(nil))}
+	if err = json.NewEncoder(buf).Encode(trailer); err != nil {
+		return fmt.Errorf("json.Encode: failed with: %w", err)
+	}
+
+	if err = buf.Flush(); err != nil {
+		return fmt.Errorf("bufio.Flush: failed with: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("file.Sync: failed with: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("file.Close: failed with: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename: failed with: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// readSnapshotFile reads and verifies the snapshot file at the given path.
+// It returns the live ports and the tombstones of the deleted ones.
+func readSnapshotFile(
+	ctx context.Context,
+	path string,
+) (snapshotHeader, []*model.Port, []*repository.Tombstone, error) {
+	var header snapshotHeader
+
+	file, err := os.Open(path)
+	if err != nil {
+		return header, nil, nil, fmt.Errorf("os.Open: failed with: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	checksum := sha256.New()
+
+	line, err := readSnapshotLine(reader, checksum)
+	if err == io.EOF {
+		return header, nil, nil, fmt.Errorf("%w: empty file %s", errs.ErrCorruptSnapshot, path)
+	}
+	if err != nil {
+		return header, nil, nil, err
+	}
+
+	if err := json.Unmarshal(line, &header); err != nil ||
+		header.Format != snapshotFormat || header.Version < 1 || header.Version > snapshotVersion {
+		return header, nil, nil, fmt.Errorf("%w: unknown header in %s", errs.ErrCorruptSnapshot, path)
+	}
+
+	// The trailer is only known once the end of the file is reached,
+	// so every line is held back until the next one has been read.
+	var ports []*model.Port
+	var tombstones []*repository.Tombstone
+	pending, err := readSnapshotLine(reader, nil)
+	if err == io.EOF {
+		return header, nil, nil, fmt.Errorf("%w: missing trailer in %s", errs.ErrCorruptSnapshot, path)
+	}
+	if err != nil {
+		return header, nil, nil, err
+	}
+
+	for {
+		if (len(ports)+len(tombstones))%1024 == 0 && ctx.Err() != nil {
+			return header, nil, nil, ctx.Err()
+		}
+
+		line, err := readSnapshotLine(reader, nil)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header, nil, nil, err
+		}
+
+		checksum.Write(pending)
+		var entry snapshotEntry
+		if err := json.Unmarshal(pending, &entry); err != nil || entry.Port == nil || entry.ID == "" {
+			return header, nil, nil, fmt.Errorf("%w: invalid port in %s", errs.ErrCorruptSnapshot, path)
+		}
+		if entry.DeletedAt != nil {
+			tombstones = append(tombstones, &repository.Tombstone{Port: entry.Port, DeletedAt: *entry.DeletedAt})
+		} else {
+			ports = append(ports, entry.Port)
+		}
+		pending = line
+	}
+
+	var trailer snapshotTrailer
+	if err := json.Unmarshal(pending, &trailer); err != nil {
+		return header, nil, nil, fmt.Errorf("%w: missing trailer in %s", errs.ErrCorruptSnapshot, path)
+	}
+	if trailer.Count != len(ports)+len(tombstones) || trailer.Checksum != hex.EncodeToString(checksum.Sum(nil)) {
+		return header, nil, nil, fmt.Errorf("%w: checksum mismatch in %s", errs.ErrCorruptSnapshot, path)
+	}
+
+	return header, ports, tombstones, nil
+}
+
+// readSnapshotLine reads a full line, including its line feed, and adds it to the checksum if one is given.
+// A line without a line feed can only come from a truncated file and is reported as corrupt.
+func readSnapshotLine(reader *bufio.Reader, checksum hash.Hash) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return nil, io.EOF
+	}
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: truncated line", errs.ErrCorruptSnapshot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bufio.ReadBytes: failed with: %w", err)
+	}
+
+	if checksum != nil {
+		checksum.Write(line)
+	}
+
+	return line, nil
+}
+
+// syncDir flushes the directory entry so that a rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("os.Open: failed with: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("dir.Sync: failed with: %w", err)
+	}
+
+	return nil
+}
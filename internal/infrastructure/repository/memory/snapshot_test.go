@@ -0,0 +1,162 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+func TestSnapshotter(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, dir string)
+	}{
+		{
+			name: "SaveAndLoad",
+			testFunc: func(t *testing.T, dir string) {
+				db := NewMemoryDB()
+				port := createPort()
+				require.NoError(t, db.Upsert(ctx, port))
+
+				_, err := NewSnapshotter(db, dir, 3).Save(ctx)
+				require.NoError(t, err)
+
+				restored := NewMemoryDB()
+				_, err = NewSnapshotter(restored, dir, 3).LoadLatest(ctx)
+				require.NoError(t, err)
+
+				retrievedPort, err := restored.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, port, retrievedPort)
+
+				// The indexes must be rebuilt as well
+				ports, err := restored.FindByCountry(ctx, "United Kingdom")
+				require.NoError(t, err)
+				assert.Len(t, ports, 1)
+			},
+		},
+		{
+			name: "TombstonesAreKept",
+			testFunc: func(t *testing.T, dir string) {
+				db := NewMemoryDB()
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				expected, err := db.GetTombstone(ctx, "GBLON")
+				require.NoError(t, err)
+
+				_, err = NewSnapshotter(db, dir, 3).Save(ctx)
+				require.NoError(t, err)
+
+				restored := NewMemoryDB()
+				_, err = NewSnapshotter(restored, dir, 3).LoadLatest(ctx)
+				require.NoError(t, err)
+				assert.Zero(t, restored.GetLength(ctx))
+
+				tombstone, err := restored.GetTombstone(ctx, "GBLON")
+				require.NoError(t, err)
+				require.NotNil(t, tombstone)
+				assert.Equal(t, expected.Port, tombstone.Port)
+				assert.True(t, expected.DeletedAt.Equal(tombstone.DeletedAt))
+
+				_, err = restored.Restore(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, 1, restored.GetLength(ctx))
+			},
+		},
+		{
+			name: "FirstVersionIsRead",
+			testFunc: func(t *testing.T, dir string) {
+				header := snapshotHeader{Format: snapshotFormat, Version: 1, Sequence: 1}
+				path := filepath.Join(dir, snapshotPrefix+"1"+snapshotExt)
+				require.NoError(t, writeSnapshotFile(ctx, path, header, []*model.Port{createPort()}, nil))
+
+				restored := NewMemoryDB()
+				_, err := NewSnapshotter(restored, dir, 3).LoadLatest(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, 1, restored.GetLength(ctx))
+			},
+		},
+		{
+			name: "NoSnapshot",
+			testFunc: func(t *testing.T, dir string) {
+				_, err := NewSnapshotter(NewMemoryDB(), dir, 3).LoadLatest(ctx)
+				assert.ErrorIs(t, err, errs.ErrSnapshotNotFound)
+			},
+		},
+		{
+			name: "CorruptSnapshotFallsBackToOlder",
+			testFunc: func(t *testing.T, dir string) {
+				db := NewMemoryDB()
+				snapshotter := NewSnapshotter(db, dir, 3)
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				older, err := snapshotter.Save(ctx)
+				require.NoError(t, err)
+
+				port := createPort()
+				port.ID = "FRPAR"
+				require.NoError(t, db.Upsert(ctx, port))
+				newer, err := snapshotter.Save(ctx)
+				require.NoError(t, err)
+
+				// Flip a byte in the newest snapshot
+				data, err := os.ReadFile(newer)
+				require.NoError(t, err)
+				data[len(data)/2] ^= 0xff
+				require.NoError(t, os.WriteFile(newer, data, 0o600))
+
+				restored := NewMemoryDB()
+				path, err := NewSnapshotter(restored, dir, 3).LoadLatest(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, older, path)
+				assert.Equal(t, 1, restored.GetLength(ctx))
+			},
+		},
+		{
+			name: "TruncatedSnapshotIsRejected",
+			testFunc: func(t *testing.T, dir string) {
+				db := NewMemoryDB()
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				path, err := NewSnapshotter(db, dir, 3).Save(ctx)
+				require.NoError(t, err)
+
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(path, data[:len(data)-10], 0o600))
+
+				_, _, _, err = readSnapshotFile(ctx, path)
+				assert.ErrorIs(t, err, errs.ErrCorruptSnapshot)
+			},
+		},
+		{
+			name: "Retention",
+			testFunc: func(t *testing.T, dir string) {
+				snapshotter := NewSnapshotter(NewMemoryDB(), dir, 2)
+				for i := 0; i < 4; i++ {
+					_, err := snapshotter.Save(ctx)
+					require.NoError(t, err)
+				}
+
+				paths, err := filepath.Glob(filepath.Join(dir, "*"))
+				require.NoError(t, err)
+				assert.Len(t, paths, 2)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t, t.TempDir())
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// GetTombstone returns the tombstone of the deleted port, or nil if there is no such tombstone.
+func (db *MemoryDB) GetTombstone(ctx context.Context, id string) (*repository.Tombstone, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		return db.tombstones[id], nil
+	}
+}
+
+// Restore brings the deleted port back from its tombstone and returns its new version.
+// It returns ErrPortNotFound if there is no such tombstone.
+func (db *MemoryDB) Restore(ctx context.Context, id string) (uint64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	tombstone, ok := db.tombstones[id]
+	if !ok {
+		return 0, errs.ErrPortNotFound
+	}
+
+	// The record holds the port, so that the replay does not depend on the tombstone
+	record := newWALRecord(ctx, walOpRestore, id, tombstone.Port)
+	if err := db.log(record); err != nil {
+		return 0, err
+	}
+	db.applyUpsert(tombstone.Port, db.seq)
+	db.remember(record)
+
+	return db.seq, nil
+}
+
+// PurgeTombstones removes for good the tombstones of the ports deleted before the given time,
+// together with the history of the ports. It returns the number of removed tombstones.
+func (db *MemoryDB) PurgeTombstones(ctx context.Context, before time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	purged := 0
+	for _, tombstone := range db.sortedTombstones() {
+		if !tombstone.DeletedAt.Before(before) {
+			continue
+		}
+
+		// Every purge is logged on its own, the ones logged before a failure stay applied
+		if err := db.log(newWALRecord(ctx, walOpPurge, tombstone.Port.ID, nil)); err != nil {
+			return purged, err
+		}
+		db.applyPurge(tombstone.Port.ID, db.seq)
+		purged++
+	}
+
+	return purged, nil
+}
+
+// listTombstones returns a page of the deleted ports ordered by their id, starting after the given id.
+func (db *MemoryDB) listTombstones(ctx context.Context, after string, limit int) (*repository.ListResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// The tombstones are expected to be few, so they are sorted on every call
+	tombstones := db.sortedTombstones()
+	start := sort.Search(len(tombstones), func(i int) bool { return tombstones[i].Port.ID > after })
+
+	end := start + limit
+	if end > len(tombstones) {
+		end = len(tombstones)
+	}
+
+	result := &repository.ListResult{
+		Ports: make([]*model.Port, 0, end-start),
+	}
+	for _, tombstone := range tombstones[start:end] {
+		result.Ports = append(result.Ports, tombstone.Port)
+	}
+
+	if end < len(tombstones) {
+		result.NextCursor = repository.EncodeCursor(tombstones[end-1].Port.ID)
+	}
+
+	return result, nil
+}
+
+// sortedTombstones returns the tombstones ordered by the id of their port.
+// The caller must hold the lock.
+func (db *MemoryDB) sortedTombstones() []*repository.Tombstone {
+	tombstones := make([]*repository.Tombstone, 0, len(db.tombstones))
+	for _, tombstone := range db.tombstones {
+		tombstones = append(tombstones, tombstone)
+	}
+	sort.Slice(tombstones, func(i, j int) bool { return tombstones[i].Port.ID < tombstones[j].Port.ID })
+
+	return tombstones
+}
+
+// applyPurge removes the tombstone and the history of the port and notifies the watchers.
+// The history no longer tells whether the port existed before its delete, so it only holds
+// every change from then on. The version is the sequence number of the purge.
+// The caller must hold the write lock.
+func (db *MemoryDB) applyPurge(id string, version uint64) {
+	tombstone, ok := db.tombstones[id]
+	if !ok {
+		return
+	}
+
+	if tombstone.DeletedAt.After(db.historySince) {
+		db.historySince = tombstone.DeletedAt
+	}
+	delete(db.tombstones, id)
+	delete(db.history, id)
+	db.notify(repository.Change{Type: repository.ChangePurged, ID: id, Version: version, Old: tombstone.Port})
+}
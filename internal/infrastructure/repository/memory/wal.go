@@ -0,0 +1,388 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+const (
+	// walOpUpsert, walOpDelete and the following ones are the operations recorded in the write-ahead log.
+	walOpUpsert     = "upsert"
+	walOpUpsertMany = "upsert_many"
+	walOpDelete     = "delete"
+	walOpRestore    = "restore"
+	walOpPurge      = "purge"
+
+	// walFrameHeaderSize is the size of the length and checksum prefix of every record.
+	walFrameHeaderSize = 8
+
+	// walMaxRecordSize guards against allocating a huge buffer for a garbage length.
+	walMaxRecordSize = 16 << 20 // 16 MB
+)
+
+// walChecksumTable is the CRC-32C table used to checksum the records.
+var walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is a single change recorded in the write-ahead log.
+type walRecord struct {
+	Seq  uint64      `json:"seq"`
+	Op   string      `json:"op"`
+	ID   string      `json:"id"`
+	Port *model.Port `json:"port,omitempty"`
+
+	// Ports holds the ports of an upsert_many change, which are applied together.
+	Ports []*model.Port `json:"ports,omitempty"`
+
+	// Time and Source describe the change in the history of the port.
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+}
+
+// newWALRecord creates the record of a change made now with the given context.
+func newWALRecord(ctx context.Context, op, id string, port *model.Port) walRecord {
+	return walRecord{
+		Op:     op,
+		ID:     id,
+		Port:   port,
+		Time:   time.Now().UTC(),
+		Source: repository.SourceFromContext(ctx),
+	}
+}
+
+// WAL is an append-only write-ahead log of the changes applied to a MemoryDB.
+//
+// Every record is framed as a big-endian uint32 length, a big-endian uint32 CRC-32C
+// of the payload and the JSON payload itself. A record that is cut short or fails the
+// checksum can only come from an interrupted write, so the log is truncated right
+// before it when it is replayed.
+type WAL struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	syncWrites bool
+
+	// syncFile flushes the file to the disk, it is replaced by the tests to inject failures.
+	syncFile func(*os.File) error
+
+	// failed holds the error that left the log with a record it could not roll back,
+	// every following append is rejected with it.
+	failed error
+}
+
+// OpenWAL opens the write-ahead log at the given path, creating it if needed.
+// When syncWrites is set, every append is flushed to the disk before the change is applied,
+// otherwise the flush is left to the operating system, which is faster but can lose
+// the latest changes on a power loss.
+func OpenWAL(path string, syncWrites bool) (*WAL, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: failed with: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("os.OpenFile: failed with: %w", err)
+	}
+
+	return &WAL{
+		path:       path,
+		file:       file,
+		syncWrites: syncWrites,
+		syncFile:   (*os.File).Sync,
+	}, nil
+}
+
+// Close flushes and closes the write-ahead log.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("file.Sync: failed with: %w", err)
+	}
+
+	return w.file.Close()
+}
+
+// AttachWAL replays the changes of the write-ahead log that are newer than the content
+// of the database, then records every following change in it. It is meant to be called
+// once at startup, after the snapshot has been restored. It returns the number of replayed changes.
+func (db *MemoryDB) AttachWAL(wal *WAL) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	replayed := 0
+	err := wal.replay(func(record walRecord) error {
+		// Already folded into the restored snapshot
+		if record.Seq <= db.seq {
+			return nil
+		}
+		if record.Seq != db.seq+1 {
+			return fmt.Errorf("%w: expected change %d, found %d", errs.ErrLogGap, db.seq+1, record.Seq)
+		}
+
+		switch record.Op {
+		case walOpUpsert:
+			if record.Port == nil {
+				return fmt.Errorf("change %d has no port", record.Seq)
+			}
+			db.applyUpsert(record.Port, record.Seq)
+		case walOpUpsertMany:
+			for _, port := range record.Ports {
+				if port == nil {
+					return fmt.Errorf("change %d has a nil port", record.Seq)
+				}
+			}
+			db.applyUpsertMany(record.Ports, record.Seq)
+		case walOpDelete:
+			db.applyDelete(record.ID, record.Seq, record.Time)
+		case walOpRestore:
+			if record.Port == nil {
+				return fmt.Errorf("change %d has no port", record.Seq)
+			}
+			db.applyUpsert(record.Port, record.Seq)
+		case walOpPurge:
+			db.applyPurge(record.ID, record.Seq)
+		default:
+			return fmt.Errorf("unknown operation %q in change %d", record.Op, record.Seq)
+		}
+		db.remember(record)
+		db.seq = record.Seq
+		replayed++
+
+		return nil
+	})
+	if err != nil {
+		return replayed, err
+	}
+
+	db.wal = wal
+
+	return replayed, nil
+}
+
+// attachedWAL returns the write-ahead log of the database, if any.
+func (db *MemoryDB) attachedWAL() *WAL {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.wal
+}
+
+// append writes the record at the end of the log. If the write or the flush fails, the record is
+// cut off the log again, so that a change reported as failed is never replayed and its sequence
+// number can be reused. If even that fails, the log rejects every following append.
+func (w *WAL) append(record walRecord) error {
+	frame, err := encodeWALRecord(record)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed != nil {
+		return w.failed
+	}
+
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("file.Seek: failed with: %w", err)
+	}
+
+	if _, err := w.file.Write(frame); err != nil {
+		return w.rollback(offset, fmt.Errorf("file.Write: failed with: %w", err))
+	}
+	if w.syncWrites {
+		if err := w.syncFile(w.file); err != nil {
+			return w.rollback(offset, fmt.Errorf("file.Sync: failed with: %w", err))
+		}
+	}
+
+	return nil
+}
+
+// rollback cuts the log at the offset the failed append started at, and returns the append error.
+// The caller must hold the lock.
+func (w *WAL) rollback(offset int64, appendErr error) error {
+	if err := w.file.Truncate(offset); err != nil {
+		w.failed = fmt.Errorf("write-ahead log %s is unusable after %v: file.Truncate: failed with: %w",
+			w.path, appendErr, err)
+		return w.failed
+	}
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		w.failed = fmt.Errorf("write-ahead log %s is unusable after %v: file.Seek: failed with: %w",
+			w.path, appendErr, err)
+		return w.failed
+	}
+
+	return appendErr
+}
+
+// replay calls fn for every record of the log in order and leaves the file positioned
+// at its end. A torn or corrupt record ends the log: it is cut off together with
+// everything after it instead of failing the replay.
+func (w *WAL) replay(fn func(walRecord) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("file.Seek: failed with: %w", err)
+	}
+
+	reader := bufio.NewReader(w.file)
+	var offset int64
+	for {
+		record, size, err := readWALRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Truncating write-ahead log %s at offset %d: %v", w.path, offset, err)
+			if err := w.file.Truncate(offset); err != nil {
+				return fmt.Errorf("file.Truncate: failed with: %w", err)
+			}
+			break
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	if _, err := w.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("file.Seek: failed with: %w", err)
+	}
+
+	return nil
+}
+
+// compact drops the records up to the given sequence number, which are folded into a snapshot.
+// The remaining records are copied to a new file that atomically replaces the log.
+func (w *WAL) compact(seq uint64) (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err = w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("file.Seek: failed with: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: failed with: %w", err)
+	}
+
+	// Remove the temporary file and restore the append position if anything goes wrong before the rename
+	renamed := false
+	defer func() {
+		if err != nil && !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			w.file.Seek(0, io.SeekEnd)
+		}
+	}()
+
+	reader := bufio.NewReader(w.file)
+	writer := bufio.NewWriter(tmp)
+	for {
+		record, _, readErr := readWALRecord(reader)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("readWALRecord: failed with: %w", readErr)
+		}
+		if record.Seq <= seq {
+			continue
+		}
+
+		frame, encodeErr := encodeWALRecord(record)
+		if encodeErr != nil {
+			return encodeErr
+		}
+		if _, err = writer.Write(frame); err != nil {
+			return fmt.Errorf("bufio.Write: failed with: %w", err)
+		}
+	}
+
+	if err = writer.Flush(); err != nil {
+		return fmt.Errorf("bufio.Flush: failed with: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("file.Sync: failed with: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("os.Rename: failed with: %w", err)
+	}
+	renamed = true
+
+	// The temporary file is the log from now on
+	w.file.Close()
+	w.file = tmp
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("file.Seek: failed with: %w", err)
+	}
+
+	return syncDir(filepath.Dir(w.path))
+}
+
+// encodeWALRecord frames the record with its length and checksum.
+func encodeWALRecord(record walRecord) ([]byte, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: failed with: %w", err)
+	}
+
+	frame := make([]byte, walFrameHeaderSize, walFrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(payload, walChecksumTable))
+
+	return append(frame, payload...), nil
+}
+
+// readWALRecord reads the next record and returns it with its size in the log.
+// It returns io.EOF only at a clean record boundary.
+func readWALRecord(reader *bufio.Reader) (walRecord, int64, error) {
+	var record walRecord
+
+	header := make([]byte, walFrameHeaderSize)
+	if n, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return record, 0, io.EOF
+		}
+		return record, 0, errors.New("torn record header")
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size == 0 || size > walMaxRecordSize {
+		return record, 0, fmt.Errorf("invalid record size %d", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return record, 0, errors.New("torn record payload")
+	}
+	if crc32.Checksum(payload, walChecksumTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return record, 0, errors.New("record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return record, 0, fmt.Errorf("json.Unmarshal: failed with: %w", err)
+	}
+
+	return record, int64(walFrameHeaderSize) + int64(size), nil
+}
@@ -0,0 +1,252 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// openTestDB creates a database that records its changes in the write-ahead log at the given path.
+func openTestDB(t *testing.T, path string) (*MemoryDB, *WAL) {
+	t.Helper()
+
+	wal, err := OpenWAL(path, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { wal.Close() })
+
+	db := NewMemoryDB()
+	_, err = db.AttachWAL(wal)
+	require.NoError(t, err)
+
+	return db, wal
+}
+
+func TestWAL(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, dir string)
+	}{
+		{
+			name: "Replay",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+
+				paris := createPort()
+				paris.ID = "FRPAR"
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				require.NoError(t, db.Upsert(ctx, paris))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				require.NoError(t, wal.Close())
+
+				replayedDB, _ := openTestDB(t, path)
+				assert.Equal(t, 1, replayedDB.GetLength(ctx))
+				retrievedPort, err := replayedDB.Get(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Equal(t, paris, retrievedPort)
+			},
+		},
+		{
+			name: "ReplayBatch",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+
+				paris := createPort()
+				paris.ID = "FRPAR"
+				require.NoError(t, db.UpsertMany(ctx, []*model.Port{createPort(), paris}))
+				_, version, err := db.GetVersioned(ctx, "FRPAR")
+				require.NoError(t, err)
+				require.NoError(t, wal.Close())
+
+				// The batch is a single record, both ports come back with its version
+				replayedDB, _ := openTestDB(t, path)
+				assert.Equal(t, 2, replayedDB.GetLength(ctx))
+				retrievedPort, replayedVersion, err := replayedDB.GetVersioned(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Equal(t, paris, retrievedPort)
+				assert.Equal(t, version, replayedVersion)
+
+				_, londonVersion, err := replayedDB.GetVersioned(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, version, londonVersion)
+			},
+		},
+		{
+			name: "ReplayTombstones",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+
+				paris := createPort()
+				paris.ID = "FRPAR"
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				require.NoError(t, db.Upsert(ctx, paris))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				require.NoError(t, db.Delete(ctx, "FRPAR"))
+				deleted, err := db.GetTombstone(ctx, "FRPAR")
+				require.NoError(t, err)
+				_, err = db.Restore(ctx, "GBLON")
+				require.NoError(t, err)
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				purged, err := db.PurgeTombstones(ctx, deleted.DeletedAt.Add(time.Nanosecond))
+				require.NoError(t, err)
+				require.Equal(t, 1, purged)
+				require.NoError(t, wal.Close())
+
+				// The delete time of the remaining tombstone is replayed as well
+				replayedDB, _ := openTestDB(t, path)
+				assert.Zero(t, replayedDB.GetLength(ctx))
+				tombstone, err := replayedDB.GetTombstone(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Nil(t, tombstone)
+				tombstone, err = replayedDB.GetTombstone(ctx, "GBLON")
+				require.NoError(t, err)
+				require.NotNil(t, tombstone)
+				assert.True(t, tombstone.DeletedAt.After(deleted.DeletedAt))
+			},
+		},
+		{
+			name: "TornLastRecordIsCutOff",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				require.NoError(t, wal.Close())
+
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+
+				// Simulate a crash in the middle of the second append
+				file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+				require.NoError(t, err)
+				_, err = file.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, '{', '"'})
+				require.NoError(t, err)
+				require.NoError(t, file.Close())
+
+				replayedDB, replayedWAL := openTestDB(t, path)
+				assert.Equal(t, 1, replayedDB.GetLength(ctx))
+
+				truncated, err := os.Stat(path)
+				require.NoError(t, err)
+				assert.Equal(t, info.Size(), truncated.Size())
+
+				// The log keeps working after the cut
+				paris := createPort()
+				paris.ID = "FRPAR"
+				require.NoError(t, replayedDB.Upsert(ctx, paris))
+				require.NoError(t, replayedWAL.Close())
+
+				reopenedDB, _ := openTestDB(t, path)
+				assert.Equal(t, 2, reopenedDB.GetLength(ctx))
+			},
+		},
+		{
+			name: "FailedAppendIsRolledBack",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+				require.NoError(t, db.Upsert(ctx, createPort()))
+
+				// The record of the failed change reaches the file before the flush fails
+				wal.syncFile = func(*os.File) error { return os.ErrClosed }
+				paris := createPort()
+				paris.ID = "FRPAR"
+				assert.ErrorIs(t, db.Upsert(ctx, paris), os.ErrClosed)
+				wal.syncFile = (*os.File).Sync
+
+				// The next change takes the sequence number of the failed one
+				rome := createPort()
+				rome.ID = "ITROM"
+				require.NoError(t, db.Upsert(ctx, rome))
+				_, version, err := db.GetVersioned(ctx, "ITROM")
+				require.NoError(t, err)
+				require.NoError(t, wal.Close())
+
+				replayedDB, _ := openTestDB(t, path)
+				assert.Equal(t, 2, replayedDB.GetLength(ctx))
+				retrievedPort, err := replayedDB.Get(ctx, "FRPAR")
+				require.NoError(t, err)
+				assert.Nil(t, retrievedPort)
+				retrievedPort, replayedVersion, err := replayedDB.GetVersioned(ctx, "ITROM")
+				require.NoError(t, err)
+				assert.Equal(t, rome, retrievedPort)
+				assert.Equal(t, version, replayedVersion)
+			},
+		},
+		{
+			name: "CompactionFoldsTheLogIntoASnapshot",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+				snapshotter := NewSnapshotter(db, dir, 3)
+
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				_, err := snapshotter.Save(ctx)
+				require.NoError(t, err)
+
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				assert.Zero(t, info.Size())
+
+				paris := createPort()
+				paris.ID = "FRPAR"
+				require.NoError(t, db.Upsert(ctx, paris))
+				require.NoError(t, wal.Close())
+
+				// Restore the snapshot first, then the changes made after it
+				restored := NewMemoryDB()
+				_, err = NewSnapshotter(restored, dir, 3).LoadLatest(ctx)
+				require.NoError(t, err)
+
+				reopenedWAL, err := OpenWAL(path, true)
+				require.NoError(t, err)
+				defer reopenedWAL.Close()
+
+				replayed, err := restored.AttachWAL(reopenedWAL)
+				require.NoError(t, err)
+				assert.Equal(t, 1, replayed)
+				assert.Equal(t, 2, restored.GetLength(ctx))
+			},
+		},
+		{
+			name: "MissingSnapshotIsDetected",
+			testFunc: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "ports.wal")
+				db, wal := openTestDB(t, path)
+
+				require.NoError(t, db.Upsert(ctx, createPort()))
+				_, err := NewSnapshotter(db, filepath.Join(dir, "snapshots"), 3).Save(ctx)
+				require.NoError(t, err)
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				require.NoError(t, wal.Close())
+
+				// The log starts after the snapshot, replaying it alone would lose the port
+				reopenedWAL, err := OpenWAL(path, true)
+				require.NoError(t, err)
+				defer reopenedWAL.Close()
+
+				_, err = NewMemoryDB().AttachWAL(reopenedWAL)
+				assert.ErrorIs(t, err, errs.ErrLogGap)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t, t.TempDir())
+		})
+	}
+}
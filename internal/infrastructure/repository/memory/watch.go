@@ -0,0 +1,128 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+)
+
+// DefaultWatchBuffer is the number of changes buffered for every watcher by default.
+const DefaultWatchBuffer = 64
+
+// watcher is a registered Watch call.
+type watcher struct {
+	filter repository.WatchFilter
+
+	// changes has room for one more change than the buffer, which is kept for the resync.
+	changes chan repository.Change
+
+	// done is closed once the watcher is removed.
+	done chan struct{}
+}
+
+// SetWatchBuffer sets the number of changes buffered for the watchers started afterwards.
+// A size of zero or less falls back to DefaultWatchBuffer.
+func (db *MemoryDB) SetWatchBuffer(size int) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	if size <= 0 {
+		size = DefaultWatchBuffer
+	}
+	db.watchBuffer = size
+}
+
+// Watch returns a channel receiving the changes matching the filter, made after the call.
+// A watcher whose buffer is full receives a ChangeResync and its channel is closed.
+func (db *MemoryDB) Watch(ctx context.Context, filter repository.WatchFilter) (<-chan repository.Change, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	db.watchMu.Lock()
+	w := &watcher{
+		filter:  filter,
+		changes: make(chan repository.Change, db.watchBuffer+1),
+		done:    make(chan struct{}),
+	}
+	db.watchers[w] = struct{}{}
+	db.watchMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.watchMu.Lock()
+			db.unwatch(w)
+			db.watchMu.Unlock()
+		case <-w.done:
+		}
+	}()
+
+	return w.changes, nil
+}
+
+// publish sends the change of the port from old to port, nil when absent, to the watchers selecting it.
+// The caller must hold the write lock, so that the watchers get the changes in the order they were applied.
+func (db *MemoryDB) publish(old, port *model.Port, version uint64) {
+	change := repository.Change{Version: version, Old: old, New: port}
+	switch {
+	case old == nil:
+		change.Type = repository.ChangeCreated
+		change.ID = port.ID
+	case port == nil:
+		change.Type = repository.ChangeDeleted
+		change.ID = old.ID
+	default:
+		change.Type = repository.ChangeUpdated
+		change.ID = port.ID
+	}
+	db.notify(change)
+}
+
+// notify sends the change to the watchers selecting it.
+// The caller must hold the write lock, so that the watchers get the changes in the order they were applied.
+func (db *MemoryDB) notify(change repository.Change) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	for w := range db.watchers {
+		if !w.filter.Match(change) {
+			continue
+		}
+
+		// The last free slot is kept for the resync of a watcher falling behind
+		if len(w.changes) == cap(w.changes)-1 {
+			w.changes <- repository.Change{Type: repository.ChangeResync}
+			db.unwatch(w)
+			continue
+		}
+		w.changes <- change
+	}
+}
+
+// resync sends a ChangeResync to every watcher and removes them.
+// The caller must hold the write lock.
+func (db *MemoryDB) resync() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+
+	for w := range db.watchers {
+		w.changes <- repository.Change{Type: repository.ChangeResync}
+		db.unwatch(w)
+	}
+}
+
+// unwatch removes the watcher and closes its channel, unless it was already removed.
+// The caller must hold the watch lock.
+func (db *MemoryDB) unwatch(w *watcher) {
+	if _, ok := db.watchers[w]; !ok {
+		return
+	}
+
+	delete(db.watchers, w)
+	close(w.changes)
+	close(w.done)
+}
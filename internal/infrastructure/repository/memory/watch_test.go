@@ -0,0 +1,178 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+)
+
+// receive returns the next change of the channel, failing the test if none arrives in time.
+func receive(t *testing.T, changes <-chan repository.Change) repository.Change {
+	t.Helper()
+
+	select {
+	case change, ok := <-changes:
+		require.True(t, ok, "channel closed")
+		return change
+	case <-time.After(time.Second):
+		require.FailNow(t, "no change received")
+		return repository.Change{}
+	}
+}
+
+// requireClosed fails the test if the channel is not closed in time.
+func requireClosed(t *testing.T, changes <-chan repository.Change) {
+	t.Helper()
+
+	select {
+	case change, ok := <-changes:
+		require.False(t, ok, "unexpected change %v", change.Type)
+	case <-time.After(time.Second):
+		require.FailNow(t, "channel not closed")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, db *MemoryDB)
+	}{
+		{
+			name: "ChangesCarryTheOldAndNewPort",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				ctx := context.Background()
+				changes, err := db.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				created := repositorytest.NewPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(ctx, created))
+				updated := repositorytest.NewPort("GBLON", "France")
+				require.NoError(t, db.Upsert(ctx, updated))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+
+				change := receive(t, changes)
+				assert.Equal(t, repository.ChangeCreated, change.Type)
+				assert.Equal(t, "GBLON", change.ID)
+				assert.Nil(t, change.Old)
+				assert.Equal(t, created, change.New)
+				assert.Equal(t, uint64(1), change.Version)
+
+				change = receive(t, changes)
+				assert.Equal(t, repository.ChangeUpdated, change.Type)
+				assert.Equal(t, created, change.Old)
+				assert.Equal(t, updated, change.New)
+				assert.Equal(t, uint64(2), change.Version)
+
+				change = receive(t, changes)
+				assert.Equal(t, repository.ChangeDeleted, change.Type)
+				assert.Equal(t, "GBLON", change.ID)
+				assert.Equal(t, updated, change.Old)
+				assert.Nil(t, change.New)
+				assert.Equal(t, uint64(3), change.Version)
+			},
+		},
+		{
+			name: "Filter",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				ctx := context.Background()
+				changes, err := db.Watch(ctx, repository.WatchFilter{
+					IDs:   []string{"FRPAR"},
+					Types: []repository.ChangeType{repository.ChangeDeleted},
+				})
+				require.NoError(t, err)
+
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("FRPAR", "France")))
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				require.NoError(t, db.Delete(ctx, "FRPAR"))
+
+				change := receive(t, changes)
+				assert.Equal(t, repository.ChangeDeleted, change.Type)
+				assert.Equal(t, "FRPAR", change.ID)
+				assert.Empty(t, changes)
+			},
+		},
+		{
+			name: "BatchSendsAChangePerPort",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				ctx := context.Background()
+				changes, err := db.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				require.NoError(t, db.UpsertMany(ctx, []*model.Port{
+					repositorytest.NewPort("GBLON", "United Kingdom"),
+					repositorytest.NewPort("GBLON", "France"),
+				}))
+
+				assert.Equal(t, repository.ChangeCreated, receive(t, changes).Type)
+				change := receive(t, changes)
+				assert.Equal(t, repository.ChangeUpdated, change.Type)
+				assert.Equal(t, "France", change.New.Country)
+			},
+		},
+		{
+			name: "SlowWatcherIsSentAResync",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				ctx := context.Background()
+				db.SetWatchBuffer(2)
+				changes, err := db.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				// The writes must not wait for the watcher reading nothing
+				for i := 0; i < 5; i++ {
+					require.NoError(t, db.Upsert(ctx, repositorytest.NewPort("GBLON", "United Kingdom")))
+				}
+
+				assert.Equal(t, repository.ChangeCreated, receive(t, changes).Type)
+				assert.Equal(t, repository.ChangeUpdated, receive(t, changes).Type)
+				assert.Equal(t, repository.ChangeResync, receive(t, changes).Type)
+				requireClosed(t, changes)
+			},
+		},
+		{
+			name: "CanceledWatchIsClosed",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				ctx, cancel := context.WithCancel(context.Background())
+				changes, err := db.Watch(ctx, repository.WatchFilter{})
+				require.NoError(t, err)
+
+				cancel()
+				requireClosed(t, changes)
+
+				// The writes keep working without the watcher
+				require.NoError(t, db.Upsert(context.Background(), repositorytest.NewPort("GBLON", "United Kingdom")))
+
+				_, err = db.Watch(ctx, repository.WatchFilter{})
+				assert.ErrorIs(t, err, context.Canceled)
+			},
+		},
+		{
+			name: "RestoreSendsAResync",
+			testFunc: func(t *testing.T, db *MemoryDB) {
+				changes, err := db.Watch(context.Background(), repository.WatchFilter{IDs: []string{"GBLON"}})
+				require.NoError(t, err)
+
+				db.restore([]*model.Port{repositorytest.NewPort("FRPAR", "France")}, nil, 10, time.Now())
+
+				change := receive(t, changes)
+				assert.Equal(t, repository.ChangeResync, change.Type)
+				requireClosed(t, changes)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.testFunc(t, NewMemoryDB())
+		})
+	}
+}
@@ -0,0 +1,38 @@
+-- The ports and their list fields, one row per list item.
+-- The position keeps the order of the items as they were imported.
+CREATE TABLE ports (
+    id       TEXT PRIMARY KEY,
+    name     TEXT NOT NULL,
+    city     TEXT NOT NULL,
+    province TEXT NOT NULL,
+    country  TEXT NOT NULL,
+    timezone TEXT NOT NULL,
+    code     TEXT NOT NULL
+);
+
+CREATE TABLE port_aliases (
+    port_id  TEXT    NOT NULL REFERENCES ports (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    alias    TEXT    NOT NULL,
+    PRIMARY KEY (port_id, position)
+);
+
+CREATE TABLE port_regions (
+    port_id  TEXT    NOT NULL REFERENCES ports (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    region   TEXT    NOT NULL,
+    PRIMARY KEY (port_id, position)
+);
+
+CREATE TABLE port_unlocs (
+    port_id  TEXT    NOT NULL REFERENCES ports (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    unloc    TEXT    NOT NULL,
+    PRIMARY KEY (port_id, position)
+);
+
+CREATE TABLE port_coordinates (
+    port_id   TEXT PRIMARY KEY REFERENCES ports (id) ON DELETE CASCADE,
+    longitude REAL NOT NULL,
+    latitude  REAL NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Indexes for the lookups by field. The id is part of every index,
+-- so the matching ports come back already ordered.
+CREATE INDEX ports_country_idx ON ports (country, id);
+CREATE INDEX ports_code_idx ON ports (code, id);
+CREATE INDEX ports_timezone_idx ON ports (timezone, id);
+CREATE INDEX port_unlocs_unloc_idx ON port_unlocs (unloc, port_id);
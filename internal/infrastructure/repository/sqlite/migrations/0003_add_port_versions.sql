@@ -0,0 +1,12 @@
+-- The version of every port, taken from a sequence which only grows,
+-- so a port deleted and created again never gets back an old version.
+CREATE TABLE sequences (
+    name  TEXT PRIMARY KEY,
+    value INTEGER NOT NULL
+);
+
+ALTER TABLE ports ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+
+-- The existing ports get their row id as their first version
+UPDATE ports SET version = rowid;
+INSERT INTO sequences (name, value) SELECT 'port_version', COALESCE(MAX(version), 0) FROM ports;
@@ -0,0 +1,5 @@
+-- The deleted ports stay in the table as tombstones until they are purged.
+-- The time of the delete is stored in Unix nanoseconds, it is NULL for the live ports.
+ALTER TABLE ports ADD COLUMN deleted_at INTEGER;
+
+CREATE INDEX ports_deleted_at_idx ON ports (deleted_at);
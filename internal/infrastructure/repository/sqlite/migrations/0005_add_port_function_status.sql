@@ -0,0 +1,3 @@
+-- The UN/LOCODE function classifier and status of the ports, empty for the ports which have none.
+ALTER TABLE ports ADD COLUMN function TEXT NOT NULL DEFAULT '';
+ALTER TABLE ports ADD COLUMN status TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,540 @@
+// Package sqlite contains a port repository stored in a SQLite database.
+// It uses a pure Go driver, so it builds without cgo.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	// Registers the pure Go "sqlite" driver
+	_ "modernc.org/sqlite"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+// selectPorts reads the ports with their list fields in a single statement,
+// so every port is read from a consistent state of the database.
+// The list fields come back as JSON arrays ordered by their position.
+// The statements built on it select either the live ports or the deleted ones by their deleted_at column.
+const selectPorts = `
+SELECT p.id, p.name, p.city, p.province, p.country, p.timezone, p.code, p.function, p.status,
+	(SELECT json_group_array(alias) FROM
+		(SELECT alias FROM port_aliases WHERE port_id = p.id ORDER BY position)),
+	(SELECT json_group_array(region) FROM
+		(SELECT region FROM port_regions WHERE port_id = p.id ORDER BY position)),
+	(SELECT json_group_array(unloc) FROM
+		(SELECT unloc FROM port_unlocs WHERE port_id = p.id ORDER BY position)),
+	c.longitude, c.latitude, p.version, p.deleted_at
+FROM ports p
+LEFT JOIN port_coordinates c ON c.port_id = p.id`
+
+// deletePort marks a live port as deleted at the given time in Unix nanoseconds.
+const deletePort = `UPDATE ports SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`
+
+// SQLiteDB represents a port repository stored in a SQLite database.
+//
+// The list fields of the ports are stored in their own tables, one row per item,
+// so they can be queried with plain SQL. They are read back as empty lists when
+// a port has no items. Coordinates are stored as a longitude and latitude pair.
+// The deleted ports stay in the ports table with the time of their delete until they are purged.
+type SQLiteDB struct {
+	db *sql.DB
+}
+
+// Ensure that SQLiteDB implements the PortRepository interface.
+var _ repository.PortRepository = (*SQLiteDB)(nil)
+
+// NewSQLiteDB opens the SQLite database at the given path, creating it if needed,
+// and applies the pending schema migrations.
+func NewSQLiteDB(ctx context.Context, path string) (*SQLiteDB, error) {
+	// Every connection enforces the foreign keys and waits for the locks instead of failing,
+	// and the transactions take the write lock upfront to avoid upgrade deadlocks.
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"+
+		"&_pragma=journal_mode(WAL)&_txlock=immediate", path)
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open: failed with: %w", err)
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteDB{db: db}, nil
+}
+
+// Close closes the database.
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// Upsert inserts or updates a port together with its list fields.
+// It returns an ErrInvalidInput error if the coordinates are neither empty nor a pair.
+func (s *SQLiteDB) Upsert(ctx context.Context, port *model.Port) error {
+	_, err := s.upsert(ctx, port, nil)
+	return err
+}
+
+// UpsertIfVersion inserts or updates a port if its current version is the given one.
+// It returns the new version, or ErrVersionMismatch if the port has another version.
+func (s *SQLiteDB) UpsertIfVersion(ctx context.Context, port *model.Port, version uint64) (uint64, error) {
+	return s.upsert(ctx, port, &version)
+}
+
+// upsert writes the port in its own transaction, if its current version is the expected one when given,
+// and returns its new version.
+func (s *SQLiteDB) upsert(ctx context.Context, port *model.Port, expected *uint64) (uint64, error) {
+	if err := validateCoordinates(port); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sql.BeginTx: failed with: %w", err)
+	}
+	defer tx.Rollback()
+
+	if expected != nil {
+		current, err := currentVersion(ctx, tx, port.ID)
+		if err != nil {
+			return 0, err
+		}
+		if current != *expected {
+			return 0, errs.ErrVersionMismatch
+		}
+	}
+
+	version, err := upsertPort(ctx, tx, port)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sql.Commit: failed with: %w", err)
+	}
+
+	return version, nil
+}
+
+// UpsertMany inserts or updates all the ports within a single transaction.
+// It returns ErrInvalidInput if any port is nil or has invalid coordinates, in which case nothing is written.
+func (s *SQLiteDB) UpsertMany(ctx context.Context, ports []*model.Port) error {
+	for _, port := range ports {
+		if port == nil {
+			return errs.ErrInvalidInput
+		}
+		if err := validateCoordinates(port); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sql.BeginTx: failed with: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, port := range ports {
+		if _, err := upsertPort(ctx, tx, port); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sql.Commit: failed with: %w", err)
+	}
+
+	return nil
+}
+
+// Get returns the port with the given id, or nil if there is no such port.
+func (s *SQLiteDB) Get(ctx context.Context, id string) (*model.Port, error) {
+	ports, err := s.query(ctx, selectPorts+` WHERE p.id = ? AND p.deleted_at IS NULL`, id)
+	if err != nil || len(ports) == 0 {
+		return nil, err
+	}
+
+	return ports[0], nil
+}
+
+// GetVersioned returns the port with the given id together with its version,
+// or nil and zero if there is no such port.
+func (s *SQLiteDB) GetVersioned(ctx context.Context, id string) (*model.Port, uint64, error) {
+	ports, err := s.queryVersioned(ctx, selectPorts+` WHERE p.id = ? AND p.deleted_at IS NULL`, id)
+	if err != nil || len(ports) == 0 {
+		return nil, 0, err
+	}
+
+	return ports[0].port, ports[0].version, nil
+}
+
+// GetTombstone returns the tombstone of the deleted port with the given id, or nil if there is no such tombstone.
+func (s *SQLiteDB) GetTombstone(ctx context.Context, id string) (*repository.Tombstone, error) {
+	ports, err := s.queryVersioned(ctx, selectPorts+` WHERE p.id = ? AND p.deleted_at IS NOT NULL`, id)
+	if err != nil || len(ports) == 0 {
+		return nil, err
+	}
+
+	return &repository.Tombstone{
+		Port:      ports[0].port,
+		DeletedAt: time.Unix(0, ports[0].deletedAt.Int64).UTC(),
+	}, nil
+}
+
+// Restore brings the deleted port with the given id back and returns its new version.
+// It returns ErrPortNotFound if there is no such deleted port.
+func (s *SQLiteDB) Restore(ctx context.Context, id string) (uint64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sql.BeginTx: failed with: %w", err)
+	}
+	defer tx.Rollback()
+
+	version, err := nextVersion(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE ports SET deleted_at = NULL, version = ? WHERE id = ? AND deleted_at IS NOT NULL`, version, id)
+	if err != nil {
+		return 0, fmt.Errorf("restore port: failed with: %w", err)
+	}
+	if err := requireRow(result); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sql.Commit: failed with: %w", err)
+	}
+
+	return version, nil
+}
+
+// PurgeTombstones removes for good the ports deleted before the given time, their list fields are removed by cascade.
+// It returns the number of removed ports.
+func (s *SQLiteDB) PurgeTombstones(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM ports WHERE deleted_at < ?`, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("purge ports: failed with: %w", err)
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sql.RowsAffected: failed with: %w", err)
+	}
+
+	return int(purged), nil
+}
+
+// Delete marks the port with the given id as deleted, its list fields are kept for the restore.
+// It returns ErrPortNotFound if the port does not exist.
+func (s *SQLiteDB) Delete(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, deletePort, time.Now().UnixNano(), id)
+	if err != nil {
+		return fmt.Errorf("delete port: failed with: %w", err)
+	}
+
+	return requireRow(result)
+}
+
+// DeleteIfVersion removes the port with the given id if its current version is the given one.
+// It returns ErrPortNotFound if the port does not exist, or ErrVersionMismatch if it has another version.
+func (s *SQLiteDB) DeleteIfVersion(ctx context.Context, id string, version uint64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sql.BeginTx: failed with: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := currentVersion(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return errs.ErrPortNotFound
+	}
+	if current != version {
+		return errs.ErrVersionMismatch
+	}
+
+	if _, err := tx.ExecContext(ctx, deletePort, time.Now().UnixNano(), id); err != nil {
+		return fmt.Errorf("delete port: failed with: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sql.Commit: failed with: %w", err)
+	}
+
+	return nil
+}
+
+// List returns a page of ports ordered by their id, or of the deleted ones if requested.
+func (s *SQLiteDB) List(ctx context.Context, opts repository.ListOptions) (*repository.ListResult, error) {
+	if opts.Limit <= 0 {
+		return nil, errs.ErrInvalidLimit
+	}
+
+	after, err := repository.DecodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	state := `p.deleted_at IS NULL`
+	if opts.Deleted {
+		state = `p.deleted_at IS NOT NULL`
+	}
+
+	// Read one more port to know whether there is a next page
+	ports, err := s.query(ctx, selectPorts+` WHERE `+state+` AND p.id > ? ORDER BY p.id LIMIT ?`, after, opts.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &repository.ListResult{Ports: ports}
+	if len(ports) > opts.Limit {
+		result.Ports = ports[:opts.Limit]
+		result.NextCursor = repository.EncodeCursor(result.Ports[opts.Limit-1].ID)
+	}
+
+	return result, nil
+}
+
+// FindByCountry returns the ports of the given country ordered by their id.
+func (s *SQLiteDB) FindByCountry(ctx context.Context, country string) ([]*model.Port, error) {
+	return s.find(ctx, selectPorts+` WHERE p.country = ? AND p.deleted_at IS NULL ORDER BY p.id`, country)
+}
+
+// FindByUnloc returns the ports having the given UN/LOCODE ordered by their id.
+func (s *SQLiteDB) FindByUnloc(ctx context.Context, unloc string) ([]*model.Port, error) {
+	return s.find(ctx, selectPorts+
+		` WHERE p.id IN (SELECT port_id FROM port_unlocs WHERE unloc = ?) AND p.deleted_at IS NULL ORDER BY p.id`,
+		unloc)
+}
+
+// FindByCode returns the ports having the given code ordered by their id.
+func (s *SQLiteDB) FindByCode(ctx context.Context, code string) ([]*model.Port, error) {
+	return s.find(ctx, selectPorts+` WHERE p.code = ? AND p.deleted_at IS NULL ORDER BY p.id`, code)
+}
+
+// FindByTimezone returns the ports in the given timezone ordered by their id.
+func (s *SQLiteDB) FindByTimezone(ctx context.Context, timezone string) ([]*model.Port, error) {
+	return s.find(ctx, selectPorts+` WHERE p.timezone = ? AND p.deleted_at IS NULL ORDER BY p.id`, timezone)
+}
+
+// GetLength returns the number of ports in the repository.
+func (s *SQLiteDB) GetLength(ctx context.Context) int {
+	var count int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM ports WHERE deleted_at IS NULL`).Scan(&count); err != nil {
+		return 0
+	}
+
+	return count
+}
+
+// find runs a lookup by field. Empty values are not indexed by the other
+// repositories, so they do not match any port here either.
+func (s *SQLiteDB) find(ctx context.Context, query, value string) ([]*model.Port, error) {
+	if value == "" {
+		return make([]*model.Port, 0), nil
+	}
+
+	return s.query(ctx, query, value)
+}
+
+// query runs a statement built on selectPorts and decodes the resulting ports.
+func (s *SQLiteDB) query(ctx context.Context, query string, args ...any) ([]*model.Port, error) {
+	versioned, err := s.queryVersioned(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]*model.Port, 0, len(versioned))
+	for _, v := range versioned {
+		ports = append(ports, v.port)
+	}
+
+	return ports, nil
+}
+
+// versionedPort is a port read together with its version and the time of its delete, if any.
+type versionedPort struct {
+	port      *model.Port
+	version   uint64
+	deletedAt sql.NullInt64
+}
+
+// queryVersioned runs a statement built on selectPorts and decodes the resulting ports with their versions.
+func (s *SQLiteDB) queryVersioned(ctx context.Context, query string, args ...any) ([]versionedPort, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select ports: failed with: %w", err)
+	}
+	defer rows.Close()
+
+	ports := make([]versionedPort, 0)
+	for rows.Next() {
+		port, err := scanPort(rows)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sql.Rows: failed with: %w", err)
+	}
+
+	return ports, nil
+}
+
+// scanPort decodes a row of selectPorts.
+func scanPort(rows *sql.Rows) (versionedPort, error) {
+	port := new(model.Port)
+	var aliases, regions, unlocs string
+	var longitude, latitude sql.NullFloat64
+	var version uint64
+	var deletedAt sql.NullInt64
+
+	err := rows.Scan(&port.ID, &port.Name, &port.City, &port.Province, &port.Country, &port.Timezone,
+		&port.Code, &port.Function, &port.Status, &aliases, &regions, &unlocs, &longitude, &latitude, &version, &deletedAt)
+	if err != nil {
+		return versionedPort{}, fmt.Errorf("sql.Scan: failed with: %w", err)
+	}
+
+	for _, list := range []struct {
+		raw    string
+		target *[]string
+	}{
+		{aliases, &port.Alias},
+		{regions, &port.Regions},
+		{unlocs, &port.Unlocs},
+	} {
+		if err := json.Unmarshal([]byte(list.raw), list.target); err != nil {
+			return versionedPort{}, fmt.Errorf("json.Unmarshal: failed with: %w", err)
+		}
+	}
+
+	if longitude.Valid && latitude.Valid {
+		port.Coordinates = []float64{longitude.Float64, latitude.Float64}
+	}
+
+	return versionedPort{port: port, version: version, deletedAt: deletedAt}, nil
+}
+
+// validateCoordinates returns an ErrInvalidInput error if the coordinates are neither empty nor a pair,
+// as the schema only stores a longitude and latitude pair.
+func validateCoordinates(port *model.Port) error {
+	if len(port.Coordinates) != 0 && len(port.Coordinates) != 2 {
+		return fmt.Errorf("%w: coordinates must be a longitude and latitude pair", errs.ErrInvalidInput)
+	}
+
+	return nil
+}
+
+// currentVersion returns the version of the port with the given id, or zero if there is no such live port.
+func currentVersion(ctx context.Context, tx *sql.Tx, id string) (uint64, error) {
+	var version uint64
+	err := tx.QueryRowContext(ctx, `SELECT version FROM ports WHERE id = ? AND deleted_at IS NULL`, id).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("select version: failed with: %w", err)
+	}
+
+	return version, nil
+}
+
+// nextVersion takes the next value of the version sequence within the given transaction.
+func nextVersion(ctx context.Context, tx *sql.Tx) (uint64, error) {
+	var version uint64
+	err := tx.QueryRowContext(ctx,
+		`UPDATE sequences SET value = value + 1 WHERE name = 'port_version' RETURNING value`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("next version: failed with: %w", err)
+	}
+
+	return version, nil
+}
+
+// requireRow returns ErrPortNotFound if the statement changed no row.
+func requireRow(result sql.Result) error {
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("sql.RowsAffected: failed with: %w", err)
+	}
+	if changed == 0 {
+		return errs.ErrPortNotFound
+	}
+
+	return nil
+}
+
+// upsertPort writes the port and replaces its list fields within the given transaction,
+// which also brings back a deleted port. It returns the new version of the port,
+// which is the next value of the version sequence.
+func upsertPort(ctx context.Context, tx *sql.Tx, port *model.Port) (uint64, error) {
+	version, err := nextVersion(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ports (id, name, city, province, country, timezone, code, function, status, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			name = excluded.name, city = excluded.city, province = excluded.province,
+			country = excluded.country, timezone = excluded.timezone, code = excluded.code,
+			function = excluded.function, status = excluded.status,
+			version = excluded.version, deleted_at = NULL`,
+		port.ID, port.Name, port.City, port.Province, port.Country, port.Timezone, port.Code, port.Function,
+		port.Status, version)
+	if err != nil {
+		return 0, fmt.Errorf("upsert port: failed with: %w", err)
+	}
+
+	lists := []struct {
+		table  string
+		column string
+		values []string
+	}{
+		{"port_aliases", "alias", port.Alias},
+		{"port_regions", "region", port.Regions},
+		{"port_unlocs", "unloc", port.Unlocs},
+	}
+	for _, list := range lists {
+		// The table and column names are constants, never user input
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+list.table+` WHERE port_id = ?`, port.ID); err != nil {
+			return 0, fmt.Errorf("delete %s: failed with: %w", list.table, err)
+		}
+
+		insert := `INSERT INTO ` + list.table + ` (port_id, position, ` + list.column + `) VALUES (?, ?, ?)`
+		for position, value := range list.values {
+			if _, err := tx.ExecContext(ctx, insert, port.ID, position, value); err != nil {
+				return 0, fmt.Errorf("insert %s: failed with: %w", list.table, err)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM port_coordinates WHERE port_id = ?`, port.ID); err != nil {
+		return 0, fmt.Errorf("delete port_coordinates: failed with: %w", err)
+	}
+	if len(port.Coordinates) == 2 {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO port_coordinates (port_id, longitude, latitude) VALUES (?, ?, ?)`,
+			port.ID, port.Coordinates[0], port.Coordinates[1])
+		if err != nil {
+			return 0, fmt.Errorf("insert port_coordinates: failed with: %w", err)
+		}
+	}
+
+	return version, nil
+}
@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/repository/repositorytest"
+	errs "github.com/canbo-x/port-service/internal/error"
+)
+
+func createPort(id, country string) *model.Port {
+	return &model.Port{
+		ID:          id,
+		Name:        "London",
+		City:        "London",
+		Country:     country,
+		Alias:       []string{"Londres", "Londra"},
+		Regions:     []string{},
+		Coordinates: []float64{-0.0833, 51.5},
+		Province:    "Greater London",
+		Timezone:    "Europe/London",
+		Unlocs:      []string{id},
+		Code:        "12345",
+	}
+}
+
+// openTestDB opens a new SQLite database in a temporary directory.
+func openTestDB(t *testing.T) (*SQLiteDB, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ports.sqlite")
+	db, err := NewSQLiteDB(context.Background(), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db, path
+}
+
+func TestSQLiteDB(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name     string
+		testFunc func(t *testing.T, db *SQLiteDB, path string)
+	}{
+		{
+			name: "UpsertGetAndDelete",
+			testFunc: func(t *testing.T, db *SQLiteDB, _ string) {
+				port := createPort("GBLON", "United Kingdom")
+				require.NoError(t, db.Upsert(ctx, port))
+
+				retrievedPort, err := db.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, port, retrievedPort)
+
+				// Updating the port must replace its list items
+				port.Alias = []string{"Londinium"}
+				port.Coordinates = nil
+				require.NoError(t, db.Upsert(ctx, port))
+				retrievedPort, err = db.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Equal(t, port, retrievedPort)
+				assert.Equal(t, 1, db.GetLength(ctx))
+
+				require.NoError(t, db.Delete(ctx, "GBLON"))
+				retrievedPort, err = db.Get(ctx, "GBLON")
+				require.NoError(t, err)
+				assert.Nil(t, retrievedPort)
+				assert.ErrorIs(t, db.Delete(ctx, "GBLON"), errs.ErrPortNotFound)
+
+				// The list items are kept for the restore until the port is purged
+				var aliases int
+				require.NoError(t, db.db.QueryRow(`SELECT COUNT(*) FROM port_aliases`).Scan(&aliases))
+				assert.Equal(t, 1, aliases)
+
+				// The list items must be removed by cascade
+				purged, err := db.PurgeTombstones(ctx, time.Now().Add(time.Hour))
+				require.NoError(t, err)
+				assert.Equal(t, 1, purged)
+				require.NoError(t, db.db.QueryRow(`SELECT COUNT(*) FROM port_aliases`).Scan(&aliases))
+				assert.Zero(t, aliases)
+			},
+		},
+		{
+			name: "InvalidCoordinates",
+			testFunc: func(t *testing.T, db *SQLiteDB, _ string) {
+				port := createPort("GBLON", "United Kingdom")
+				port.Coordinates = []float64{1}
+				assert.ErrorIs(t, db.Upsert(ctx, port), errs.ErrInvalidInput)
+			},
+		},
+		{
+			name: "ListAndFind",
+			testFunc: func(t *testing.T, db *SQLiteDB, _ string) {
+				for _, id := range []string{"GBLON", "FRPAR", "GBSOU"} {
+					country := "United Kingdom"
+					if id == "FRPAR" {
+						country = "France"
+					}
+					require.NoError(t, db.Upsert(ctx, createPort(id, country)))
+				}
+
+				page, err := db.List(ctx, repository.ListOptions{Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 2)
+				assert.Equal(t, "FRPAR", page.Ports[0].ID)
+				require.NotEmpty(t, page.NextCursor)
+
+				page, err = db.List(ctx, repository.ListOptions{Cursor: page.NextCursor, Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, page.Ports, 1)
+				assert.Equal(t, "GBSOU", page.Ports[0].ID)
+				assert.Empty(t, page.NextCursor)
+
+				ports, err := db.FindByCountry(ctx, "United Kingdom")
+				require.NoError(t, err)
+				require.Len(t, ports, 2)
+				assert.Equal(t, "GBLON", ports[0].ID)
+
+				ports, err = db.FindByUnloc(ctx, "FRPAR")
+				require.NoError(t, err)
+				require.Len(t, ports, 1)
+
+				ports, err = db.FindByCode(ctx, "")
+				require.NoError(t, err)
+				assert.Empty(t, ports)
+			},
+		},
+		{
+			name: "MigrationsAreAppliedOnce",
+			testFunc: func(t *testing.T, db *SQLiteDB, path string) {
+				require.NoError(t, db.Upsert(ctx, createPort("GBLON", "United Kingdom")))
+				require.NoError(t, db.Close())
+
+				reopened, err := NewSQLiteDB(ctx, path)
+				require.NoError(t, err)
+				defer reopened.Close()
+				assert.Equal(t, 1, reopened.GetLength(ctx))
+
+				migrations, err := loadMigrations()
+				require.NoError(t, err)
+
+				var version int
+				row := reopened.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`)
+				require.NoError(t, row.Scan(&version))
+				assert.Equal(t, migrations[len(migrations)-1].version, version)
+
+				// The lookup indexes are part of the schema
+				var index string
+				row = reopened.db.QueryRow(`SELECT name FROM sqlite_master WHERE name = 'ports_country_idx'`)
+				require.NoError(t, row.Scan(&index))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db, path := openTestDB(t)
+			tc.testFunc(t, db, path)
+		})
+	}
+}
+
+func TestSQLiteDBConformance(t *testing.T) {
+	repositorytest.Run(t, func() repository.PortRepository {
+		db, _ := openTestDB(t)
+		return db
+	})
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
@@ -21,3 +22,36 @@ func SetupSignalHandler(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}()
 }
+
+// SetupReloadHandler sets up a signal handler to listen for SIGHUP until the context is canceled,
+// so that the signal no longer terminates the process. It returns the function enabling the reloads:
+// the signals received before it is called are ignored, and the following ones call the reload function.
+// The signals received during a reload are coalesced into a single following reload.
+func SetupReloadHandler(ctx context.Context) func(reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	reloads := make(chan func(), 1)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		var reload func()
+		for {
+			select {
+			case <-signals:
+				if reload == nil {
+					log.Printf("Ignoring SIGHUP received before the startup is done")
+					continue
+				}
+				reload()
+			case reload = <-reloads:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func(reload func()) {
+		reloads <- reload
+	}
+}
@@ -3,6 +3,7 @@ package e2e
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/canbo-x/port-service/internal/application/filereader"
+	"github.com/canbo-x/port-service/internal/application/handler"
 	"github.com/canbo-x/port-service/internal/application/service"
 	"github.com/canbo-x/port-service/internal/domain/model"
 	"github.com/canbo-x/port-service/internal/infrastructure/httpserver"
@@ -78,13 +80,15 @@ func TestE2E(t *testing.T) {
 	log.Println("File processing complete. Starting HTTP server.")
 
 	// Initialize the HTTP server
-	httpServer := httpserver.NewHTTPServer(portService)
+	httpServer := httpserver.NewHTTPServer(service.NewDatasets(filename, portService))
 
 	// Start the server
 	wg.Add(1)
 	go func() {
-		err = httpServer.StartServer(ctx, cancel, wg)
-		require.NoError(t, err)
+		// The server returns the context error once the test cancels the context on exit
+		if serverErr := httpServer.StartServer(ctx, cancel, wg); !errors.Is(serverErr, context.Canceled) {
+			require.NoError(t, serverErr)
+		}
 	}()
 
 	// Wait for the server to start
@@ -128,6 +132,21 @@ func TestE2E(t *testing.T) {
 				require.Equal(t, *expectedPort, respPort)
 			},
 		},
+		{
+			name:           "test list ports",
+			url:            "http://localhost:8080/ports?limit=1",
+			method:         "GET",
+			expectedStatus: http.StatusOK,
+			validateResponse: func(t *testing.T, resp *http.Response) {
+				var respPage handler.ListPortsResponse
+				if err := json.NewDecoder(resp.Body).Decode(&respPage); err != nil {
+					require.FailNow(t, "failed to unmarshal response", err.Error())
+				}
+				require.Len(t, respPage.Ports, 1)
+				require.Equal(t, "FRPAR", respPage.Ports[0].ID)
+				require.NotEmpty(t, respPage.Next)
+			},
+		},
 		{
 			name:           "test get invalid port",
 			url:            "http://localhost:8080/ports/invalid",
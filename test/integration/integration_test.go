@@ -6,14 +6,26 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/canbo-x/port-service/internal/application/filereader"
 	"github.com/canbo-x/port-service/internal/application/handler"
+	"github.com/canbo-x/port-service/internal/application/replication"
 	"github.com/canbo-x/port-service/internal/application/service"
 	"github.com/canbo-x/port-service/internal/domain/model"
+	"github.com/canbo-x/port-service/internal/domain/repository"
+	"github.com/canbo-x/port-service/internal/domain/source"
+	errs "github.com/canbo-x/port-service/internal/error"
+	"github.com/canbo-x/port-service/internal/infrastructure/httpserver"
 	"github.com/canbo-x/port-service/internal/infrastructure/repository/memory"
 )
 
@@ -101,6 +113,1352 @@ func TestGetPort(t *testing.T) {
 	}
 }
 
+func TestListPorts(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with test data
+	for _, port := range []*model.Port{getGBLON(), getFRPAR()} {
+		if err := portRepository.Upsert(ctx, port); err != nil {
+			t.Fatalf("failed to upsert port: %v", err)
+		}
+	}
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedIDs    []string
+		expectNext     bool
+	}{
+		{
+			name:           "Default limit",
+			query:          "",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"FRPAR", "GBLON"},
+		},
+		{
+			name:           "First page",
+			query:          "limit=1",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"FRPAR"},
+			expectNext:     true,
+		},
+		{
+			name:           "Second page",
+			query:          "limit=1&cursor=RlJQQVI",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"GBLON"},
+		},
+		{
+			name:           "Invalid limit",
+			query:          "limit=abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Limit too large",
+			query:          "limit=100000",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid cursor",
+			query:          "cursor=%21%21",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Create a test request
+			req := httptest.NewRequest(http.MethodGet, "/ports?"+tc.query, nil)
+			rec := httptest.NewRecorder()
+
+			// Create Echo context
+			e := echo.New()
+			c := e.NewContext(req, rec)
+			c.SetPath("/ports")
+
+			// Execute the handler
+			if err := portHandler.ListPorts(c); err != nil {
+				t.Errorf("handler error: %v", err)
+			}
+
+			// Check the response status code
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+
+			if tc.expectedStatus == http.StatusOK {
+				// Decode the response
+				var resp handler.ListPortsResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("failed to unmarshal response: %v", err)
+				}
+
+				ids := make([]string, 0, len(resp.Ports))
+				for _, port := range resp.Ports {
+					ids = append(ids, port.ID)
+				}
+
+				// Compare the results
+				assert.Equal(t, tc.expectedIDs, ids)
+				assert.Equal(t, tc.expectNext, resp.Next != "")
+				assert.Equal(t, tc.expectNext, rec.Header().Get("Link") != "")
+			}
+		})
+	}
+}
+
+func TestDeletePort(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with test data
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	// The steps share the repository, so they run sequentially
+	testCases := []struct {
+		name           string
+		id             string
+		expectedStatus int
+	}{
+		{
+			name:           "Existing port",
+			id:             "GBLON",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Already deleted port",
+			id:             "GBLON",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid Port ID",
+			id:             "invalid_id",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Create a test request
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/ports/%s", tc.id), nil)
+			rec := httptest.NewRecorder()
+
+			// Create Echo context and set parameters
+			e := echo.New()
+			c := e.NewContext(req, rec)
+			c.SetPath("/ports/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(tc.id)
+
+			// Execute the handler
+			if err := portHandler.DeletePort(c); err != nil {
+				t.Errorf("handler error: %v", err)
+			}
+
+			// Check the response status code
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+		})
+	}
+
+	assert.Equal(t, 0, portService.GetLength(ctx))
+}
+
+func TestPortHistory(t *testing.T) {
+	ctx := repository.WithSource(context.Background(), "import:ports.json")
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with two revisions of the same port
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	moved := getGBLON()
+	moved.Coordinates = []float64{-0.1, 51.6}
+	if err := portRepository.Upsert(ctx, moved); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	// newContext creates an Echo context for a request to the given port path
+	newContext := func(target, path, id string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath(path)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+
+		return c, rec
+	}
+
+	// Delete the port through the API, which is recorded as the third revision
+	c, rec := newContext("/ports/GBLON", "/ports/:id", "GBLON")
+	assert.NoError(t, portHandler.DeletePort(c))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	t.Run("History", func(t *testing.T) {
+		c, rec := newContext("/ports/GBLON/history", "/ports/:id/history", "GBLON")
+		assert.NoError(t, portHandler.GetPortHistory(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp handler.PortHistoryResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "GBLON", resp.ID)
+		if assert.Len(t, resp.Revisions, 3) {
+			assert.Equal(t, uint64(1), resp.Revisions[0].Revision)
+			assert.Equal(t, "import:ports.json", resp.Revisions[0].Source)
+			assert.Equal(t, getGBLON(), resp.Revisions[0].Port)
+			assert.Equal(t, moved, resp.Revisions[1].Port)
+			assert.Equal(t, "api", resp.Revisions[2].Source)
+			assert.True(t, resp.Revisions[2].Deleted)
+			assert.Nil(t, resp.Revisions[2].Port)
+		}
+	})
+
+	testCases := []struct {
+		name           string
+		revision       string
+		expectedStatus int
+		expectedPort   *model.Port
+	}{
+		{
+			name:           "First revision",
+			revision:       "1",
+			expectedStatus: http.StatusOK,
+			expectedPort:   getGBLON(),
+		},
+		{
+			name:           "Second revision",
+			revision:       "2",
+			expectedStatus: http.StatusOK,
+			expectedPort:   moved,
+		},
+		{
+			name:           "Deleted revision",
+			revision:       "3",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Unknown revision",
+			revision:       "4",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid revision",
+			revision:       "first",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero revision",
+			revision:       "0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			c, rec := newContext("/ports/GBLON?revision="+tc.revision, "/ports/:id", "GBLON")
+			assert.NoError(t, portHandler.GetPort(c))
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+
+			if tc.expectedPort != nil {
+				var port model.Port
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &port))
+				assert.Equal(t, tc.expectedPort, &port)
+			}
+		})
+	}
+
+	t.Run("Unknown port", func(t *testing.T) {
+		c, rec := newContext("/ports/NLRTM/history", "/ports/:id/history", "NLRTM")
+		assert.NoError(t, portHandler.GetPortHistory(c))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Storage without history", func(t *testing.T) {
+		// Hide the history methods of the memory database
+		withoutHistory := struct{ repository.PortRepository }{portRepository}
+		portHandler := handler.NewPortHandler(service.NewPortService(withoutHistory))
+
+		c, rec := newContext("/ports/GBLON/history", "/ports/:id/history", "GBLON")
+		assert.NoError(t, portHandler.GetPortHistory(c))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
+
+func TestPointInTime(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with two revisions of the same port, apart in time, and a later port
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	moved := getGBLON()
+	moved.Coordinates = []float64{-0.1, 51.6}
+	if err := portRepository.Upsert(ctx, moved); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	if err := portRepository.Upsert(ctx, getFRPAR()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	revisions, err := portRepository.History(ctx, "GBLON")
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	created, updated := revisions[0].Timestamp, revisions[1].Timestamp
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	// get sends a GET request with the given query to the port handler, or to the listing without an id
+	get := func(id string, query url.Values) *httptest.ResponseRecorder {
+		target := "/ports"
+		handle := portHandler.ListPorts
+		if id != "" {
+			target += "/" + id
+			handle = portHandler.GetPort
+		}
+		req := httptest.NewRequest(http.MethodGet, target+"?"+query.Encode(), nil)
+		rec := httptest.NewRecorder()
+
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handle(c))
+
+		return rec
+	}
+	asOf := func(at time.Time) url.Values {
+		return url.Values{"asOf": {at.Format(time.RFC3339Nano)}}
+	}
+
+	t.Run("Port", func(t *testing.T) {
+		rec := get("GBLON", asOf(created))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var port model.Port
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &port))
+		assert.Equal(t, getGBLON(), &port)
+
+		rec = get("GBLON", asOf(updated))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &port))
+		assert.Equal(t, moved, &port)
+
+		// The port did not exist yet
+		rec = get("GBLON", asOf(created.Add(-time.Second)))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		rec := get("", asOf(created))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var resp handler.ListPortsResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		if assert.Len(t, resp.Ports, 1) {
+			assert.Equal(t, getGBLON(), resp.Ports[0])
+		}
+
+		rec = get("", asOf(time.Now()))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Len(t, resp.Ports, 2)
+	})
+
+	t.Run("Expired history", func(t *testing.T) {
+		portRepository.SetHistoryLimit(1)
+		defer portRepository.SetHistoryLimit(memory.DefaultHistoryLimit)
+
+		// The expired history is told apart from a port which did not exist at that time
+		rec := get("GBLON", asOf(created))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), errs.ErrHistoryExpired.Error())
+
+		rec = get("", asOf(created))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), errs.ErrHistoryExpired.Error())
+
+		// The port may have existed before the trimmed revisions, so it is not reported as missing either
+		rec = get("GBLON", asOf(created.Add(-time.Second)))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("Invalid time", func(t *testing.T) {
+		rec := get("GBLON", url.Values{"asOf": {"2026-01-01"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = get("GBLON", url.Values{"asOf": {"2026-01-01T00:00:00Z"}, "revision": {"1"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = get("", url.Values{"asOf": {"yesterday"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = get("", url.Values{"asOf": {"2026-01-01T00:00:00Z"}, "deleted": {"true"}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Storage without history", func(t *testing.T) {
+		// Hide the history methods of the memory database
+		withoutHistory := struct{ repository.PortRepository }{portRepository}
+		portHandler = handler.NewPortHandler(service.NewPortService(withoutHistory))
+
+		rec := get("GBLON", asOf(created))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+		rec = get("", asOf(created))
+		assert.Equal(t, http.StatusNotImplemented, rec.Code)
+	})
+}
+
+func TestConditionalWrites(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with test data
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	// do sends a request with the given body and headers to the handler of the method
+	do := func(method, id, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, fmt.Sprintf("/ports/%s", id), strings.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/ports/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+
+		var err error
+		switch method {
+		case http.MethodGet:
+			err = portHandler.GetPort(c)
+		case http.MethodPut:
+			err = portHandler.PutPort(c)
+		case http.MethodDelete:
+			err = portHandler.DeletePort(c)
+		}
+		assert.NoError(t, err)
+
+		return rec
+	}
+
+	renamed := getGBLON()
+	renamed.Name = "City of London"
+	body, err := json.Marshal(renamed)
+	assert.NoError(t, err)
+
+	// The steps share the repository, so they run sequentially
+	rec := do(http.MethodGet, "GBLON", "", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	t.Run("Matching ETag", func(t *testing.T) {
+		rec := do(http.MethodPut, "GBLON", string(body), map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+		port, err := portService.GetPort(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Equal(t, "City of London", port.Name)
+	})
+
+	t.Run("Stale ETag", func(t *testing.T) {
+		rec := do(http.MethodPut, "GBLON", string(body), map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+		rec = do(http.MethodPut, "GBLON", string(body), map[string]string{"If-Match": "W/" + etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+		rec = do(http.MethodDelete, "GBLON", "", map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+		assert.Equal(t, 1, portService.GetLength(ctx))
+	})
+
+	t.Run("Without precondition", func(t *testing.T) {
+		rec := do(http.MethodPut, "GBLON", `{"name":"London"}`, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+		etag = rec.Header().Get("ETag")
+
+		// The ETag of the write is the one returned by the reads
+		rec = do(http.MethodGet, "GBLON", "", nil)
+		assert.Equal(t, etag, rec.Header().Get("ETag"))
+	})
+
+	t.Run("Create only", func(t *testing.T) {
+		rec := do(http.MethodPut, "GBLON", `{}`, map[string]string{"If-None-Match": "*"})
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+		rec = do(http.MethodPut, "NLRTM", `{"name":"Rotterdam"}`, map[string]string{"If-None-Match": "*"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, portService.GetLength(ctx))
+
+		rec = do(http.MethodPut, "DEHAM", `{}`, map[string]string{"If-Match": "*"})
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	})
+
+	t.Run("Invalid port", func(t *testing.T) {
+		rec := do(http.MethodPut, "GBLON", `{"id":"FRPAR"}`, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = do(http.MethodPut, "GBLON", `{"coordinates":[1]}`, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = do(http.MethodPut, "GBLON", `{`, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = do(http.MethodPut, "invalid_id", `{}`, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Delete with matching ETag", func(t *testing.T) {
+		rec := do(http.MethodDelete, "GBLON", "", map[string]string{"If-Match": etag})
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, 1, portService.GetLength(ctx))
+	})
+
+	t.Run("Losing to concurrent writes", func(t *testing.T) {
+		contended := &contendedRepository{PortRepository: memory.NewMemoryDB()}
+		portHandler = handler.NewPortHandler(service.NewPortService(contended))
+
+		rec := do(http.MethodPut, "GBLON", `{"name":"London"}`, nil)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, service.MaxSaveAttempts, contended.attempts)
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := service.NewPortService(contended).SavePort(canceledCtx, getGBLON(), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, service.MaxSaveAttempts, contended.attempts)
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with test data
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	if err := portRepository.Upsert(ctx, getFRPAR()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+
+	// Create the port handler
+	portHandler := handler.NewPortHandler(portService)
+
+	// do sends a request to the given handler, with the port id as path parameter unless empty
+	do := func(method, target, id string, handle echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		rec := httptest.NewRecorder()
+
+		c := echo.New().NewContext(req, rec)
+		if id != "" {
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+		assert.NoError(t, handle(c))
+
+		return rec
+	}
+
+	// listed returns the ids of the ports listed by the given request
+	listed := func(target string) []string {
+		rec := do(http.MethodGet, target, "", portHandler.ListPorts)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp handler.ListPortsResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		ids := make([]string, 0, len(resp.Ports))
+		for _, port := range resp.Ports {
+			ids = append(ids, port.ID)
+		}
+		return ids
+	}
+
+	// The steps share the repository, so they run sequentially
+	rec := do(http.MethodDelete, "/ports/GBLON", "GBLON", portHandler.DeletePort)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	t.Run("Deleted port is gone", func(t *testing.T) {
+		rec := do(http.MethodGet, "/ports/GBLON", "GBLON", portHandler.GetPort)
+		assert.Equal(t, http.StatusGone, rec.Code)
+
+		rec = do(http.MethodGet, "/ports/NLRTM", "NLRTM", portHandler.GetPort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(http.MethodDelete, "/ports/GBLON", "GBLON", portHandler.DeletePort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		assert.Equal(t, []string{"FRPAR"}, listed("/ports"))
+		assert.Equal(t, []string{"FRPAR"}, listed("/ports?deleted=false"))
+		assert.Equal(t, []string{"GBLON"}, listed("/ports?deleted=true"))
+
+		rec := do(http.MethodGet, "/ports?deleted=maybe", "", portHandler.ListPorts)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		rec := do(http.MethodPost, "/ports/GBLON/restore", "GBLON", portHandler.RestorePort)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+
+		var port model.Port
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &port))
+		assert.Equal(t, getGBLON(), &port)
+
+		rec = do(http.MethodGet, "/ports/GBLON", "GBLON", portHandler.GetPort)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{"FRPAR", "GBLON"}, listed("/ports"))
+		assert.Empty(t, listed("/ports?deleted=true"))
+
+		// A live port has no tombstone to restore
+		rec = do(http.MethodPost, "/ports/GBLON/restore", "GBLON", portHandler.RestorePort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(http.MethodPost, "/ports/invalid_id/restore", "invalid_id", portHandler.RestorePort)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		rec := do(http.MethodDelete, "/ports/FRPAR", "FRPAR", portHandler.DeletePort)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		// The tombstone is kept for the retention
+		purged, err := portService.PurgeDeletedPorts(ctx, time.Hour)
+		assert.NoError(t, err)
+		assert.Zero(t, purged)
+
+		purged, err = portService.PurgeDeletedPorts(ctx, -time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+
+		rec = do(http.MethodGet, "/ports/FRPAR", "FRPAR", portHandler.GetPort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = do(http.MethodPost, "/ports/FRPAR/restore", "FRPAR", portHandler.RestorePort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestDatasets(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize a default and an internal dataset, each with its own repository
+	defaultRepository := memory.NewMemoryDB()
+	internalRepository := memory.NewMemoryDB()
+	datasets := service.NewDatasets("ports.json", service.NewPortService(defaultRepository))
+	assert.NoError(t, datasets.Add("internal", "internal.json", service.NewPortService(internalRepository)))
+	assert.ErrorIs(t, datasets.Add("internal", "other.json", service.NewPortService(memory.NewMemoryDB())),
+		errs.ErrDatasetExists)
+	assert.ErrorIs(t, datasets.Add("Internal List", "other.json", service.NewPortService(memory.NewMemoryDB())),
+		errs.ErrInvalidDataset)
+
+	// Populate the datasets with different ports
+	if err := defaultRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	if err := internalRepository.Upsert(ctx, getFRPAR()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+
+	// Create the port handler
+	portHandler := handler.NewDatasetHandler(datasets)
+
+	// do sends a request to the given handler with the dataset and the port id as path parameters
+	do := func(method, target, ds, id string, handle echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("{}"))
+		rec := httptest.NewRecorder()
+
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("ds", "id")
+		c.SetParamValues(ds, id)
+		assert.NoError(t, handle(c))
+
+		return rec
+	}
+
+	testCases := []struct {
+		name           string
+		ds             string
+		id             string
+		expectedStatus int
+	}{
+		{name: "Default dataset without prefix", ds: "", id: "GBLON", expectedStatus: http.StatusOK},
+		{name: "Default dataset with prefix", ds: "default", id: "GBLON", expectedStatus: http.StatusOK},
+		{name: "Port of another dataset", ds: "", id: "FRPAR", expectedStatus: http.StatusNotFound},
+		{name: "Internal dataset", ds: "internal", id: "FRPAR", expectedStatus: http.StatusOK},
+		{name: "Port of the default dataset", ds: "internal", id: "GBLON", expectedStatus: http.StatusNotFound},
+		{name: "Unknown dataset", ds: "customer", id: "GBLON", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := do(http.MethodGet, "/ports/"+tc.id, tc.ds, tc.id, portHandler.GetPort)
+			assert.Equal(t, tc.expectedStatus, rec.Code)
+		})
+	}
+
+	t.Run("Writes stay in their dataset", func(t *testing.T) {
+		rec := do(http.MethodPut, "/datasets/internal/ports/NLRTM", "internal", "NLRTM", portHandler.PutPort)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, internalRepository.GetLength(ctx))
+		assert.Equal(t, 1, defaultRepository.GetLength(ctx))
+
+		rec = do(http.MethodPut, "/datasets/customer/ports/NLRTM", "customer", "NLRTM", portHandler.PutPort)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Contains(t, rec.Body.String(), errs.ErrDatasetNotFound.Error())
+
+		rec = do(http.MethodDelete, "/datasets/internal/ports/NLRTM", "internal", "NLRTM", portHandler.DeletePort)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		rec := do(http.MethodGet, "/datasets", "", "", portHandler.ListDatasets)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var stats []service.DatasetStats
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		assert.Equal(t, []service.DatasetStats{
+			{Name: "default", Source: "ports.json", Ports: 1},
+			{Name: "internal", Source: "internal.json", Ports: 1},
+		}, stats)
+
+		rec = do(http.MethodGet, "/datasets/internal", "internal", "", portHandler.GetDataset)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var dataset service.DatasetStats
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &dataset))
+		assert.Equal(t, service.DatasetStats{Name: "internal", Source: "internal.json", Ports: 1}, dataset)
+
+		rec = do(http.MethodGet, "/datasets/customer", "customer", "", portHandler.GetDataset)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Listing", func(t *testing.T) {
+		rec := do(http.MethodGet, "/datasets/internal/ports", "internal", "", portHandler.ListPorts)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var resp handler.ListPortsResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		if assert.Len(t, resp.Ports, 1) {
+			assert.Equal(t, "FRPAR", resp.Ports[0].ID)
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+
+	// Initialize the repository and the service
+	portRepository := memory.NewMemoryDB()
+	portService := service.NewPortService(portRepository)
+
+	// Populate the repository with test data
+	if err := portRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	_, version, err := portService.GetPortVersioned(ctx, "GBLON")
+	assert.NoError(t, err)
+
+	newRepository := func(current repository.PortRepository) (repository.PortRepository, error) {
+		return current.(*memory.MemoryDB).Successor(), nil
+	}
+
+	renamed := getGBLON()
+	renamed.Name = "City of London"
+
+	testCases := []struct {
+		name          string
+		ports         []*model.Port
+		opts          service.ReloadOptions
+		expectedError error
+		expectedName  string
+	}{
+		{
+			name:          "Too Few Ports",
+			ports:         []*model.Port{},
+			opts:          service.ReloadOptions{MinCount: 1},
+			expectedError: errs.ErrReloadRejected,
+			expectedName:  "London",
+		},
+		{
+			name:          "Too Large Delta",
+			ports:         []*model.Port{renamed, getFRPAR()},
+			opts:          service.ReloadOptions{MinCount: 1, MaxDelta: 0.5},
+			expectedError: errs.ErrReloadRejected,
+			expectedName:  "London",
+		},
+		{
+			name:         "Valid File",
+			ports:        []*model.Port{renamed, getFRPAR()},
+			opts:         service.ReloadOptions{MinCount: 2, MaxDelta: 1},
+			expectedName: "City of London",
+		},
+	}
+
+	// The reloads replace the same repository, so they run sequentially
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			fileReader := &filereader.JSONFileReader{
+				Filename:   writePortsFile(t, tc.ports),
+				BufferSize: 1024,
+			}
+
+			err := portService.Reload(ctx, fileReader, newRepository, tc.opts)
+			assert.ErrorIs(t, err, tc.expectedError)
+
+			port, err := portService.GetPort(ctx, "GBLON")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedName, port.Name)
+		})
+	}
+
+	t.Run("Versions Continue", func(t *testing.T) {
+		_, reloaded, err := portService.GetPortVersioned(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Greater(t, reloaded, version)
+
+		// The current repository is not changed by the reload, it is replaced
+		port, err := portRepository.Get(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Equal(t, "London", port.Name)
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		fileReader := &filereader.JSONFileReader{Filename: filepath.Join(t.TempDir(), "missing.json")}
+		err := portService.Reload(ctx, fileReader, newRepository, service.ReloadOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, 2, portService.GetLength(ctx))
+	})
+
+	t.Run("Reload In Progress", func(t *testing.T) {
+		fileReader := &filereader.JSONFileReader{Filename: writePortsFile(t, []*model.Port{getGBLON()})}
+
+		// The first reload waits in the factory until the second one is rejected
+		started := make(chan struct{})
+		release := make(chan struct{})
+		blocking := func(current repository.PortRepository) (repository.PortRepository, error) {
+			close(started)
+			<-release
+			return newRepository(current)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- portService.Reload(ctx, fileReader, blocking, service.ReloadOptions{})
+		}()
+
+		<-started
+		err := portService.Reload(ctx, fileReader, newRepository, service.ReloadOptions{})
+		assert.ErrorIs(t, err, errs.ErrReloadInProgress)
+
+		// The readers are served by the current repository during the reload
+		assert.Equal(t, 2, portService.GetLength(ctx))
+
+		close(release)
+		assert.NoError(t, <-done)
+		assert.Equal(t, 1, portService.GetLength(ctx))
+	})
+	t.Run("Failed Import Stops The Source", func(t *testing.T) {
+		src := &endlessSource{stopped: make(chan struct{})}
+		failing := func(repository.PortRepository) (repository.PortRepository, error) {
+			return &failingRepository{PortRepository: memory.NewMemoryDB()}, nil
+		}
+
+		err := portService.Reload(ctx, src, failing, service.ReloadOptions{})
+		assert.ErrorIs(t, err, errs.ErrInvalidInput)
+
+		// The source is not left blocked on sending its next port
+		select {
+		case <-src.stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the source was not stopped")
+		}
+		assert.Equal(t, 1, portService.GetLength(ctx))
+	})
+}
+
+func TestFullSync(t *testing.T) {
+	ctx := context.Background()
+
+	getNLRTM := func() *model.Port {
+		port := getFRPAR()
+		port.ID, port.Name, port.Unlocs = "NLRTM", "Rotterdam", []string{"NLRTM"}
+		return port
+	}
+
+	testCases := []struct {
+		name            string
+		ports           []*model.Port
+		opts            service.SyncOptions
+		expectedError   error
+		expectedDeleted int
+		expectedIDs     []string
+	}{
+		{
+			name:            "Missing Ports Are Deleted",
+			ports:           []*model.Port{getGBLON(), getFRPAR()},
+			opts:            service.SyncOptions{MaxRemove: 0.5},
+			expectedDeleted: 1,
+			expectedIDs:     []string{"FRPAR", "GBLON"},
+		},
+		{
+			name:            "New Ports Are Added",
+			ports:           []*model.Port{getGBLON(), getFRPAR(), getNLRTM()},
+			opts:            service.SyncOptions{MaxRemove: 0.1},
+			expectedDeleted: 0,
+			expectedIDs:     []string{"FRPAR", "GBLON", "NLRTM"},
+		},
+		{
+			name:          "Too Many Ports Would Be Deleted",
+			ports:         []*model.Port{getGBLON()},
+			opts:          service.SyncOptions{MaxRemove: 0.5},
+			expectedError: errs.ErrSyncRejected,
+			expectedIDs:   []string{"FRPAR", "GBLON", "NLRTM"},
+		},
+		{
+			name:            "Check Disabled",
+			ports:           []*model.Port{getGBLON()},
+			opts:            service.SyncOptions{},
+			expectedDeleted: 2,
+			expectedIDs:     []string{"GBLON"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // Capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			portService := service.NewPortService(memory.NewMemoryDB())
+			assert.NoError(t, portService.UpsertPorts(ctx, []*model.Port{getGBLON(), getFRPAR(), getNLRTM()}))
+			fileReader := &filereader.JSONFileReader{Filename: writePortsFile(t, tc.ports), BufferSize: 1024}
+
+			deleted, err := portService.SyncFileToDB(ctx, fileReader, tc.opts)
+			assert.ErrorIs(t, err, tc.expectedError)
+			assert.Equal(t, tc.expectedDeleted, deleted)
+
+			result, err := portService.ListPorts(ctx, "", 0, false)
+			assert.NoError(t, err)
+			ids := make([]string, 0, len(result.Ports))
+			for _, port := range result.Ports {
+				ids = append(ids, port.ID)
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+
+	t.Run("Deleted Ports Are Kept As Tombstones", func(t *testing.T) {
+		portService := service.NewPortService(memory.NewMemoryDB())
+		assert.NoError(t, portService.UpsertPorts(ctx, []*model.Port{getGBLON(), getFRPAR()}))
+		fileReader := &filereader.JSONFileReader{
+			Filename:   writePortsFile(t, []*model.Port{getGBLON()}),
+			BufferSize: 1024,
+		}
+
+		deleted, err := portService.SyncFileToDB(ctx, fileReader, service.SyncOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		_, err = portService.GetPort(ctx, "FRPAR")
+		assert.ErrorIs(t, err, errs.ErrPortGone)
+		revisions, err := portService.GetPortHistory(ctx, "FRPAR")
+		assert.NoError(t, err)
+		if assert.Len(t, revisions, 2) {
+			assert.True(t, revisions[1].Deleted)
+			assert.Equal(t, service.SourceImportPrefix+fileReader.Filename, revisions[1].Source)
+		}
+	})
+
+	t.Run("Skipped Broken Port Deletes Nothing", func(t *testing.T) {
+		portService := service.NewPortService(memory.NewMemoryDB())
+		assert.NoError(t, portService.UpsertPorts(ctx, []*model.Port{getGBLON(), getFRPAR()}))
+
+		// The stored FRPAR port is still in the file, but broken
+		renamed := getGBLON()
+		renamed.Name = "City of London"
+		data, err := json.Marshal(renamed)
+		assert.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "ports.json")
+		content := fmt.Sprintf(`{"GBLON": %s, "FRPAR": {"name": 42}}`, data)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		fileReader := &filereader.JSONFileReader{Filename: path, BufferSize: 1024, SkipBroken: true}
+
+		deleted, err := portService.SyncFileToDB(ctx, fileReader, service.SyncOptions{})
+		assert.ErrorIs(t, err, errs.ErrSyncRejected)
+		assert.Zero(t, deleted)
+		assert.Equal(t, 2, portService.GetLength(ctx))
+
+		// The valid ports are imported all the same
+		port, err := portService.GetPort(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Equal(t, "City of London", port.Name)
+	})
+
+	t.Run("Failed Import Deletes Nothing", func(t *testing.T) {
+		portService := service.NewPortService(memory.NewMemoryDB())
+		assert.NoError(t, portService.UpsertPorts(ctx, []*model.Port{getGBLON(), getFRPAR()}))
+		fileReader := &filereader.JSONFileReader{Filename: filepath.Join(t.TempDir(), "missing.json")}
+
+		_, err := portService.SyncFileToDB(ctx, fileReader, service.SyncOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, 2, portService.GetLength(ctx))
+	})
+}
+
+func TestImportSources(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Chained Sources Are Imported In Order", func(t *testing.T) {
+		renamed := getGBLON()
+		renamed.Name = "London Gateway"
+		rotterdam := getFRPAR()
+		rotterdam.ID, rotterdam.Name, rotterdam.Unlocs = "NLRTM", "Rotterdam", []string{"NLRTM"}
+		first := &filereader.JSONFileReader{Filename: writePortsFile(t, []*model.Port{getGBLON(), getFRPAR()})}
+		second := &filereader.JSONFileReader{Filename: writePortsFile(t, []*model.Port{renamed, rotterdam})}
+		chained := source.Chain(first, second)
+		assert.Equal(t, first.Filename+"+"+second.Filename, chained.Name())
+		assert.Equal(t, "json", chained.Metadata()["format"])
+
+		portService := service.NewPortService(memory.NewMemoryDB())
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		assert.NoError(t, portService.StoreFileToDB(ctx, chained, wg))
+		assert.Equal(t, 3, portService.GetLength(ctx))
+
+		port, err := portService.GetPort(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Equal(t, "London Gateway", port.Name)
+		revisions, err := portService.GetPortHistory(ctx, "GBLON")
+		assert.NoError(t, err)
+		if assert.Len(t, revisions, 2) {
+			assert.Equal(t, service.SourceImportPrefix+chained.Name(), revisions[1].Source)
+		}
+	})
+
+	t.Run("Broken Ports Are Skipped Or Fail The Import", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "ports.json")
+		content := `{"GBLON": {"name": "London"}, "FRPAR": {"name": ["Paris"]}, "NLRTM": {"name": "Rotterdam"}}`
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+
+		for _, skipBroken := range []bool{true, false} {
+			portService := service.NewPortService(memory.NewMemoryDB())
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			err := portService.StoreFileToDB(ctx, &filereader.JSONFileReader{
+				Filename:   filename,
+				SkipBroken: skipBroken,
+			}, wg)
+
+			if skipBroken {
+				assert.NoError(t, err)
+				assert.Equal(t, 2, portService.GetLength(ctx))
+			} else {
+				assert.ErrorContains(t, err, "FRPAR")
+			}
+		}
+	})
+
+	t.Run("Chain Stops At The First Failed Source", func(t *testing.T) {
+		chained := source.Chain(
+			&filereader.JSONFileReader{Filename: filepath.Join(t.TempDir(), "missing.json")},
+			&filereader.JSONFileReader{Filename: writePortsFile(t, []*model.Port{getGBLON()})},
+		)
+
+		portService := service.NewPortService(memory.NewMemoryDB())
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		err := portService.StoreFileToDB(ctx, chained, wg)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.ErrorContains(t, err, "missing.json")
+		assert.Equal(t, 0, portService.GetLength(ctx))
+	})
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Initialize the leader with a default and an internal dataset, keeping only a few changes
+	// so that the followers also have to start over from a snapshot
+	leaderRepository := memory.NewMemoryDB()
+	leaderService := service.NewPortService(leaderRepository)
+	internalService := service.NewPortService(memory.NewMemoryDB())
+	leaderDatasets := service.NewDatasets("ports.json", leaderService)
+	assert.NoError(t, leaderDatasets.Add("internal", "internal.json", internalService))
+	if err := leaderRepository.Upsert(ctx, getGBLON()); err != nil {
+		t.Fatalf("failed to upsert port: %v", err)
+	}
+	for _, portService := range []*service.PortService{leaderService, internalService} {
+		portService := portService
+		go portService.RunChangeLog(ctx, 2)
+	}
+	leader := httptest.NewServer(httpserver.NewHTTPServer(leaderDatasets).Handler())
+	defer func() {
+		// Stop the followers before the leader, so that they do not retry against it
+		cancel()
+		leader.Close()
+	}()
+
+	// Start two followers of the default dataset and one of the internal dataset, all in process
+	opts := replication.Options{Wait: 100 * time.Millisecond, RetryInterval: 10 * time.Millisecond}
+	followerServices := make([]*service.PortService, 0, 2)
+	for i := 0; i < 2; i++ {
+		followerService := service.NewPortService(memory.NewMemoryDB())
+		go replication.NewFollower(leader.URL, followerService, opts).Run(ctx)
+		followerServices = append(followerServices, followerService)
+	}
+	internalFollower := service.NewPortService(memory.NewMemoryDB())
+	go replication.NewFollower(leader.URL+"/datasets/internal", internalFollower, opts).Run(ctx)
+
+	// caughtUp reports whether the follower holds the ports of the leader with the same versions
+	caughtUp := func(leaderService, followerService *service.PortService) bool {
+		expected, err := leaderService.ReplicationSnapshot(ctx)
+		assert.NoError(t, err)
+		actual, err := followerService.ReplicationSnapshot(ctx)
+		assert.NoError(t, err)
+
+		return expected.Version == actual.Version &&
+			assert.ObjectsAreEqual(expected.Ports, actual.Ports) &&
+			assert.ObjectsAreEqual(expected.Versions, actual.Versions) &&
+			len(expected.Tombstones) == len(actual.Tombstones)
+	}
+	requireCaughtUp := func(t *testing.T) {
+		t.Helper()
+		for _, followerService := range followerServices {
+			followerService := followerService
+			assert.Eventually(t, func() bool { return caughtUp(leaderService, followerService) },
+				5*time.Second, 10*time.Millisecond)
+		}
+		assert.Eventually(t, func() bool { return caughtUp(internalService, internalFollower) },
+			5*time.Second, 10*time.Millisecond)
+	}
+
+	t.Run("Followers start from a snapshot", func(t *testing.T) {
+		requireCaughtUp(t)
+	})
+
+	t.Run("Followers apply the changes in order", func(t *testing.T) {
+		assert.NoError(t, leaderService.UpsertPort(ctx, getFRPAR()))
+		assert.NoError(t, leaderService.UpsertPorts(ctx, []*model.Port{getGBLON(), getFRPAR()}))
+		assert.NoError(t, leaderService.DeletePort(ctx, "GBLON"))
+		assert.NoError(t, internalService.UpsertPort(ctx, getFRPAR()))
+		requireCaughtUp(t)
+
+		// The versions are the same, so an ETag read from a follower can be used in a write to the leader
+		_, version, err := followerServices[0].GetPortVersioned(ctx, "FRPAR")
+		assert.NoError(t, err)
+		_, err = leaderService.SavePort(ctx, getFRPAR(), &version)
+		assert.NoError(t, err)
+		requireCaughtUp(t)
+	})
+
+	t.Run("Followers start over once the changes are no longer kept", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, leaderService.UpsertPort(ctx, getGBLON()))
+		}
+		requireCaughtUp(t)
+	})
+
+	t.Run("Followers follow a reload", func(t *testing.T) {
+		renamed := getGBLON()
+		renamed.Name = "City of London"
+		fileReader := &filereader.JSONFileReader{
+			Filename:   writePortsFile(t, []*model.Port{renamed, getFRPAR()}),
+			BufferSize: 1024,
+		}
+		newRepository := func(current repository.PortRepository) (repository.PortRepository, error) {
+			return current.(*memory.MemoryDB).Successor(), nil
+		}
+		assert.NoError(t, leaderService.Reload(ctx, fileReader, newRepository, service.ReloadOptions{MinCount: 2}))
+		requireCaughtUp(t)
+
+		port, err := followerServices[1].GetPort(ctx, "GBLON")
+		assert.NoError(t, err)
+		assert.Equal(t, "City of London", port.Name)
+	})
+
+	t.Run("Followers reject the writes", func(t *testing.T) {
+		followerDatasets := service.NewDatasets("ports.json", followerServices[0])
+		follower := httptest.NewServer(httpserver.NewHTTPServer(followerDatasets).Handler())
+		defer follower.Close()
+
+		req, err := http.NewRequest(http.MethodPut, follower.URL+"/ports/NLRTM", strings.NewReader(`{}`))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, errs.ErrFollowerReadOnly.Error(), body["error"])
+		assert.Equal(t, leader.URL, body["leader"])
+
+		assert.ErrorIs(t, followerServices[0].DeletePort(ctx, "FRPAR"), errs.ErrFollowerReadOnly)
+		_, err = followerServices[0].RestorePort(ctx, "GBLON")
+		assert.ErrorIs(t, err, errs.ErrFollowerReadOnly)
+	})
+
+	t.Run("Followers report their lag", func(t *testing.T) {
+		resp, err := http.Get(leader.URL + "/replication/status")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var leaderStatus service.ReplicationStatus
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&leaderStatus))
+		assert.Equal(t, service.RoleLeader, leaderStatus.Role)
+
+		assert.Eventually(t, func() bool {
+			status, err := followerServices[0].ReplicationStatus(ctx)
+			assert.NoError(t, err)
+			return status.Connected && status.Lag == 0 && status.LeaderVersion == leaderStatus.Version
+		}, 5*time.Second, 10*time.Millisecond)
+
+		status, err := followerServices[0].ReplicationStatus(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, service.RoleFollower, status.Role)
+		assert.Equal(t, leader.URL, status.Leader)
+		assert.Equal(t, leaderStatus.Version, status.Version)
+		assert.NotNil(t, status.LastContact)
+	})
+
+	t.Run("Changes ahead of the leader are expired", func(t *testing.T) {
+		resp, err := http.Get(leader.URL + "/replication/changes?after=1000000")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		resp, err = http.Get(leader.URL + "/replication/changes?after=latest")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+// endlessSource is a port source that sends ports until its context is done, then closes stopped.
+type endlessSource struct {
+	stopped chan struct{}
+}
+
+func (src *endlessSource) Name() string {
+	return "endless"
+}
+
+func (src *endlessSource) Metadata() map[string]string {
+	return nil
+}
+
+func (src *endlessSource) ReadPorts(ctx context.Context) (<-chan *model.Port, <-chan error) {
+	portsCh := make(chan *model.Port)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(src.stopped)
+		defer close(errCh)
+		defer close(portsCh)
+
+		for i := 0; ; i++ {
+			port := getGBLON()
+			port.ID = fmt.Sprintf("P%05d", i)
+			select {
+			case portsCh <- port:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return portsCh, errCh
+}
+
+// failingRepository is a port repository whose batch writes fail.
+type failingRepository struct {
+	repository.PortRepository
+}
+
+func (r *failingRepository) UpsertMany(context.Context, []*model.Port) error {
+	return errs.ErrInvalidInput
+}
+
+// contendedRepository is a port repository whose conditional writes always find another version,
+// as if the port was changed right after every read.
+type contendedRepository struct {
+	repository.PortRepository
+	attempts int
+}
+
+func (r *contendedRepository) UpsertIfVersion(context.Context, *model.Port, uint64) (uint64, error) {
+	r.attempts++
+	return 0, errs.ErrVersionMismatch
+}
+
+// writePortsFile writes the ports to a JSON file in the format of ports.json and returns its path.
+func writePortsFile(t *testing.T, ports []*model.Port) string {
+	t.Helper()
+
+	byID := make(map[string]*model.Port, len(ports))
+	for _, port := range ports {
+		byID[port.ID] = port
+	}
+	data, err := json.Marshal(byID)
+	if err != nil {
+		t.Fatalf("failed to marshal ports: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ports.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write ports file: %v", err)
+	}
+
+	return path
+}
+
 func getGBLON() *model.Port {
 	return &model.Port{
 		ID:          "GBLON",